@@ -1,4 +1,4 @@
 #!/usr/bin/env bash
 p=github.com/ziutek/mymysql
 
-go $* $p/mysql $p/native $p/thrsafe $p/autorc $p/godrv
+go $* $p/mysql $p/mysqltest $p/native $p/pool $p/replication $p/mysqltrace $p/mysqlmetrics/... $p/thrsafe $p/autorc $p/godrv
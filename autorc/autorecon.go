@@ -2,6 +2,8 @@
 package autorc
 
 import (
+	"context"
+	"errors"
 	"io"
 	"log"
 	"net"
@@ -10,8 +12,14 @@ import (
 	"github.com/ziutek/mymysql/mysql"
 )
 
-// IsNetErr returns true if error is network error or UnexpectedEOF.
+// IsNetErr returns true if error is network error or UnexpectedEOF. Context
+// errors (context.Canceled, context.DeadlineExceeded) aren't network errors
+// even if they implement net.Error.
 func IsNetErr(err error) bool {
+	if errors.Is(err, context.Canceled) ||
+		errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
 	if err == io.ErrUnexpectedEOF {
 		return true
 	}
@@ -40,11 +48,28 @@ type Conn struct {
 	Raw mysql.Conn
 	// Maximum reconnect retries.
 	// Default is 7 which means 1+2+3+4+5+6+7 = 28 seconds before return error
-	// (if waiting for error takes no time).
+	// (if waiting for error takes no time). Used only if Retry is nil.
 	MaxRetries int
 
+	// Retry policy for retryable errors (see IsRetryableErr). If nil the
+	// linear policy described above is used.
+	Retry RetryPolicy
+
 	// Debug logging. You may change it at any time.
 	Debug bool
+
+	// Multi-host configuration (see NewMulti)
+	Balance      Balance       // Replica selection for ReadOnly
+	HostDownTime time.Duration // Time a failed replica isn't used (default 10s)
+
+	hosts    []string
+	primary  int        // Index of the current primary in hosts
+	replicas []*replica // Lazily created connections to hosts
+	next     int        // Next replica for round-robin
+	init     []string   // Initialisation commands for replicas
+	rep      *replica   // Not nil for the connection to replica
+
+	metrics mysql.Metrics
 }
 
 // New creates a new autoreconnecting connection.
@@ -62,57 +87,152 @@ func NewFromCF(cfgFile string) (*Conn, map[string]string, error) {
 	if err != nil {
 		return nil, nil, err
 	}
-	return &Conn{raw, 7, false}, unk, nil
+	return &Conn{Raw: raw, MaxRetries: 7}, unk, nil
 }
 
 // Clone makes a copy of the connection.
 func (c *Conn) Clone() *Conn {
 	return &Conn{
-		Raw:        c.Raw.Clone(),
-		MaxRetries: c.MaxRetries,
-		Debug:      c.Debug,
+		Raw:          c.Raw.Clone(),
+		MaxRetries:   c.MaxRetries,
+		Retry:        c.Retry,
+		Debug:        c.Debug,
+		Balance:      c.Balance,
+		HostDownTime: c.HostDownTime,
+		hosts:        c.hosts,
+		primary:      c.primary,
+		init:         append([]string(nil), c.init...),
+		metrics:      c.metrics,
 	}
 }
 
 // SetTimeout sets a timeout for underlying mysql.Conn connection.
 func (c *Conn) SetTimeout(timeout time.Duration) {
 	c.Raw.SetTimeout(timeout)
+	for _, r := range c.replicas {
+		if r != nil {
+			r.conn.Raw.SetTimeout(timeout)
+		}
+	}
+}
+
+// SetMetrics sets the metrics collector for underlying mysql.Conn connections
+// (see mysql.Metrics). Additionally operations repeated after retryable errors
+// are counted in mysql.Retries.
+func (c *Conn) SetMetrics(m mysql.Metrics) {
+	c.metrics = m
+	c.Raw.SetMetrics(m)
+	for _, r := range c.replicas {
+		if r != nil {
+			r.conn.metrics = m
+			r.conn.Raw.SetMetrics(m)
+		}
+	}
 }
 
-func (c *Conn) reconnectIfNetErr(nn *int, err *error) {
-	for *err != nil && IsNetErr(*err) && *nn <= c.MaxRetries {
+// retries holds the state of retried operation.
+type retries struct {
+	n     int       // Number of previous retries
+	start time.Time // Time of the first failure
+	tx    bool      // The operation is the whole transaction (see Begin)
+}
+
+// retry asks the retry policy if the operation that failed with err should be
+// retried. If so, it waits and reconnects if the error requires it. On return
+// err is nil if the operation should be repeated.
+func (c *Conn) retry(ctx context.Context, nn *retries, err *error) {
+	if *err == nil {
+		return
+	}
+	if nn.start.IsZero() {
+		nn.start = time.Now()
+	}
+	ctx = context.WithValue(ctx, retryStartKey{}, nn.start)
+	for *err != nil && c.isRetryable(nn, *err) {
+		policy := c.Retry
+		if policy == nil {
+			policy = linear(c.MaxRetries)
+		}
+		wait, ok := policy.Retry(ctx, *err, nn.n+1)
+		if !ok {
+			break
+		}
+		reconnect := c.isFailoverErr(*err)
 		if c.Debug {
-			log.Printf("Error: '%s' - reconnecting...", *err)
+			if reconnect {
+				log.Printf("Error: '%s' - reconnecting...", *err)
+			} else {
+				log.Printf("Error: '%s' - retrying...", *err)
+			}
+		}
+		if wait > 0 {
+			t := time.NewTimer(wait)
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				t.Stop()
+				*err = ctx.Err()
+				return
+			}
+		}
+		nn.n++
+		if c.metrics != nil {
+			c.metrics.Add(mysql.Retries, 1)
 		}
-		time.Sleep(time.Second * time.Duration(*nn))
-		*err = c.Raw.Reconnect()
+		if !reconnect {
+			*err = nil
+			return
+		}
+		*err = c.reconnect()
 		if c.Debug && *err != nil {
 			log.Println("Can't reconnect:", *err)
 		}
-		*nn++
+	}
+	if *err != nil && c.rep != nil && IsNetErr(*err) {
+		c.rep.setDown(c.HostDownTime)
 	}
 }
 
-func (c *Conn) connectIfNotConnected() (err error) {
+func (c *Conn) connectIfNotConnected(ctx context.Context) (err error) {
 	if c.Raw.IsConnected() {
 		return
 	}
-	err = c.Raw.Connect()
-	nn := 0
-	c.reconnectIfNetErr(&nn, &err)
+	if c.hosts != nil {
+		err = c.failover()
+	} else {
+		err = c.Raw.Connect()
+	}
+	var nn retries
+	c.retry(ctx, &nn, &err)
 	return
 }
 
-// Reconnect tries to reconnect the connection up to MaxRetries times.
-func (c *Conn) Reconnect() (err error) {
-	err = c.Raw.Reconnect()
-	nn := 0
-	c.reconnectIfNetErr(&nn, &err)
+// Reconnect tries to reconnect the connection using the retry policy.
+func (c *Conn) Reconnect() error {
+	return c.ReconnectContext(context.Background())
+}
+
+// ReconnectContext works like Reconnect but waiting for the next attempt can
+// be cancelled using ctx.
+func (c *Conn) ReconnectContext(ctx context.Context) (err error) {
+	err = c.reconnect()
+	var nn retries
+	c.retry(ctx, &nn, &err)
 	return
 }
 
+// Register registers the initialization command (see mysql.Conn.Register).
+// A connection created by NewMulti registers it for all hosts.
 func (c *Conn) Register(sql string) {
 	c.Raw.Register(sql)
+	if c.hosts != nil {
+		c.init = append(c.init, sql)
+		for _, r := range c.replicas {
+			if r != nil {
+				r.conn.Raw.Register(sql)
+			}
+		}
+	}
 }
 
 func (c *Conn) SetMaxPktSize(new_size int) int {
@@ -121,15 +241,15 @@ func (c *Conn) SetMaxPktSize(new_size int) int {
 
 // Use is an automatic connect/reconnect/repeat version of mysql.Conn.Use.
 func (c *Conn) Use(dbname string) (err error) {
-	if err = c.connectIfNotConnected(); err != nil {
+	if err = c.connectIfNotConnected(context.Background()); err != nil {
 		return
 	}
-	nn := 0
+	var nn retries
 	for {
 		if err = c.Raw.Use(dbname); err == nil {
 			return
 		}
-		if c.reconnectIfNetErr(&nn, &err); err != nil {
+		if c.retry(context.Background(), &nn, &err); err != nil {
 			return
 		}
 	}
@@ -139,15 +259,36 @@ func (c *Conn) Use(dbname string) (err error) {
 // Query is an automatic connect/reconnect/repeat version of mysql.Conn.Query.
 func (c *Conn) Query(sql string, params ...interface{}) (rows []mysql.Row, res mysql.Result, err error) {
 
-	if err = c.connectIfNotConnected(); err != nil {
+	if err = c.connectIfNotConnected(context.Background()); err != nil {
 		return
 	}
-	nn := 0
+	var nn retries
 	for {
 		if rows, res, err = c.Raw.Query(sql, params...); err == nil {
 			return
 		}
-		if c.reconnectIfNetErr(&nn, &err); err != nil {
+		if c.retry(context.Background(), &nn, &err); err != nil {
+			return
+		}
+	}
+	panic(nil)
+}
+
+// QueryContext works like Query but ctx is passed to mysql.Conn.StartContext
+// and cancels waiting for the next retry.
+func (c *Conn) QueryContext(ctx context.Context, sql string, params ...interface{}) (rows []mysql.Row, res mysql.Result, err error) {
+
+	if err = c.connectIfNotConnected(ctx); err != nil {
+		return
+	}
+	var nn retries
+	for {
+		if res, err = c.Raw.StartContext(ctx, sql, params...); err == nil {
+			if rows, err = mysql.GetRows(res); err == nil {
+				return
+			}
+		}
+		if c.retry(ctx, &nn, &err); err != nil {
 			return
 		}
 	}
@@ -157,15 +298,15 @@ func (c *Conn) Query(sql string, params ...interface{}) (rows []mysql.Row, res m
 // QueryFirst is an automatic connect/reconnect/repeat version of mysql.Conn.QueryFirst.
 func (c *Conn) QueryFirst(sql string, params ...interface{}) (row mysql.Row, res mysql.Result, err error) {
 
-	if err = c.connectIfNotConnected(); err != nil {
+	if err = c.connectIfNotConnected(context.Background()); err != nil {
 		return
 	}
-	nn := 0
+	var nn retries
 	for {
 		if row, res, err = c.Raw.QueryFirst(sql, params...); err == nil {
 			return
 		}
-		if c.reconnectIfNetErr(&nn, &err); err != nil {
+		if c.retry(context.Background(), &nn, &err); err != nil {
 			return
 		}
 	}
@@ -175,15 +316,15 @@ func (c *Conn) QueryFirst(sql string, params ...interface{}) (row mysql.Row, res
 // QueryLast is an automatic connect/reconnect/repeat version of mysql.Conn.QueryLast.
 func (c *Conn) QueryLast(sql string, params ...interface{}) (row mysql.Row, res mysql.Result, err error) {
 
-	if err = c.connectIfNotConnected(); err != nil {
+	if err = c.connectIfNotConnected(context.Background()); err != nil {
 		return
 	}
-	nn := 0
+	var nn retries
 	for {
 		if row, res, err = c.Raw.QueryLast(sql, params...); err == nil {
 			return
 		}
-		if c.reconnectIfNetErr(&nn, &err); err != nil {
+		if c.retry(context.Background(), &nn, &err); err != nil {
 			return
 		}
 	}
@@ -208,17 +349,17 @@ func (c *Conn) PrepareOnce(s *Stmt, sql string) error {
 	if s.Raw != nil {
 		return nil
 	}
-	if err := c.connectIfNotConnected(); err != nil {
+	if err := c.connectIfNotConnected(context.Background()); err != nil {
 		return err
 	}
-	nn := 0
+	var nn retries
 	for {
 		var err error
 		if s.Raw, err = c.Raw.Prepare(sql); err == nil {
 			s.con = c
 			return nil
 		}
-		if c.reconnectIfNetErr(&nn, &err); err != nil {
+		if c.retry(context.Background(), &nn, &err); err != nil {
 			return err
 		}
 	}
@@ -243,15 +384,15 @@ func (c *Conn) reprepare(stmt *Stmt) error {
 }
 
 // Begin starts a transaction and calls f to complete it.
-// If f returns an error and IsNetErr(error) == true it reconnects and calls
-// f up to MaxRetries times. If error is of type *mysql.Error it tries to rollback
-// the transaction.
+// If f returns an error of type *mysql.Error it tries to rollback the
+// transaction. If the error is retryable (see IsRetryableErr) it reconnects if
+// needed and calls f again according to the retry policy.
 func (c *Conn) Begin(f func(mysql.Transaction, ...interface{}) error, args ...interface{}) error {
-	err := c.connectIfNotConnected()
+	err := c.connectIfNotConnected(context.Background())
 	if err != nil {
 		return err
 	}
-	nn := 0
+	nn := retries{tx: true}
 	for {
 		var tr mysql.Transaction
 		if tr, err = c.Raw.Begin(); err == nil {
@@ -259,10 +400,10 @@ func (c *Conn) Begin(f func(mysql.Transaction, ...interface{}) error, args ...in
 				return nil
 			}
 		}
-		if c.reconnectIfNetErr(&nn, &err); err != nil {
-			if _, ok := err.(*mysql.Error); ok && tr.IsValid() {
-				tr.Rollback()
-			}
+		if _, ok := err.(*mysql.Error); ok && tr != nil && tr.IsValid() {
+			tr.Rollback()
+		}
+		if c.retry(context.Background(), &nn, &err); err != nil {
 			return err
 		}
 	}
@@ -287,10 +428,10 @@ func (s *Stmt) needsRepreparing(err error) bool {
 // Exec is an automatic connect/reconnect/repeat version of mysql.Stmt.Exec.
 func (s *Stmt) Exec(params ...interface{}) (rows []mysql.Row, res mysql.Result, err error) {
 
-	if err = s.con.connectIfNotConnected(); err != nil {
+	if err = s.con.connectIfNotConnected(context.Background()); err != nil {
 		return
 	}
-	nn := 0
+	var nn retries
 	for {
 		if rows, res, err = s.Raw.Exec(params...); err == nil {
 			return
@@ -305,7 +446,38 @@ func (s *Stmt) Exec(params ...interface{}) (rows []mysql.Row, res mysql.Result,
 			continue
 		}
 
-		if s.con.reconnectIfNetErr(&nn, &err); err != nil {
+		if s.con.retry(context.Background(), &nn, &err); err != nil {
+			return
+		}
+	}
+	panic(nil)
+}
+
+// ExecContext works like Exec but ctx is passed to mysql.Stmt.RunContext and
+// cancels waiting for the next retry.
+func (s *Stmt) ExecContext(ctx context.Context, params ...interface{}) (rows []mysql.Row, res mysql.Result, err error) {
+
+	if err = s.con.connectIfNotConnected(ctx); err != nil {
+		return
+	}
+	var nn retries
+	for {
+		if res, err = s.Raw.RunContext(ctx, params...); err == nil {
+			if rows, err = mysql.GetRows(res); err == nil {
+				return
+			}
+		}
+
+		if s.needsRepreparing(err) {
+			if s.con.reprepare(s) != nil {
+				return
+			}
+
+			// Try again
+			continue
+		}
+
+		if s.con.retry(ctx, &nn, &err); err != nil {
 			return
 		}
 	}
@@ -315,10 +487,10 @@ func (s *Stmt) Exec(params ...interface{}) (rows []mysql.Row, res mysql.Result,
 // ExecFirst is an automatic connect/reconnect/repeat version of mysql.Stmt.ExecFirst.
 func (s *Stmt) ExecFirst(params ...interface{}) (row mysql.Row, res mysql.Result, err error) {
 
-	if err = s.con.connectIfNotConnected(); err != nil {
+	if err = s.con.connectIfNotConnected(context.Background()); err != nil {
 		return
 	}
-	nn := 0
+	var nn retries
 	for {
 		if row, res, err = s.Raw.ExecFirst(params...); err == nil {
 			return
@@ -333,7 +505,7 @@ func (s *Stmt) ExecFirst(params ...interface{}) (row mysql.Row, res mysql.Result
 			continue
 		}
 
-		if s.con.reconnectIfNetErr(&nn, &err); err != nil {
+		if s.con.retry(context.Background(), &nn, &err); err != nil {
 			return
 		}
 	}
@@ -343,10 +515,10 @@ func (s *Stmt) ExecFirst(params ...interface{}) (row mysql.Row, res mysql.Result
 // ExecLast is an automatic connect/reconnect/repeat version of mysql.Stmt.ExecLast.
 func (s *Stmt) ExecLast(params ...interface{}) (row mysql.Row, res mysql.Result, err error) {
 
-	if err = s.con.connectIfNotConnected(); err != nil {
+	if err = s.con.connectIfNotConnected(context.Background()); err != nil {
 		return
 	}
-	nn := 0
+	var nn retries
 	for {
 		if row, res, err = s.Raw.ExecLast(params...); err == nil {
 			return
@@ -361,7 +533,7 @@ func (s *Stmt) ExecLast(params ...interface{}) (row mysql.Row, res mysql.Result,
 			continue
 		}
 
-		if s.con.reconnectIfNetErr(&nn, &err); err != nil {
+		if s.con.retry(context.Background(), &nn, &err); err != nil {
 			return
 		}
 	}
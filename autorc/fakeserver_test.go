@@ -0,0 +1,303 @@
+package autorc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ziutek/mymysql/mysql"
+	"github.com/ziutek/mymysql/mysqlmetrics"
+	"github.com/ziutek/mymysql/mysqltest"
+)
+
+// Tests that use the fake server, so they don't need a MySQL server.
+
+func startFake(t *testing.T, read_only int) *mysqltest.Server {
+	srv := &mysqltest.Server{User: user, Passwd: passwd}
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	setReadOnly(srv, read_only)
+	srv.HandleQuery("SELECT 1", mysqltest.Rows("1").AddRow(1))
+	return srv
+}
+
+func setReadOnly(srv *mysqltest.Server, read_only int) {
+	srv.HandleQuery("SELECT @@global.read_only",
+		mysqltest.Rows("@@global.read_only").AddRow(read_only))
+	if read_only == 0 {
+		srv.HandleQuery("INSERT t VALUES (1)", &mysqltest.OK{AffectedRows: 1})
+	} else {
+		srv.HandleQuery("INSERT t VALUES (1)", mysqltest.Err(
+			mysql.ER_OPTION_PREVENTS_STATEMENT,
+			"The MySQL server is running with the --read-only option",
+		))
+	}
+}
+
+func countQuery(srv *mysqltest.Server, sql string) int {
+	n := 0
+	for _, q := range srv.Queries() {
+		if q == sql {
+			n++
+		}
+	}
+	return n
+}
+
+func TestFakeMultiHost(t *testing.T) {
+	s1 := startFake(t, 0)
+	defer s1.Close()
+	s2 := startFake(t, 1)
+	defer s2.Close()
+
+	c := NewMulti("tcp", "", []string{s1.Addr(), s2.Addr()}, user, passwd)
+	c.MaxRetries = 1
+	defer c.Close()
+
+	_, _, err := c.Query("SELECT 1")
+	checkErr(t, err, nil)
+	if c.Primary() != s1.Addr() {
+		t.Fatal("Bad primary:", c.Primary())
+	}
+	ro := c.ReadOnly()
+	_, _, err = ro.Query("SELECT 1")
+	checkErr(t, err, nil)
+	if ro == c || countQuery(s2, "SELECT 1") != 1 {
+		t.Fatal("Read-only query wasn't routed to the replica")
+	}
+
+	// Switchover: s1 is read-only, s2 is the new primary
+	setReadOnly(s1, 1)
+	setReadOnly(s2, 0)
+	_, _, err = c.Query("INSERT t VALUES (1)")
+	checkErr(t, err, nil)
+	if c.Primary() != s2.Addr() || countQuery(s2, "INSERT t VALUES (1)") != 1 {
+		t.Fatal("Primary change wasn't detected:", c.Primary())
+	}
+	if ro = c.ReadOnly(); ro.Raw.NetConn().RemoteAddr().String() != s1.Addr() {
+		t.Fatal("Old primary isn't used as replica")
+	}
+
+	// Failover: s2 is down, s1 is promoted
+	setReadOnly(s1, 0)
+	s2.Close()
+	_, _, err = c.Query("INSERT t VALUES (1)")
+	checkErr(t, err, nil)
+	if c.Primary() != s1.Addr() {
+		t.Fatal("Failover didn't happen:", c.Primary())
+	}
+	// Query on failed replica returns error and the replica isn't used
+	// anymore, so primary is used for reads.
+	if _, _, err = c.ReadOnly().Query("SELECT 1"); err == nil {
+		t.Fatal("Query on failed replica succeeded")
+	}
+	if c.ReadOnly() != c {
+		t.Fatal("Unavailable replica was returned")
+	}
+}
+
+func TestNewMultiNoHosts(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("NewMulti didn't panic")
+		}
+	}()
+	NewMulti("tcp", "", nil, user, passwd)
+}
+
+func TestFakeLeastLatency(t *testing.T) {
+	s1 := startFake(t, 0)
+	defer s1.Close()
+	s2 := startFake(t, 1)
+	defer s2.Close()
+	s3 := startFake(t, 1)
+	defer s3.Close()
+
+	c := NewMulti("tcp", "", []string{s1.Addr(), s2.Addr(), s3.Addr()},
+		user, passwd)
+	c.Balance = LeastLatency
+	defer c.Close()
+	checkErr(t, c.Reconnect(), nil)
+	s2.HandleCommand(mysqltest.COM_PING,
+		func(cmd *mysqltest.Command) []mysqltest.Result {
+			return []mysqltest.Result{
+				mysqltest.Delay(20 * time.Millisecond), &mysqltest.OK{},
+			}
+		},
+	)
+	for i := 0; i < 3; i++ {
+		ro := c.ReadOnly()
+		if ro.Raw.NetConn().RemoteAddr().String() != s3.Addr() {
+			t.Fatal("The fastest replica wasn't selected")
+		}
+	}
+}
+
+// failOnce returns a handler that returns the error code for the first n
+// calls and OK after that.
+func failOnce(n int, code uint16) mysqltest.HandlerFunc {
+	return func(cmd *mysqltest.Command) []mysqltest.Result {
+		if n > 0 {
+			n--
+			return []mysqltest.Result{mysqltest.Err(code, "lock error")}
+		}
+		return []mysqltest.Result{&mysqltest.OK{AffectedRows: 1}}
+	}
+}
+
+func TestFakeRetry(t *testing.T) {
+	srv := startFake(t, 0)
+	defer srv.Close()
+	for _, sql := range []string{"START TRANSACTION", "COMMIT", "ROLLBACK"} {
+		srv.HandleQuery(sql, &mysqltest.OK{})
+	}
+	c := New("tcp", "", srv.Addr(), user, passwd)
+	c.Retry = ExpBackoff{MaxRetries: 3, Base: time.Millisecond}
+	m := mysqlmetrics.New()
+	c.SetMetrics(m)
+	defer c.Close()
+
+	srv.HandleFunc("UPDATE t SET a = 1", failOnce(2, mysql.ER_LOCK_DEADLOCK))
+	_, res, err := c.Query("UPDATE t SET a = 1")
+	checkErr(t, err, nil)
+	if res.AffectedRows() != 1 || countQuery(srv, "UPDATE t SET a = 1") != 3 {
+		t.Fatal("Deadlock wasn't retried")
+	}
+	if s := m.Snapshot(); s.Counters[mysql.Retries] != 2 ||
+		s.Errors[mysql.ER_LOCK_DEADLOCK] != 2 {
+		t.Fatalf("Bad metrics: %v %v", s.Counters, s.Errors)
+	}
+
+	// Transaction is rolled back before retry
+	srv.Reset()
+	srv.HandleFunc("UPDATE t SET a = 2", failOnce(1, mysql.ER_LOCK_WAIT_TIMEOUT))
+	calls := 0
+	err = c.Begin(func(tr mysql.Transaction, args ...interface{}) error {
+		calls++
+		if _, err := tr.Start("UPDATE t SET a = 2"); err != nil {
+			return err
+		}
+		return tr.Commit()
+	})
+	checkErr(t, err, nil)
+	if calls != 2 || countQuery(srv, "ROLLBACK") != 1 ||
+		countQuery(srv, "COMMIT") != 1 {
+		t.Fatalf("Bad transaction retry: %d %q", calls, srv.Queries())
+	}
+
+	// Deadlock inside a transaction: the server rolled back the transaction
+	// so the statement can't be repeated alone
+	srv.Reset()
+	srv.HandleQuery("BEGIN", &mysqltest.OK{
+		Status: mysql.SERVER_STATUS_IN_TRANS | mysql.SERVER_STATUS_AUTOCOMMIT,
+	})
+	srv.HandleFunc("UPDATE t SET a = 5", failOnce(1, mysql.ER_LOCK_DEADLOCK))
+	_, _, err = c.Query("BEGIN")
+	checkErr(t, err, nil)
+	_, _, err = c.Query("UPDATE t SET a = 5")
+	if !mysql.IsDeadlock(err) || countQuery(srv, "UPDATE t SET a = 5") != 1 {
+		t.Fatalf("Deadlock in transaction was retried: %v %q", err, srv.Queries())
+	}
+	_, _, err = c.Query("ROLLBACK")
+	checkErr(t, err, nil)
+
+	// Other server errors aren't retried
+	srv.HandleFunc("UPDATE t SET a = 3", failOnce(1, mysql.ER_DUP_ENTRY))
+	_, _, err = c.Query("UPDATE t SET a = 3")
+	if e, ok := err.(*mysql.Error); !ok || e.Code != mysql.ER_DUP_ENTRY {
+		t.Fatal("Bad error:", err)
+	}
+
+	// Policy refuses
+	c.Retry = RetryPolicyFunc(
+		func(ctx context.Context, err error, attempt int) (time.Duration, bool) {
+			return 0, false
+		},
+	)
+	srv.HandleFunc("UPDATE t SET a = 4", failOnce(1, mysql.ER_LOCK_DEADLOCK))
+	_, _, err = c.Query("UPDATE t SET a = 4")
+	if e, ok := err.(*mysql.Error); !ok || e.Code != mysql.ER_LOCK_DEADLOCK {
+		t.Fatal("Bad error:", err)
+	}
+}
+
+func TestFakeRetryDeadline(t *testing.T) {
+	srv := startFake(t, 0)
+	defer srv.Close()
+	c := New("tcp", "", srv.Addr(), user, passwd)
+	defer c.Close()
+
+	// Waiting is cancelled by context
+	c.Retry = ExpBackoff{Base: time.Hour, Max: time.Hour}
+	srv.HandleFunc("UPDATE t SET a = 1", failOnce(1, mysql.ER_LOCK_DEADLOCK))
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, _, err := c.QueryContext(ctx, "UPDATE t SET a = 1")
+	checkErr(t, err, context.DeadlineExceeded)
+
+	// Caller's deadline isn't a network error: no reconnect nor retry
+	c.Retry = nil
+	srv.HandleQuery("SELECT SLEEP(1)", mysqltest.Delay(time.Second),
+		mysqltest.Rows("SLEEP(1)").AddRow(0))
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, _, err = c.QueryContext(ctx, "SELECT SLEEP(1)")
+	if !errors.Is(err, context.DeadlineExceeded) || time.Since(start) > 900*time.Millisecond {
+		t.Fatal("Bad error:", err, time.Since(start))
+	}
+	if IsNetErr(context.DeadlineExceeded) || IsRetryableErr(context.Canceled) {
+		t.Fatal("Context error is retryable")
+	}
+
+	// Deadline policy doesn't start waiting that would exceed the deadline
+	c.Retry = Deadline{Policy: ExpBackoff{Base: time.Hour, Max: time.Hour}}
+	srv.HandleFunc("UPDATE t SET a = 2", failOnce(1, mysql.ER_LOCK_DEADLOCK))
+	ctx, cancel = context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	start = time.Now()
+	_, _, err = c.QueryContext(ctx, "UPDATE t SET a = 2")
+	if e, ok := err.(*mysql.Error); !ok || e.Code != mysql.ER_LOCK_DEADLOCK ||
+		time.Since(start) > time.Second {
+		t.Fatal("Bad error:", err)
+	}
+
+	// MaxElapsed
+	c.Retry = Deadline{
+		Policy:     ExpBackoff{Base: 10 * time.Millisecond, Max: 10 * time.Millisecond},
+		MaxElapsed: 50 * time.Millisecond,
+	}
+	srv.HandleFunc("UPDATE t SET a = 3", failOnce(100, mysql.ER_LOCK_DEADLOCK))
+	_, _, err = c.Query("UPDATE t SET a = 3")
+	if e, ok := err.(*mysql.Error); !ok || e.Code != mysql.ER_LOCK_DEADLOCK {
+		t.Fatal("Bad error:", err)
+	}
+	if n := countQuery(srv, "UPDATE t SET a = 3"); n < 2 || n > 50 {
+		t.Fatal("Bad number of attempts:", n)
+	}
+}
+
+func TestFakeCredentialProvider(t *testing.T) {
+	srv := startFake(t, 0)
+	defer srv.Close()
+
+	calls := 0
+	c := New("tcp", "", srv.Addr(), "", "")
+	c.Raw.SetCredentialProvider(func() (string, string, error) {
+		calls++
+		return user, passwd, nil
+	})
+	defer c.Close()
+
+	_, _, err := c.Query("SELECT 1")
+	checkErr(t, err, nil)
+	// Connection lost: autorc reconnects with fresh credentials
+	c.Raw.NetConn().Close()
+	_, _, err = c.Query("SELECT 1")
+	checkErr(t, err, nil)
+	if calls != 2 {
+		t.Fatal("Bad number of provider calls:", calls)
+	}
+}
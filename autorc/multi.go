@@ -0,0 +1,208 @@
+package autorc
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/ziutek/mymysql/mysql"
+)
+
+// ErrNoPrimary is returned if none of the hosts of multi-host connection is
+// writable.
+var ErrNoPrimary = errors.New("autorc: no writable (primary) host")
+
+// Balance specifies how ReadOnly selects the replica.
+type Balance int
+
+// Replica selection methods
+const (
+	RoundRobin   Balance = iota // Next available replica
+	LeastLatency                // Replica with the lowest Ping time
+)
+
+// Latency of replica is measured again after this time.
+const latencyCheck = 10 * time.Second
+
+type replica struct {
+	conn    *Conn
+	down    time.Time // Don't use before this time
+	latency time.Duration
+	checked time.Time // Last latency measurement
+}
+
+func (r *replica) setDown(d time.Duration) {
+	if d <= 0 {
+		d = 10 * time.Second
+	}
+	r.down = time.Now().Add(d)
+	r.checked = time.Time{}
+}
+
+// NewMulti creates a new autoreconnecting connection that uses multiple
+// servers. The primary is the first writable host (@@global.read_only = 0)
+// starting from hosts[0]. If the primary fails (network error or
+// ER_OPTION_PREVENTS_STATEMENT after it was made read-only) the connection
+// fails over to the next writable host. Other hosts are replicas that can be
+// used for read-only statements (see ReadOnly).
+//
+// Configure the connection using Raw before the first use. All hosts use the
+// same configuration, but initialization commands must be registered using
+// Conn.Register: replicas don't run commands registered using Raw.Register.
+// NewMulti panics if hosts is empty.
+func NewMulti(proto, laddr string, hosts []string, user, passwd string, db ...string) *Conn {
+	if len(hosts) == 0 {
+		panic("autorc.NewMulti: no hosts")
+	}
+	c := New(proto, laddr, hosts[0], user, passwd, db...)
+	c.hosts = hosts
+	return c
+}
+
+// Primary returns the address of the current primary of multi-host
+// connection (it can change after failover).
+func (c *Conn) Primary() string {
+	if c.hosts == nil {
+		return ""
+	}
+	return c.hosts[c.primary]
+}
+
+func (c *Conn) isFailoverErr(err error) bool {
+	if IsNetErr(err) {
+		return true
+	}
+	if c.hosts == nil {
+		return false
+	}
+	if err == ErrNoPrimary {
+		return true
+	}
+	e, ok := err.(*mysql.Error)
+	return ok && e.Code == mysql.ER_OPTION_PREVENTS_STATEMENT
+}
+
+func (c *Conn) reconnect() error {
+	if c.hosts == nil {
+		return c.Raw.Reconnect()
+	}
+	return c.failover()
+}
+
+// failover connects to the first writable host starting from the current
+// primary.
+func (c *Conn) failover() (err error) {
+	for i := range c.hosts {
+		n := (c.primary + i) % len(c.hosts)
+		c.Raw.SetRemoteAddr(c.hosts[n])
+		if err = c.Raw.Reconnect(); err != nil {
+			continue
+		}
+		var row mysql.Row
+		if row, _, err = c.Raw.QueryFirst("SELECT @@global.read_only"); err != nil {
+			continue
+		}
+		if row.ForceInt(0) != 0 {
+			err = ErrNoPrimary
+			continue
+		}
+		if n != c.primary {
+			if c.Debug {
+				log.Printf("Primary changed: %s -> %s", c.hosts[c.primary],
+					c.hosts[n])
+			}
+			c.primary = n
+		}
+		return nil
+	}
+	c.Raw.Close()
+	return
+}
+
+// ReadOnly returns a connection to the replica, selected using c.Balance, that
+// can be used for read-only statements. It returns c if c isn't a multi-host
+// connection or all replicas are unavailable. If a statement fails on the
+// replica because of network error (after one reconnect attempt) the error is
+// returned and the replica isn't used for HostDownTime.
+func (c *Conn) ReadOnly() *Conn {
+	if len(c.hosts) < 2 {
+		return c
+	}
+	if c.replicas == nil {
+		c.replicas = make([]*replica, len(c.hosts))
+	}
+	now := time.Now()
+	var best *replica
+	for i := 0; i < len(c.hosts); i++ {
+		n := (c.next + i) % len(c.hosts)
+		if n == c.primary {
+			continue
+		}
+		r := c.replicas[n]
+		if r == nil {
+			r = c.newReplica(c.hosts[n])
+			c.replicas[n] = r
+		}
+		if r.down.After(now) {
+			continue
+		}
+		if c.Balance == RoundRobin {
+			if r.conn.connectIfNotConnected(context.Background()) != nil {
+				continue
+			}
+			c.next = n + 1
+			return r.conn
+		}
+		if now.Sub(r.checked) >= latencyCheck {
+			err := r.conn.connectIfNotConnected(context.Background())
+			if err == nil {
+				start := time.Now()
+				if err = r.conn.Raw.Ping(); err == nil {
+					r.latency = time.Since(start)
+					r.checked = now
+				}
+			}
+			if err != nil {
+				r.setDown(c.HostDownTime)
+				continue
+			}
+		}
+		if best == nil || r.latency < best.latency {
+			best = r
+		}
+	}
+	if best == nil {
+		return c
+	}
+	return best.conn
+}
+
+// Close closes the connection and all connections to replicas.
+func (c *Conn) Close() (err error) {
+	for _, r := range c.replicas {
+		if r != nil && r.conn.Raw.IsConnected() {
+			r.conn.Raw.Close()
+		}
+	}
+	if c.Raw.IsConnected() {
+		err = c.Raw.Close()
+	}
+	return
+}
+
+func (c *Conn) newReplica(host string) *replica {
+	raw := c.Raw.Clone()
+	raw.SetRemoteAddr(host)
+	for _, sql := range c.init {
+		raw.Register(sql)
+	}
+	r := &replica{conn: &Conn{
+		Raw:          raw,
+		Debug:        c.Debug,
+		HostDownTime: c.HostDownTime,
+		metrics:      c.metrics,
+	}}
+	r.conn.rep = r
+	return r
+}
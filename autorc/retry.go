@@ -0,0 +1,125 @@
+package autorc
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/ziutek/mymysql/mysql"
+)
+
+// RetryPolicy decides whether an operation that failed with a retryable
+// error (see IsRetryableErr) should be retried and how long to wait before
+// the next attempt. attempt is the number of the retry (1 for the first one).
+// ctx is the context of the operation (context.Background() for methods
+// without the context argument), waiting is cancelled when ctx is done.
+type RetryPolicy interface {
+	Retry(ctx context.Context, err error, attempt int) (wait time.Duration, retry bool)
+}
+
+// RetryPolicyFunc is an adapter that allows to use ordinary function as
+// RetryPolicy.
+type RetryPolicyFunc func(ctx context.Context, err error, attempt int) (time.Duration, bool)
+
+func (f RetryPolicyFunc) Retry(ctx context.Context, err error, attempt int) (time.Duration, bool) {
+	return f(ctx, err, attempt)
+}
+
+// IsRetryableErr returns true if the operation that failed with err can be
+// repeated: err is a network error (see IsNetErr), a deadlock or a lock wait
+// timeout (see mysql.IsDeadlock and mysql.IsLockTimeout). Multi-host
+// connections also retry after ER_OPTION_PREVENTS_STATEMENT and ErrNoPrimary
+// (see NewMulti). Deadlocks and lock wait timeouts are retried only if the
+// connection isn't in a transaction (the server rolls back the whole
+// transaction after a deadlock, so repeating only the last statement would
+// run it without the previous ones) or by Begin, which repeats the whole
+// transaction.
+func IsRetryableErr(err error) bool {
+	return IsNetErr(err) || isLockErr(err)
+}
+
+func isLockErr(err error) bool {
+	return mysql.IsDeadlock(err) || mysql.IsLockTimeout(err)
+}
+
+// isRetryable reports whether the operation described by nn that failed with
+// err can be repeated.
+func (c *Conn) isRetryable(nn *retries, err error) bool {
+	if isLockErr(err) {
+		return nn.tx || c.Raw.Status()&mysql.SERVER_STATUS_IN_TRANS == 0
+	}
+	return IsNetErr(err) || c.isFailoverErr(err)
+}
+
+type retryStartKey struct{}
+
+// RetryStart returns the time of the first failure of the operation. It can
+// be used by RetryPolicy implementations to limit the total retry time.
+func RetryStart(ctx context.Context) time.Time {
+	t, _ := ctx.Value(retryStartKey{}).(time.Time)
+	return t
+}
+
+// linear is the default policy: it waits 0, 1, 2, ... seconds and gives up
+// after MaxRetries retries.
+type linear int
+
+func (max linear) Retry(ctx context.Context, err error, attempt int) (time.Duration, bool) {
+	return time.Duration(attempt-1) * time.Second, attempt <= int(max)+1
+}
+
+// ExpBackoff is a RetryPolicy that waits a random time from the range
+// [0, min(Max, Base * 2^(attempt-1))) (exponential backoff with full jitter).
+type ExpBackoff struct {
+	MaxRetries int           // Maximum number of retries (0 means no limit)
+	Base       time.Duration // Default 100 ms
+	Max        time.Duration // Default 10 s
+}
+
+func (p ExpBackoff) Retry(ctx context.Context, err error, attempt int) (time.Duration, bool) {
+	if p.MaxRetries > 0 && attempt > p.MaxRetries {
+		return 0, false
+	}
+	base, max := p.Base, p.Max
+	if base <= 0 {
+		base = 100 * time.Millisecond
+	}
+	if max <= 0 {
+		max = 10 * time.Second
+	}
+	d := max
+	if attempt <= 62 && base<<uint(attempt-1) < max && base<<uint(attempt-1) > 0 {
+		d = base << uint(attempt-1)
+	}
+	return time.Duration(rand.Int63n(int64(d)) + 1), true
+}
+
+// Deadline is a RetryPolicy that uses Policy (ExpBackoff{} if nil) but gives
+// up if the next attempt would start after the deadline of ctx or, if
+// MaxElapsed > 0, after MaxElapsed from the first failure.
+type Deadline struct {
+	Policy     RetryPolicy
+	MaxElapsed time.Duration
+}
+
+func (p Deadline) Retry(ctx context.Context, err error, attempt int) (time.Duration, bool) {
+	policy := p.Policy
+	if policy == nil {
+		policy = ExpBackoff{}
+	}
+	wait, ok := policy.Retry(ctx, err, attempt)
+	if !ok {
+		return 0, false
+	}
+	next := time.Now().Add(wait)
+	if dl, ok := ctx.Deadline(); ok && next.After(dl) {
+		return 0, false
+	}
+	if p.MaxElapsed > 0 {
+		if start := RetryStart(ctx); !start.IsZero() &&
+			next.Sub(start) > p.MaxElapsed {
+			return 0, false
+		}
+	}
+	return wait, true
+}
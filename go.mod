@@ -1,3 +1,5 @@
 module github.com/ziutek/mymysql
 
 go 1.16
+
+require filippo.io/edwards25519 v1.0.0
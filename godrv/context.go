@@ -0,0 +1,278 @@
+package godrv
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"time"
+)
+
+var (
+	_ driver.QueryerContext     = conn{}
+	_ driver.ExecerContext      = conn{}
+	_ driver.ConnPrepareContext = conn{}
+	_ driver.ConnBeginTx        = conn{}
+	_ driver.Pinger             = conn{}
+	_ driver.SessionResetter    = conn{}
+	_ driver.Validator          = conn{}
+	_ driver.StmtQueryContext   = (*stmt)(nil)
+	_ driver.StmtExecContext    = (*stmt)(nil)
+	_ driver.DriverContext      = (*Driver)(nil)
+	_ driver.Connector          = (*connector)(nil)
+)
+
+var errNamedArgs = errors.New("godrv: named arguments aren't supported")
+
+// aLongTimeAgo is a deadline that makes blocked network I/O return at once.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// watcher interrupts the network I/O of a connection when its context is done.
+type watcher struct {
+	nc        net.Conn
+	done      chan struct{}
+	cancelled chan bool
+}
+
+// watchContext starts watching ctx. If ctx is done before stop is called, the
+// deadline of nc is moved to the past, so blocked reads and writes fail. It
+// returns nil if ctx can't be cancelled.
+func watchContext(ctx context.Context, nc net.Conn) *watcher {
+	if ctx.Done() == nil || nc == nil {
+		return nil
+	}
+	w := &watcher{nc, make(chan struct{}), make(chan bool, 1)}
+	go func() {
+		select {
+		case <-w.done:
+			w.cancelled <- false
+		case <-ctx.Done():
+			nc.SetDeadline(aLongTimeAgo)
+			w.cancelled <- true
+		}
+	}()
+	return w
+}
+
+// stop stops watching and reports whether the context was done. In this case
+// the network connection is closed because the state of the protocol is
+// unknown (the next command fails and database/sql discards the connection).
+func (w *watcher) stop() bool {
+	if w == nil {
+		return false
+	}
+	close(w.done)
+	if !<-w.cancelled {
+		return false
+	}
+	w.nc.Close()
+	return true
+}
+
+func namedValues(named []driver.NamedValue) ([]driver.Value, error) {
+	args := make([]driver.Value, len(named))
+	for i, nv := range named {
+		if nv.Name != "" {
+			return nil, errNamedArgs
+		}
+		args[i] = nv.Value
+	}
+	return args, nil
+}
+
+// ExecContext implements driver.ExecerContext interface.
+func (c conn) ExecContext(ctx context.Context, query string, named []driver.NamedValue) (driver.Result, error) {
+	args, err := namedValues(named)
+	if err != nil {
+		return nil, err
+	}
+	q, err := c.parseQuery(query, args)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.my.StartContext(ctx, q)
+	if err != nil {
+		return nil, errFilter(err)
+	}
+	return &rowsRes{my: res}, nil
+}
+
+// QueryContext implements driver.QueryerContext interface.
+func (c conn) QueryContext(ctx context.Context, query string, named []driver.NamedValue) (driver.Rows, error) {
+	args, err := namedValues(named)
+	if err != nil {
+		return nil, err
+	}
+	q, err := c.parseQuery(query, args)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.my.StartContext(ctx, q)
+	if err != nil {
+		return nil, errFilter(err)
+	}
+	return &rowsRes{row: res.MakeRow(), my: res, simpleQuery: textQuery}, nil
+}
+
+// PrepareContext implements driver.ConnPrepareContext interface. If ctx is done
+// before the server response is read, the connection is closed and ctx.Err()
+// is returned.
+func (c conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	w := watchContext(ctx, c.my.NetConn())
+	st, err := c.Prepare(query)
+	if w.stop() {
+		return nil, ctx.Err()
+	}
+	return st, err
+}
+
+var isolationLevels = map[sql.IsolationLevel]string{
+	sql.LevelReadUncommitted: "READ UNCOMMITTED",
+	sql.LevelReadCommitted:   "READ COMMITTED",
+	sql.LevelRepeatableRead:  "REPEATABLE READ",
+	sql.LevelSerializable:    "SERIALIZABLE",
+}
+
+// BeginTx implements driver.ConnBeginTx interface. Isolation level and
+// read-only mode are set using SET TRANSACTION for the started transaction
+// only.
+func (c conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	var chars []string
+	if level := sql.IsolationLevel(opts.Isolation); level != sql.LevelDefault {
+		l, ok := isolationLevels[level]
+		if !ok {
+			return nil, errors.New("godrv: unsupported isolation level: " +
+				level.String())
+		}
+		chars = append(chars, "ISOLATION LEVEL "+l)
+	}
+	if opts.ReadOnly {
+		chars = append(chars, "READ ONLY")
+	}
+	if len(chars) != 0 {
+		q := "SET TRANSACTION " + chars[0]
+		if len(chars) > 1 {
+			q += ", " + chars[1]
+		}
+		if _, err := c.my.StartContext(ctx, q); err != nil {
+			return nil, errFilter(err)
+		}
+	} else if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.Begin()
+}
+
+// Ping implements driver.Pinger interface. If ctx is done before the server
+// response is read, the connection is closed and ctx.Err() is returned.
+func (c conn) Ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	w := watchContext(ctx, c.my.NetConn())
+	err := c.my.Ping()
+	if w.stop() {
+		return ctx.Err()
+	}
+	if err != nil {
+		return errFilter(err)
+	}
+	return nil
+}
+
+// ResetSession implements driver.SessionResetter interface. It resets the
+// session state (user variables, temporary tables, session variables) before
+// the connection is reused, so nothing leaks between users of the pool. The
+// connection is discarded if the reset fails.
+func (c conn) ResetSession(ctx context.Context) error {
+	if !c.IsValid() {
+		return driver.ErrBadConn
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := c.my.ResetSession(); err != nil {
+		return driver.ErrBadConn
+	}
+	return nil
+}
+
+// IsValid implements driver.Validator interface. It returns false if the
+// connection was closed, for example after a failed query cancellation.
+func (c conn) IsValid() bool {
+	return c.my != nil && c.my.IsConnected()
+}
+
+func (s *stmt) runContext(ctx context.Context, named []driver.NamedValue) (*rowsRes, error) {
+	for i, nv := range named {
+		if nv.Name != "" {
+			return nil, errNamedArgs
+		}
+		s.args[i] = interface{}(nv.Value)
+	}
+	res, err := s.my.RunContext(ctx, s.args...)
+	if err != nil {
+		return nil, errFilter(err)
+	}
+	return &rowsRes{my: res}, nil
+}
+
+// ExecContext implements driver.StmtExecContext interface.
+func (s *stmt) ExecContext(ctx context.Context, named []driver.NamedValue) (driver.Result, error) {
+	return s.runContext(ctx, named)
+}
+
+// QueryContext implements driver.StmtQueryContext interface.
+func (s *stmt) QueryContext(ctx context.Context, named []driver.NamedValue) (driver.Rows, error) {
+	r, err := s.runContext(ctx, named)
+	if err != nil {
+		return nil, err
+	}
+	r.row = r.my.MakeRow()
+	return r, nil
+}
+
+type connector struct {
+	drv *Driver
+	cfg *Driver
+}
+
+// OpenConnector implements driver.DriverContext interface. uri is parsed once
+// and has the same syntax as for Open.
+func (d *Driver) OpenConnector(uri string) (driver.Connector, error) {
+	cfg, err := d.parseURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	return &connector{drv: d, cfg: cfg}, nil
+}
+
+// Connect implements driver.Connector interface. If ctx has a deadline that
+// expires before the configured timeout, it is used as connect timeout. If ctx
+// is done during the handshake, the connection is closed and ctx.Err() is
+// returned.
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	timeout := c.cfg.timeout
+	if deadline, ok := ctx.Deadline(); ok {
+		if d := time.Until(deadline); timeout <= 0 || d < timeout {
+			timeout = d
+		}
+	}
+	cn, err := c.cfg.connect(ctx, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return cn, nil
+}
+
+// Driver implements driver.Connector interface.
+func (c *connector) Driver() driver.Driver {
+	return c.drv
+}
@@ -2,13 +2,13 @@
 package godrv
 
 import (
+	"context"
+	"crypto/tls"
 	"database/sql"
 	"database/sql/driver"
 	"errors"
-	"fmt"
 	"io"
 	"net"
-	"strconv"
 	"strings"
 	"time"
 
@@ -26,8 +26,15 @@ type rowsRes struct {
 	simpleQuery mysql.Stmt
 }
 
+// errFilter maps I/O errors to driver.ErrBadConn. Context errors are returned
+// unchanged: the connection is still usable (see RunContext) and
+// database/sql must not repeat the statement on another connection.
 func errFilter(err error) error {
-	if err == io.ErrUnexpectedEOF {
+	if errors.Is(err, context.Canceled) ||
+		errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	if err == io.ErrUnexpectedEOF || err == mysql.ErrNotConn {
 		return driver.ErrBadConn
 	}
 	if _, ok := err.(net.Error); ok {
@@ -36,63 +43,17 @@ func errFilter(err error) error {
 	return err
 }
 
-func join(a []string) string {
-	n := 0
-	for _, s := range a {
-		n += len(s)
-	}
-	b := make([]byte, n)
-	n = 0
-	for _, s := range a {
-		n += copy(b[n:], s)
-	}
-	return string(b)
-}
-
+// parseQuery replaces the ? placeholders in query with args using
+// mysql.Interpolate.
 func (c conn) parseQuery(query string, args []driver.Value) (string, error) {
 	if len(args) == 0 {
 		return query, nil
 	}
-	if strings.ContainsAny(query, `'"`) {
-		return "", nil
-	}
-	q := make([]string, 2*len(args)+1)
-	n := 0
-	for _, a := range args {
-		i := strings.IndexRune(query, '?')
-		if i == -1 {
-			return "", errors.New("number of parameters doesn't match number of placeholders")
-		}
-		var s string
-		switch v := a.(type) {
-		case nil:
-			s = "NULL"
-		case string:
-			s = "'" + c.my.Escape(v) + "'"
-		case []byte:
-			s = "'" + c.my.Escape(string(v)) + "'"
-		case int64:
-			s = strconv.FormatInt(v, 10)
-		case time.Time:
-			s = "'" + v.Format(mysql.TimeFormat) + "'"
-		case bool:
-			if v {
-				s = "1"
-			} else {
-				s = "0"
-			}
-		case float64:
-			s = strconv.FormatFloat(v, 'e', 12, 64)
-		default:
-			panic(fmt.Sprintf("%v (%T) can't be handled by godrv", v, v))
-		}
-		q[n] = query[:i]
-		q[n+1] = s
-		query = query[i+1:]
-		n += 2
+	params := make([]interface{}, len(args))
+	for i, a := range args {
+		params[i] = a
 	}
-	q[n] = query
-	return join(q), nil
+	return mysql.Interpolate(c.my, query, params...)
 }
 
 func (c conn) Exec(query string, args []driver.Value) (driver.Result, error) {
@@ -100,9 +61,6 @@ func (c conn) Exec(query string, args []driver.Value) (driver.Result, error) {
 	if err != nil {
 		return nil, err
 	}
-	if len(q) == 0 {
-		return nil, driver.ErrSkip
-	}
 	res, err := c.my.Start(q)
 	if err != nil {
 		return nil, errFilter(err)
@@ -117,9 +75,6 @@ func (c conn) Query(query string, args []driver.Value) (driver.Rows, error) {
 	if err != nil {
 		return nil, err
 	}
-	if len(q) == 0 {
-		return nil, driver.ErrSkip
-	}
 	res, err := c.my.Start(q)
 	if err != nil {
 		return nil, errFilter(err)
@@ -295,6 +250,14 @@ type Driver struct {
 	proto, laddr, raddr, user, passwd, db string
 	timeout                               time.Duration
 	dialer                                Dialer
+	credentials                           mysql.CredentialProvider
+	tlsConfig                             *tls.Config
+	tlsMode                               mysql.TLSMode
+	tlsModeSet                            bool // tlsMode was set in URI
+	compress                              string
+	decimalMode                           mysql.DecimalMode
+	tracer                                mysql.Tracer
+	metrics                               mysql.Metrics
 
 	initCmds []string
 }
@@ -316,12 +279,31 @@ type Driver struct {
 // OPTIONS can contain comma separated list of options in form:
 //   opt1=VAL1,opt2=VAL2,boolopt3,boolopt4
 // Currently implemented options, in addition to default MySQL variables:
-//   laddr   - local address/port (eg. 1.2.3.4:0)
-//   timeout - connect timeout in format accepted by time.ParseDuration
+//   laddr    - local address/port (eg. 1.2.3.4:0)
+//   timeout  - connect timeout in format accepted by time.ParseDuration
+//   tls      - TLS mode: disabled, preferred, required, verify-identity
+//   compress - compression algorithm: zlib (default) or zstd (requires
+//              native.RegisterCompressor("zstd", ...), otherwise the
+//              connection is uncompressed)
+//   decimal  - DECIMAL values from prepared statements: float (default) or
+//              exact ([]byte)
 func (d *Driver) Open(uri string) (driver.Conn, error) {
+	cfg, err := d.parseURI(uri)
+	if err != nil {
+		return nil, err
+	}
+	c, err := cfg.connect(context.Background(), cfg.timeout)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// parseURI returns a copy of d with configuration from uri. See Open.
+func (d *Driver) parseURI(uri string) (*Driver, error) {
 	cfg := *d // copy default configuration
+	cfg.initCmds = append([]string(nil), d.initCmds...)
 	pd := strings.SplitN(uri, "*", 2)
-	connCommands := []string{}
 	if len(pd) == 2 {
 		// Parse protocol part of URI
 		p := strings.SplitN(pd[0], ":", 2)
@@ -348,8 +330,33 @@ func (d *Driver) Open(uri string) (driver.Conn, error) {
 					return nil, err
 				}
 				cfg.timeout = to
+			case "tls":
+				mode, err := mysql.ParseTLSMode(v)
+				if err != nil {
+					return nil, err
+				}
+				cfg.tlsMode = mode
+				cfg.tlsModeSet = true
+			case "decimal":
+				switch v {
+				case "float":
+					cfg.decimalMode = mysql.DecimalFloat
+				case "exact", "true":
+					cfg.decimalMode = mysql.DecimalExact
+				default:
+					return nil, errors.New("Wrong decimal mode: " + v)
+				}
+			case "compress":
+				switch v {
+				case "true":
+					v = "zlib"
+				case "zlib", "zstd":
+				default:
+					return nil, errors.New("Wrong compression algorithm: " + v)
+				}
+				cfg.compress = v
 			default:
-				connCommands = append(connCommands, "SET "+k+"="+v)
+				cfg.initCmds = append(cfg.initCmds, "SET "+k+"="+v)
 			}
 		}
 		// Remove protocol part
@@ -363,31 +370,71 @@ func (d *Driver) Open(uri string) (driver.Conn, error) {
 	cfg.db = dup[0]
 	cfg.user = dup[1]
 	cfg.passwd = dup[2]
+	return &cfg, nil
+}
 
+// connect establishes a new connection using cfg configuration. The handshake
+// is interrupted if ctx is done.
+func (cfg *Driver) connect(ctx context.Context, timeout time.Duration) (*conn, error) {
 	c := conn{mysql.New(
 		cfg.proto, cfg.laddr, cfg.raddr, cfg.user, cfg.passwd, cfg.db,
 	)}
-	if d.dialer != nil {
-		dialer := func(proto, laddr, raddr string, timeout time.Duration) (
-			net.Conn, error) {
-
-			return d.dialer(proto, laddr, raddr, cfg.user, cfg.passwd, timeout)
+	var w *watcher
+	dialer := func(proto, laddr, raddr string, timeout time.Duration) (
+		nc net.Conn, err error) {
+
+		if cfg.dialer != nil {
+			// Credentials are refreshed by the provider before dialing
+			user, _ := c.my.Credentials()
+			nc, err = cfg.dialer(proto, laddr, raddr, user, cfg.db, timeout)
+		}
+		if nc == nil && err == nil {
+			nc, err = native.DefaultDialer(proto, laddr, raddr, timeout)
 		}
-		c.my.SetDialer(dialer)
+		if err == nil && w == nil {
+			// Only the connection made by Connect below is watched
+			w = watchContext(ctx, nc)
+		}
+		return
+	}
+	c.my.SetDialer(dialer)
+	if cfg.credentials != nil {
+		c.my.SetCredentialProvider(cfg.credentials)
+	}
+
+	if cfg.tlsConfig != nil {
+		c.my.SetTLSConfig(cfg.tlsConfig)
+	}
+	if cfg.tlsModeSet {
+		// Mode from URI overrides the mode set by SetTLSConfig
+		c.my.SetTLSMode(cfg.tlsMode)
+	}
+
+	if cfg.compress != "" {
+		c.my.SetCompression(cfg.compress, 0)
+	}
+	if cfg.tracer != nil {
+		c.my.SetTracer(cfg.tracer)
+	}
+	if cfg.metrics != nil {
+		c.my.SetMetrics(cfg.metrics)
 	}
 
 	// Establish the connection
-	c.my.SetTimeout(cfg.timeout)
+	c.my.SetTimeout(timeout)
 	for _, q := range cfg.initCmds {
 		c.my.Register(q) // Register initialisation commands
 	}
-	for _, q := range connCommands {
-		c.my.Register(q)
+	err := c.my.Connect()
+	if w.stop() {
+		return nil, ctx.Err()
 	}
-	if err := c.my.Connect(); err != nil {
+	if err != nil {
 		return nil, errFilter(err)
 	}
+	c.my.SetTimeout(cfg.timeout)
 	c.my.NarrowTypeSet(true)
+	c.my.SetDecimalMode(cfg.decimalMode)
 	c.my.FullFieldInfo(false)
 	return &c, nil
 }
@@ -400,7 +447,8 @@ func (drv *Driver) Register(query string) {
 
 // Dialer can be used to dial connections to MySQL. If Dialer returns (nil, nil)
 // the hook is skipped and normal dialing proceeds. user and dbname are there
-// only for logging.
+// only for logging. If a credential provider is set (see
+// SetCredentialProvider) user is the one returned by the provider.
 type Dialer func(proto, laddr, raddr, user, dbname string, timeout time.Duration) (net.Conn, error)
 
 // SetDialer sets custom Dialer used by Driver to make connections.
@@ -408,6 +456,31 @@ func (drv *Driver) SetDialer(dialer Dialer) {
 	drv.dialer = dialer
 }
 
+// SetCredentialProvider sets the function that returns the user name and
+// password for every connection made by Driver. They replace USER and PASSWD
+// from the URI. See mysql.Conn.SetCredentialProvider.
+func (drv *Driver) SetCredentialProvider(p mysql.CredentialProvider) {
+	drv.credentials = p
+}
+
+// SetTLSConfig sets TLS configuration used by Driver to make connections.
+// See mysql.Conn.SetTLSConfig.
+func (drv *Driver) SetTLSConfig(cfg *tls.Config) {
+	drv.tlsConfig = cfg
+}
+
+// SetTracer sets the tracer used by connections made by Driver. See
+// mysql.Conn.SetTracer.
+func (drv *Driver) SetTracer(t mysql.Tracer) {
+	drv.tracer = t
+}
+
+// SetMetrics sets the metrics collector used by connections made by Driver.
+// See mysql.Conn.SetMetrics.
+func (drv *Driver) SetMetrics(m mysql.Metrics) {
+	drv.metrics = m
+}
+
 // Driver automatically registered in database/sql.
 var dfltdrv = Driver{proto: "tcp", raddr: "127.0.0.1:3306"}
 
@@ -422,6 +495,27 @@ func SetDialer(dialer Dialer) {
 	dfltdrv.SetDialer(dialer)
 }
 
+// SetCredentialProvider calls SetCredentialProvider method on driver
+// registered in database/sql.
+func SetCredentialProvider(p mysql.CredentialProvider) {
+	dfltdrv.SetCredentialProvider(p)
+}
+
+// SetTLSConfig calls SetTLSConfig method on driver registered in database/sql.
+func SetTLSConfig(cfg *tls.Config) {
+	dfltdrv.SetTLSConfig(cfg)
+}
+
+// SetTracer calls SetTracer method on driver registered in database/sql.
+func SetTracer(t mysql.Tracer) {
+	dfltdrv.SetTracer(t)
+}
+
+// SetMetrics calls SetMetrics method on driver registered in database/sql.
+func SetMetrics(m mysql.Metrics) {
+	dfltdrv.SetMetrics(m)
+}
+
 func init() {
 	Register("SET NAMES utf8")
 	sql.Register("mymysql", &dfltdrv)
@@ -1,11 +1,18 @@
 package godrv
 
 import (
+	"context"
+	"crypto/tls"
 	"database/sql"
+	"database/sql/driver"
 	"fmt"
-	"github.com/ziutek/mymysql/mysql"
+	"io"
+	"net"
 	"testing"
 	"time"
+
+	"github.com/ziutek/mymysql/mysql"
+	"github.com/ziutek/mymysql/mysqltest"
 )
 
 func init() {
@@ -348,3 +355,177 @@ func TestMultiple(t *testing.T) {
 		t.Fatal("Too short result set")
 	}
 }
+
+func TestContext(t *testing.T) {
+	db, err := sql.Open("mymysql", "test/testuser/TestPasswd9")
+	checkErr(t, err)
+	defer db.Close()
+
+	checkErr(t, db.PingContext(context.Background()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = db.ExecContext(ctx, "SELECT SLEEP(10)")
+	if err != context.DeadlineExceeded {
+		t.Fatal("ExecContext:", err)
+	}
+
+	_, err = db.Exec("CREATE TABLE ro (i INT)")
+	checkErr(t, err)
+	defer db.Exec("DROP TABLE ro")
+	tx, err := db.BeginTx(context.Background(), &sql.TxOptions{
+		Isolation: sql.LevelSerializable,
+		ReadOnly:  true,
+	})
+	checkErr(t, err)
+	if _, err = tx.Exec("INSERT ro VALUES (1)"); err == nil {
+		t.Fatal("INSERT in read-only transaction succeeded")
+	}
+	checkErr(t, tx.Rollback())
+}
+
+func TestParseURI(t *testing.T) {
+	d := &Driver{proto: "tcp", raddr: "127.0.0.1:3306", initCmds: []string{"A"}}
+	cfg, err := d.parseURI("unix:/tmp/s.sock,laddr=x,timeout=3s,tls=required,compress,decimal=exact,sql_mode=ANSI*db/u/p/w")
+	checkErr(t, err)
+	if cfg.proto != "unix" || cfg.raddr != "/tmp/s.sock" || cfg.laddr != "x" ||
+		cfg.timeout != 3*time.Second || cfg.tlsMode != mysql.TLSRequired ||
+		cfg.compress != "zlib" || cfg.decimalMode != mysql.DecimalExact ||
+		cfg.db != "db" || cfg.user != "u" || cfg.passwd != "p/w" {
+		t.Fatalf("Bad config: %+v", cfg)
+	}
+	if len(cfg.initCmds) != 2 || cfg.initCmds[1] != "SET sql_mode=ANSI" ||
+		len(d.initCmds) != 1 {
+		t.Fatalf("Bad init commands: %v %v", cfg.initCmds, d.initCmds)
+	}
+	if _, err = d.parseURI("db/u"); err == nil {
+		t.Fatal("Wrong URI parsed without error")
+	}
+	if _, err = d.parseURI("tcp:a,compress=lz4*db/u/p"); err == nil {
+		t.Fatal("Unknown compression algorithm accepted")
+	}
+}
+
+func TestTLSModeOverride(t *testing.T) {
+	srv_cfg, cli_cfg, err := mysqltest.NewTLSConfig()
+	checkErr(t, err)
+	srv := &mysqltest.Server{User: "u", Passwd: "p", TLSConfig: srv_cfg}
+	checkErr(t, srv.Start())
+	defer srv.Close()
+
+	d := &Driver{proto: "tcp", raddr: srv.Addr()}
+	d.SetTLSConfig(cli_cfg)
+	for _, tc := range []struct {
+		uri    string
+		secure bool
+	}{
+		{"db/u/p", true},
+		{"tcp:" + srv.Addr() + ",tls=disabled*db/u/p", false},
+	} {
+		c, err := d.Open(tc.uri)
+		checkErr(t, err)
+		_, secure := c.(*conn).my.NetConn().(*tls.Conn)
+		c.Close()
+		if secure != tc.secure {
+			t.Errorf("%s: TLS used: %t", tc.uri, secure)
+		}
+	}
+}
+
+func TestDialerCredentials(t *testing.T) {
+	srv := &mysqltest.Server{User: "u", Passwd: "p"}
+	checkErr(t, srv.Start())
+	defer srv.Close()
+
+	d := &Driver{proto: "tcp", raddr: srv.Addr()}
+	d.SetCredentialProvider(func() (string, string, error) {
+		return "u", "p", nil
+	})
+	var user, dbname string
+	d.SetDialer(func(proto, laddr, raddr, u, db string, timeout time.Duration) (
+		net.Conn, error) {
+
+		user, dbname = u, db
+		return nil, nil
+	})
+	c, err := d.Open("db/stale/stalepw")
+	checkErr(t, err)
+	c.Close()
+	if user != "u" || dbname != "db" {
+		t.Fatalf("Bad dialer arguments: %q %q", user, dbname)
+	}
+}
+
+func TestErrFilter(t *testing.T) {
+	wrapped := fmt.Errorf("query: %w", context.DeadlineExceeded)
+	for _, err := range []error{context.Canceled, context.DeadlineExceeded, wrapped} {
+		if errFilter(err) != err {
+			t.Errorf("%v changed to %v", err, errFilter(err))
+		}
+	}
+	net_err := &net.OpError{Op: "read", Net: "tcp", Err: io.ErrClosedPipe}
+	for _, err := range []error{net_err, io.ErrUnexpectedEOF, mysql.ErrNotConn} {
+		if errFilter(err) != driver.ErrBadConn {
+			t.Errorf("%v not changed to ErrBadConn", err)
+		}
+	}
+}
+
+func TestFakeContext(t *testing.T) {
+	srv := &mysqltest.Server{User: "u", Passwd: "p"}
+	checkErr(t, srv.Start())
+	defer srv.Close()
+	release := make(chan struct{})
+	defer close(release)
+	srv.HandleCommand(mysqltest.COM_PING, func(*mysqltest.Command) []mysqltest.Result {
+		<-release
+		return nil
+	})
+	srv.HandleFunc("select 1", func(*mysqltest.Command) []mysqltest.Result {
+		<-release
+		return nil
+	})
+
+	d := &Driver{proto: "tcp", raddr: srv.Addr()}
+	for _, f := range []func(*conn, context.Context) error{
+		(*conn).Ping,
+		func(cn *conn, ctx context.Context) error {
+			_, err := cn.PrepareContext(ctx, "select 1")
+			return err
+		},
+	} {
+		c, err := d.Open("db/u/p")
+		checkErr(t, err)
+		cn := c.(*conn)
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		err = f(cn, ctx)
+		cancel()
+		if err != context.DeadlineExceeded {
+			t.Fatal("Bad error:", err)
+		}
+		// The connection was closed
+		if err = cn.Ping(context.Background()); err != driver.ErrBadConn {
+			t.Fatal("Ping after cancel:", err)
+		}
+		c.Close()
+	}
+
+	// Server that never sends the handshake
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	checkErr(t, err)
+	defer ln.Close()
+	go func() {
+		nc, err := ln.Accept()
+		if err == nil {
+			<-release
+			nc.Close()
+		}
+	}()
+	cr, err := d.OpenConnector("tcp:" + ln.Addr().String() + ",timeout=10s*db/u/p")
+	checkErr(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	if _, err = cr.Connect(ctx); err != context.Canceled {
+		t.Fatal("Connect:", err)
+	}
+}
@@ -0,0 +1,124 @@
+package mysql
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+)
+
+// The Is* functions below classify errors returned by mymysql. They use
+// errors.As, so err can be wrapped.
+
+// asError returns the MySQL server error contained in err.
+func asError(err error) (*Error, bool) {
+	var e *Error
+	if errors.As(err, &e) && e != nil {
+		return e, true
+	}
+	var v Error
+	if errors.As(err, &v) {
+		return &v, true
+	}
+	return nil, false
+}
+
+func hasCode(err error, codes ...uint16) bool {
+	e, ok := asError(err)
+	if !ok {
+		return false
+	}
+	for _, c := range codes {
+		if e.Code == c {
+			return true
+		}
+	}
+	return false
+}
+
+func hasStateClass(err error, class string) bool {
+	e, ok := asError(err)
+	return ok && strings.HasPrefix(e.SQLState, class)
+}
+
+// IsDuplicateKey returns true if err is a duplicate key (unique constraint)
+// error.
+func IsDuplicateKey(err error) bool {
+	return hasCode(err, ER_DUP_ENTRY, ER_DUP_KEY, ER_DUP_UNIQUE,
+		ER_DUP_ENTRY_WITH_KEY_NAME)
+}
+
+// IsDeadlock returns true if the transaction was rolled back because of a
+// deadlock.
+func IsDeadlock(err error) bool {
+	return hasCode(err, ER_LOCK_DEADLOCK)
+}
+
+// IsLockTimeout returns true if the statement failed because a lock couldn't
+// be acquired in time (or immediately, using NOWAIT).
+func IsLockTimeout(err error) bool {
+	return hasCode(err, ER_LOCK_WAIT_TIMEOUT, ER_LOCK_NOWAIT)
+}
+
+// IsReadOnly returns true if the statement was rejected because the server
+// or the transaction is read-only.
+func IsReadOnly(err error) bool {
+	e, ok := asError(err)
+	if !ok {
+		return false
+	}
+	switch e.Code {
+	case ER_READ_ONLY_TRANSACTION, ER_CANT_EXECUTE_IN_READ_ONLY_TRANSACTION,
+		ER_READ_ONLY_MODE, ER_INNODB_READ_ONLY:
+		return true
+	case ER_OPTION_PREVENTS_STATEMENT:
+		// --read-only or --super-read-only (other options are possible)
+		msg := string(e.Msg)
+		return strings.Contains(msg, "read-only") ||
+			strings.Contains(msg, "read_only")
+	}
+	return e.SQLState == "25006"
+}
+
+// IsConnectionLost returns true if err is a network error or the server
+// closed the connection. The connection should be reconnected. Context errors
+// (context.Canceled, context.DeadlineExceeded) aren't network errors even if
+// they implement net.Error.
+func IsConnectionLost(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, context.Canceled) ||
+		errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, ErrNotConn) {
+		return true
+	}
+	var ne net.Error
+	if errors.As(err, &ne) {
+		return true
+	}
+	return hasCode(err, ER_SERVER_SHUTDOWN, ER_NET_READ_ERROR,
+		ER_NET_READ_INTERRUPTED, ER_NET_ERROR_ON_WRITE,
+		ER_NET_WRITE_INTERRUPTED, ER_ABORTING_CONNECTION,
+		ER_NEW_ABORTING_CONNECTION, ER_CONNECTION_KILLED,
+		ER_CLIENT_INTERACTION_TIMEOUT) || hasStateClass(err, "08")
+}
+
+// IsRetryable returns true if the failed statement (or the transaction) can be
+// executed again: after a deadlock, a lock timeout or, after reconnect, a lost
+// connection.
+func IsRetryable(err error) bool {
+	return IsDeadlock(err) || IsLockTimeout(err) || IsConnectionLost(err)
+}
+
+// IsConstraintViolation returns true if the statement violated an integrity
+// constraint: duplicate key, foreign key, NOT NULL or CHECK.
+func IsConstraintViolation(err error) bool {
+	return IsDuplicateKey(err) || hasStateClass(err, "23") ||
+		hasCode(err, ER_BAD_NULL_ERROR, ER_NO_REFERENCED_ROW,
+			ER_ROW_IS_REFERENCED, ER_NO_REFERENCED_ROW_2,
+			ER_ROW_IS_REFERENCED_2, ER_CHECK_CONSTRAINT_VIOLATED)
+}
@@ -13,9 +13,16 @@ import (
 //     if val, ok := err.(*mysql.Error); ok {
 //         fmt.Println(val.Code)
 //     }
+//
+// Error can be also checked using errors.Is (also if err is wrapped) or
+// classified using IsDuplicateKey, IsDeadlock and other Is* functions:
+//     if errors.Is(err, mysql.ErrorCode(mysql.ER_DUP_ENTRY)) {
+//         // ...
+//     }
 type Error struct {
-	Code uint16
-	Msg  []byte
+	Code     uint16
+	Msg      []byte
+	SQLState string // Five characters SQLSTATE value (eg. "23000")
 }
 
 func (err Error) Error() string {
@@ -23,6 +30,26 @@ func (err Error) Error() string {
 		err.Code, err.Msg)
 }
 
+// Is reports whether target is ErrorCode or *Error with the same error code.
+// It is used by errors.Is.
+func (err Error) Is(target error) bool {
+	switch t := target.(type) {
+	case ErrorCode:
+		return err.Code == uint16(t)
+	case *Error:
+		return t != nil && err.Code == t.Code
+	}
+	return false
+}
+
+// ErrorCode is a MySQL error code that can be used as the target of
+// errors.Is (see Error.Is).
+type ErrorCode uint16
+
+func (c ErrorCode) Error() string {
+	return fmt.Sprintf("MySQL error #%d", uint16(c))
+}
+
 // MySQL error codes.
 const (
 	ER_HASHCHK                                 = 1000
@@ -499,6 +526,18 @@ const (
 	ER_NON_INSERTABLE_TABLE                    = 1471
 )
 
+// Error codes of newer server versions.
+const (
+	ER_DUP_ENTRY_WITH_KEY_NAME               = 1586
+	ER_CANT_EXECUTE_IN_READ_ONLY_TRANSACTION = 1792
+	ER_READ_ONLY_MODE                        = 1836
+	ER_INNODB_READ_ONLY                      = 1874
+	ER_CONNECTION_KILLED                     = 1927
+	ER_LOCK_NOWAIT                           = 3572
+	ER_CHECK_CONSTRAINT_VIOLATED             = 3819
+	ER_CLIENT_INTERACTION_TIMEOUT            = 4031
+)
+
 // ClientError is a type for mymysql client errors.
 type ClientError string
 
@@ -532,4 +571,7 @@ var (
 	ErrReadAfterEOR   = ClientError("previous ScanRow call returned io.EOF")
 	ErrOldProtocol    = ClientError("server does not support 4.1 protocol")
 	ErrAuthentication = ClientError("authentication error")
+	ErrNoTLS          = ClientError("server does not support TLS")
+	ErrCursorClosed   = ClientError("cursor was closed before all rows were fetched")
+	ErrWarningsLost   = ClientError("warnings were cleared by the next command")
 )
@@ -0,0 +1,79 @@
+package mysql
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestErrorIs(t *testing.T) {
+	err := fmt.Errorf("insert: %w", &Error{Code: ER_DUP_ENTRY, SQLState: "23000"})
+	if !errors.Is(err, ErrorCode(ER_DUP_ENTRY)) {
+		t.Fatal("errors.Is doesn't match the code")
+	}
+	if errors.Is(err, ErrorCode(ER_LOCK_DEADLOCK)) {
+		t.Fatal("errors.Is matches other code")
+	}
+	if !errors.Is(err, &Error{Code: ER_DUP_ENTRY}) {
+		t.Fatal("errors.Is doesn't match *Error")
+	}
+	var e *Error
+	if !errors.As(err, &e) || e.SQLState != "23000" {
+		t.Fatal("errors.As failed")
+	}
+}
+
+func TestErrorClass(t *testing.T) {
+	type class func(error) bool
+	dup := &Error{Code: ER_DUP_ENTRY, SQLState: "23000"}
+	fk := &Error{Code: ER_NO_REFERENCED_ROW_2, SQLState: "23000"}
+	deadlock := &Error{Code: ER_LOCK_DEADLOCK, SQLState: "40001"}
+	timeout := &Error{Code: ER_LOCK_WAIT_TIMEOUT, SQLState: "HY000"}
+	ro := &Error{
+		Code:     ER_OPTION_PREVENTS_STATEMENT,
+		SQLState: "HY000",
+		Msg:      []byte("The MySQL server is running with the --read-only option"),
+	}
+	other_opt := &Error{
+		Code:     ER_OPTION_PREVENTS_STATEMENT,
+		SQLState: "HY000",
+		Msg:      []byte("The MySQL server is running with the --skip-grant-tables option"),
+	}
+	shutdown := &Error{Code: ER_SERVER_SHUTDOWN, SQLState: "08S01"}
+	net_err := &net.OpError{Op: "read", Net: "tcp", Err: errors.New("reset")}
+
+	cases := []struct {
+		f   class
+		yes []error
+		no  []error
+	}{
+		{IsDuplicateKey, []error{dup}, []error{fk, deadlock, nil, io.EOF}},
+		{IsDeadlock, []error{deadlock, fmt.Errorf("tx: %w", deadlock)},
+			[]error{timeout, dup}},
+		{IsLockTimeout, []error{timeout}, []error{deadlock}},
+		{IsReadOnly, []error{ro, &Error{Code: ER_READ_ONLY_MODE}},
+			[]error{other_opt, dup}},
+		{IsConnectionLost, []error{shutdown, net_err, io.ErrUnexpectedEOF,
+			ErrNotConn}, []error{dup, deadlock, nil, context.Canceled,
+			context.DeadlineExceeded, fmt.Errorf("q: %w", context.DeadlineExceeded)}},
+		{IsRetryable, []error{deadlock, timeout, net_err}, []error{dup, ro,
+			context.Canceled, context.DeadlineExceeded}},
+		{IsConstraintViolation, []error{dup, fk, &Error{Code: ER_BAD_NULL_ERROR}},
+			[]error{deadlock, ErrNotConn}},
+	}
+	for i, c := range cases {
+		for _, err := range c.yes {
+			if !c.f(err) {
+				t.Errorf("%d: %v not classified", i, err)
+			}
+		}
+		for _, err := range c.no {
+			if c.f(err) {
+				t.Errorf("%d: %v classified", i, err)
+			}
+		}
+	}
+}
@@ -2,6 +2,8 @@
 package mysql
 
 import (
+	"context"
+	"crypto/tls"
 	"net"
 	"time"
 )
@@ -10,6 +12,7 @@ import (
 // See mymysql/native for method documentation.
 type ConnCommon interface {
 	Start(sql string, params ...interface{}) (Result, error)
+	StartContext(ctx context.Context, sql string, params ...interface{}) (Result, error)
 	Prepare(sql string) (Stmt, error)
 
 	Ping() error
@@ -25,6 +28,11 @@ type ConnCommon interface {
 // the hook is skipped and normal dialing proceeds.
 type Dialer func(proto, laddr, raddr string, timeout time.Duration) (net.Conn, error)
 
+// CredentialProvider returns the user name and password used to authenticate
+// a new connection. It is called before every connection attempt, so it can
+// return short-lived credentials (eg. IAM authentication tokens).
+type CredentialProvider func() (user, passwd string, err error)
+
 // Conn represents connection to the MySQL server.
 // See mymysql/native for method documentation.
 type Conn interface {
@@ -32,18 +40,34 @@ type Conn interface {
 
 	Clone() Conn
 	SetTimeout(time.Duration)
+	SetRemoteAddr(raddr string)
 	Connect() error
 	NetConn() net.Conn
 	SetDialer(Dialer)
+	SetCredentialProvider(CredentialProvider)
+	SetTLSConfig(*tls.Config)
+	SetTLSMode(TLSMode)
+	SetCompression(algorithm string, level int)
 	Close() error
 	IsConnected() bool
+	PendingReply() bool
 	Reconnect() error
 	Use(dbname string) error
+	ResetSession() error
+	ChangeUser(user, passwd, dbname string) error
 	Register(sql string)
 	SetMaxPktSize(new_size int) int
 	NarrowTypeSet(narrow bool)
+	SetDecimalMode(mode DecimalMode)
+	InterpolateParams(interpolate bool)
 	FullFieldInfo(full bool)
+	StrictWarnings(strict bool)
+	SetConnectAttr(key, value string)
+	SetTracer(t Tracer)
+	SetMetrics(m Metrics)
 	Status() ConnStatus
+	CurrentSchema() string
+	LastGTIDs() string
 	Credentials() (user, passwd string)
 
 	Begin() (Transaction, error)
@@ -65,9 +89,11 @@ type Transaction interface {
 type Stmt interface {
 	Bind(params ...interface{})
 	Run(params ...interface{}) (Result, error)
+	RunContext(ctx context.Context, params ...interface{}) (Result, error)
 	Delete() error
 	Reset() error
 	SendLongData(pnum int, data interface{}, pkt_size int) error
+	SetFetchSize(n int)
 
 	Fields() []*Field
 	NumParam() int
@@ -94,6 +120,8 @@ type Result interface {
 	AffectedRows() uint64
 	InsertId() uint64
 	WarnCount() int
+	Warnings() ([]Warning, error)
+	SessionChanges() []SessionChange
 
 	MakeRow() Row
 	GetRows() ([]Row, error)
@@ -0,0 +1,179 @@
+package mysql
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Interpolate replaces the ? placeholders in sql with params encoded as MySQL
+// literals. Placeholders inside quoted strings, quoted identifiers and
+// comments are ignored. Strings are escaped according to the
+// SERVER_STATUS_NO_BACKSLASH_ESCAPES status of c, nil becomes NULL, []byte and
+// Blob become hex literals. Other types are encoded like values bound to the
+// prepared statement. Returns ErrBindCount if the number of placeholders
+// doesn't match the number of parameters or ErrBindUnkType if a parameter
+// can't be encoded.
+func Interpolate(c Conn, sql string, params ...interface{}) (string, error) {
+	noBS := c.Status()&SERVER_STATUS_NO_BACKSLASH_ESCAPES != 0
+	buf := make([]byte, 0, len(sql)+16*len(params))
+	last, n := 0, 0
+	for i := 0; i < len(sql); {
+		if j := skipToken(sql, i, noBS); j != i {
+			i = j
+			continue
+		}
+		if sql[i] != '?' {
+			i++
+			continue
+		}
+		if n == len(params) {
+			return "", ErrBindCount
+		}
+		buf = append(buf, sql[last:i]...)
+		var err error
+		if buf, err = appendLiteral(buf, params[n], noBS); err != nil {
+			return "", err
+		}
+		n++
+		i++
+		last = i
+	}
+	if n != len(params) {
+		return "", ErrBindCount
+	}
+	return string(append(buf, sql[last:]...)), nil
+}
+
+// skipToken returns the index of the first byte after the quoted string,
+// quoted identifier or comment that starts at sql[i]. It returns i if there
+// is no such token at sql[i].
+func skipToken(sql string, i int, noBS bool) int {
+	switch q := sql[i]; q {
+	case '\'', '"', '`':
+		for j := i + 1; j < len(sql); j++ {
+			switch sql[j] {
+			case '\\':
+				if q != '`' && !noBS {
+					j++
+				}
+			case q:
+				if j+1 < len(sql) && sql[j+1] == q {
+					j++ // Doubled quote
+					continue
+				}
+				return j + 1
+			}
+		}
+		return len(sql)
+	case '#':
+		return lineEnd(sql, i)
+	case '-':
+		// MySQL requires whitespace or control character after --
+		if strings.HasPrefix(sql[i:], "--") &&
+			(i+2 == len(sql) || sql[i+2] <= ' ') {
+			return lineEnd(sql, i)
+		}
+	case '/':
+		if strings.HasPrefix(sql[i:], "/*") {
+			if k := strings.Index(sql[i+2:], "*/"); k >= 0 {
+				return i + 2 + k + 2
+			}
+			return len(sql)
+		}
+	}
+	return i
+}
+
+func lineEnd(sql string, i int) int {
+	if k := strings.IndexByte(sql[i:], '\n'); k >= 0 {
+		return i + k + 1
+	}
+	return len(sql)
+}
+
+func appendQuoted(buf []byte, s string, noBS bool) []byte {
+	buf = append(buf, '\'')
+	if noBS {
+		buf = append(buf, escapeQuotes(s)...)
+	} else {
+		buf = append(buf, escapeString(s)...)
+	}
+	return append(buf, '\'')
+}
+
+func appendHex(buf []byte, b []byte) []byte {
+	buf = append(buf, "X'"...)
+	buf = append(buf, hex.EncodeToString(b)...)
+	return append(buf, '\'')
+}
+
+func appendLiteral(buf []byte, val interface{}, noBS bool) ([]byte, error) {
+	switch v := val.(type) {
+	case nil:
+		return append(buf, "NULL"...), nil
+	case []byte:
+		return appendHex(buf, v), nil
+	case Blob:
+		return appendHex(buf, v), nil
+	case json.RawMessage:
+		return appendQuoted(buf, string(v), noBS), nil
+	case Decimal:
+		d, err := ParseDecimal(string(v))
+		if err != nil {
+			return nil, err
+		}
+		return append(buf, d...), nil
+	case time.Time:
+		return appendQuoted(buf, TimeString(v), noBS), nil
+	case Timestamp:
+		return appendQuoted(buf, v.String(), noBS), nil
+	case Date:
+		return appendQuoted(buf, v.String(), noBS), nil
+	case time.Duration:
+		return appendQuoted(buf, DurationString(v), noBS), nil
+	}
+	// Basic kinds (also user defined types)
+	v := reflect.ValueOf(val)
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			return append(buf, "NULL"...), nil
+		}
+		return appendLiteral(buf, v.Elem().Interface(), noBS)
+	case reflect.String:
+		return appendQuoted(buf, v.String(), noBS), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64:
+		return strconv.AppendInt(buf, v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64:
+		return strconv.AppendUint(buf, v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		f := v.Float()
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			// MySQL has no literals for them
+			return nil, ClientError("can't interpolate " +
+				strconv.FormatFloat(f, 'g', -1, 64))
+		}
+		bits := 64
+		if v.Kind() == reflect.Float32 {
+			bits = 32
+		}
+		return strconv.AppendFloat(buf, f, 'g', -1, bits), nil
+	case reflect.Bool:
+		if v.Bool() {
+			return append(buf, '1'), nil
+		}
+		return append(buf, '0'), nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return appendHex(buf, v.Bytes()), nil
+		}
+	}
+	return nil, ErrBindUnkType
+}
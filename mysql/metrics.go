@@ -0,0 +1,70 @@
+package mysql
+
+import "time"
+
+// Metric identifies a counter or a histogram collected using Metrics.
+type Metric int
+
+// Counters
+const (
+	BytesIn    Metric = iota // Bytes read from the network connection
+	BytesOut                 // Bytes written to the network connection
+	PacketsIn                // MySQL protocol packets received
+	PacketsOut               // MySQL protocol packets sent
+	Connects                 // Successful connects and reconnects
+	Reconnects               // Calls of Reconnect (also by autorc)
+	Queries                  // Text queries
+	Execs                    // Executions of prepared statements
+	Retries                  // Operations retried by autorc
+)
+
+// Histograms
+const (
+	QueryDuration Metric = iota + 100 // Query/execution time (whole response)
+	LockWait                          // Time waiting for thrsafe connection lock
+)
+
+var metricNames = map[Metric]string{
+	BytesIn:       "bytes_in",
+	BytesOut:      "bytes_out",
+	PacketsIn:     "packets_in",
+	PacketsOut:    "packets_out",
+	Connects:      "connects",
+	Reconnects:    "reconnects",
+	Queries:       "queries",
+	Execs:         "execs",
+	Retries:       "retries",
+	QueryDuration: "query_duration",
+	LockWait:      "lock_wait",
+}
+
+// Counters lists all counters.
+var Counters = []Metric{
+	BytesIn, BytesOut, PacketsIn, PacketsOut, Connects, Reconnects, Queries,
+	Execs, Retries,
+}
+
+// Histograms lists all histograms.
+var Histograms = []Metric{QueryDuration, LockWait}
+
+// String returns the name of the metric in snake case (eg. "bytes_in").
+func (m Metric) String() string {
+	if s, ok := metricNames[m]; ok {
+		return s
+	}
+	return "unknown"
+}
+
+// Metrics collects measurements from connections (see Conn.SetMetrics). One
+// Metrics is usually shared by many connections (Clone copies it), so it must
+// be safe for concurrent use. Methods are called often (Add for every packet)
+// so they should be cheap.
+type Metrics interface {
+	// Add adds n to the counter m.
+	Add(m Metric, n uint64)
+	// Observe records d in the histogram m.
+	Observe(m Metric, d time.Duration)
+	// Error counts an error returned by Connect, Reconnect, Prepare, a query
+	// or an execution. code is the MySQL error code or 0 for other errors.
+	Error(code uint16)
+}
@@ -2,6 +2,7 @@ package mysql
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"math"
 	"os"
@@ -16,7 +17,8 @@ import (
 // []byte slice, contained result text or nil if NULL is returned.
 //
 // If it is a result of prepared statement execution, its element field can be:
-// intX, uintX, floatX, []byte, Date, Time, time.Time (in Local location) or nil.
+// intX, uintX, floatX, []byte, Date, Time, time.Time (in Local location),
+// Decimal (see DecimalMode), json.RawMessage or nil.
 type Row []interface{}
 
 // Bin gets the nn-th value and returns it as []byte ([]byte{} if NULL).
@@ -446,6 +448,8 @@ func (tr Row) FloatErr(nn int) (val float64, err error) {
 		} else {
 			val = float64(u)
 		}
+	case Decimal:
+		val, err = data.Float64()
 	case []byte:
 		val, err = strconv.ParseFloat(string(data), 64)
 	default:
@@ -470,3 +474,61 @@ func (tr Row) ForceFloat(nn int) (val float64) {
 	val, _ = tr.FloatErr(nn)
 	return
 }
+
+// DecimalErr gets the nn-th value and returns it as Decimal ("" if NULL).
+// Returns error if conversion is impossible.
+func (tr Row) DecimalErr(nn int) (val Decimal, err error) {
+	switch data := tr[nn].(type) {
+	case nil:
+		// nop
+	case Decimal:
+		val = data
+	case int64, int32, int16, int8:
+		val = Decimal(strconv.FormatInt(reflect.ValueOf(data).Int(), 10))
+	case uint64, uint32, uint16, uint8:
+		val = Decimal(strconv.FormatUint(reflect.ValueOf(data).Uint(), 10))
+	case float64:
+		val = Decimal(strconv.FormatFloat(data, 'f', -1, 64))
+	case float32:
+		val = Decimal(strconv.FormatFloat(float64(data), 'f', -1, 32))
+	case []byte:
+		val, err = ParseDecimal(string(data))
+	default:
+		err = os.ErrInvalid
+	}
+	return
+}
+
+// Decimal gets the nn-th value and returns it as Decimal ("" if NULL).
+// Panics if conversion is impossible.
+func (tr Row) Decimal(nn int) (val Decimal) {
+	val, err := tr.DecimalErr(nn)
+	if err != nil {
+		panic(err)
+	}
+	return
+}
+
+// ForceDecimal gets the nn-th value and returns it as Decimal. Returns "" if
+// value is NULL or if conversion is impossible.
+func (tr Row) ForceDecimal(nn int) (val Decimal) {
+	val, _ = tr.DecimalErr(nn)
+	return
+}
+
+// JSON unmarshals the nn-th value into v using encoding/json. v isn't modified
+// if value is NULL. Returns error if value isn't JSON text or can't be stored
+// in v.
+func (tr Row) JSON(nn int, v interface{}) error {
+	switch data := tr[nn].(type) {
+	case nil:
+		return nil
+	case json.RawMessage:
+		return json.Unmarshal(data, v)
+	case []byte:
+		return json.Unmarshal(data, v)
+	case string:
+		return json.Unmarshal([]byte(data), v)
+	}
+	return os.ErrInvalid
+}
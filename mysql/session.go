@@ -0,0 +1,40 @@
+package mysql
+
+// SessionTrackType is the type of the session state change reported by the
+// server (see Result.SessionChanges).
+type SessionTrackType byte
+
+// Session state change types. The server reports only changes enabled by the
+// session_track_* system variables.
+const (
+	SessionTrackSystemVariables            SessionTrackType = iota // session_track_system_variables
+	SessionTrackSchema                                             // session_track_schema
+	SessionTrackStateChange                                        // session_track_state_change
+	SessionTrackGTIDs                                              // session_track_gtids
+	SessionTrackTransactionCharacteristics                         // session_track_transaction_info = CHARACTERISTICS
+	SessionTrackTransactionState                                   // session_track_transaction_info
+)
+
+var sessionTrackNames = []string{
+	"system_variables", "schema", "state_change", "gtids",
+	"transaction_characteristics", "transaction_state",
+}
+
+func (t SessionTrackType) String() string {
+	if int(t) < len(sessionTrackNames) {
+		return sessionTrackNames[t]
+	}
+	return "unknown"
+}
+
+// SessionChange is a session state change reported by the server in the OK
+// packet.
+type SessionChange struct {
+	Type SessionTrackType
+	// Name is the system variable name (SessionTrackSystemVariables only).
+	Name string
+	// Value is the new value of the system variable, the schema name, "1"
+	// for SessionTrackStateChange, the GTID set, the SQL that reproduces
+	// transaction characteristics or the 8 characters transaction state.
+	Value string
+}
@@ -15,4 +15,9 @@ const (
 
 	SERVER_STATUS_DB_DROPPED           ConnStatus = 0x100
 	SERVER_STATUS_NO_BACKSLASH_ESCAPES ConnStatus = 0x200
+	SERVER_STATUS_METADATA_CHANGED     ConnStatus = 0x400
+	SERVER_QUERY_WAS_SLOW              ConnStatus = 0x800
+	SERVER_PS_OUT_PARAMS               ConnStatus = 0x1000
+	SERVER_STATUS_IN_TRANS_READONLY    ConnStatus = 0x2000 // Read-only transaction has started
+	SERVER_SESSION_STATE_CHANGED       ConnStatus = 0x4000 // Session state changed (see Result.SessionChanges)
 )
@@ -0,0 +1,43 @@
+package mysql
+
+// TLSMode specifies whether and how a connection is secured with TLS.
+type TLSMode int
+
+// TLS modes. Certificate verification described below applies to the default
+// TLS configuration. A configuration set by the user is always used as is.
+const (
+	TLSDisabled       TLSMode = iota // Plaintext connection
+	TLSPreferred                     // TLS if server supports it, certificate not verified
+	TLSRequired                      // TLS or error, certificate not verified
+	TLSVerifyIdentity                // TLS or error, certificate and host name verified
+)
+
+func (m TLSMode) String() string {
+	switch m {
+	case TLSDisabled:
+		return "disabled"
+	case TLSPreferred:
+		return "preferred"
+	case TLSRequired:
+		return "required"
+	case TLSVerifyIdentity:
+		return "verify-identity"
+	}
+	return "unknown"
+}
+
+// ParseTLSMode converts string representation of TLS mode (as returned by
+// TLSMode.String) to TLSMode.
+func ParseTLSMode(s string) (TLSMode, error) {
+	switch s {
+	case "disabled", "false":
+		return TLSDisabled, nil
+	case "preferred":
+		return TLSPreferred, nil
+	case "required", "true":
+		return TLSRequired, nil
+	case "verify-identity":
+		return TLSVerifyIdentity, nil
+	}
+	return TLSDisabled, ClientError("unknown TLS mode: " + s)
+}
@@ -0,0 +1,93 @@
+package mysql
+
+import (
+	"context"
+	"time"
+)
+
+// Tracer receives events from the connection (see Conn.SetTracer). Methods
+// are called synchronously by the goroutine that uses the connection, so they
+// should return quickly. Clone copies the tracer, so one tracer is usually
+// used by many connections and must be safe for concurrent use. Embed
+// NopTracer to implement only some of the methods.
+type Tracer interface {
+	// Connect is called after Connect.
+	Connect(ev *ConnectEvent)
+	// Auth is called after authentication (successful or not).
+	Auth(ev *AuthEvent)
+	// QueryStart is called before a text query is sent to the server.
+	QueryStart(ev *QueryEvent)
+	// QueryEnd is called with the same ev as QueryStart after the whole
+	// response was read (all rows of all results) or an error occurred.
+	QueryEnd(ev *QueryEvent)
+	// Prepare is called after Prepare.
+	Prepare(ev *StmtEvent)
+	// ExecStart and ExecEnd work like QueryStart and QueryEnd for execution
+	// of a prepared statement.
+	ExecStart(ev *QueryEvent)
+	ExecEnd(ev *QueryEvent)
+	// StmtClose is called after the statement was deleted.
+	StmtClose(ev *StmtEvent)
+	// Reconnect is called after Reconnect.
+	Reconnect(ev *ConnectEvent)
+}
+
+// ConnectEvent describes a connection attempt.
+type ConnectEvent struct {
+	Addr          string // Server address
+	ThreadId      uint32 // Server thread ID (0 if the connection failed)
+	ServerVersion string
+	Duration      time.Duration
+	Err           error
+}
+
+// AuthEvent describes an authentication.
+type AuthEvent struct {
+	Addr   string
+	User   string
+	Plugin string // Authentication plugin used (after auth switch)
+	Err    error
+}
+
+// QueryEvent describes a text query or an execution of a prepared statement.
+// Fields after Start are set before QueryEnd (ExecEnd) is called.
+type QueryEvent struct {
+	// Ctx is the context passed to StartContext or RunContext (otherwise
+	// context.Background()).
+	Ctx    context.Context
+	Addr   string
+	SQL    string // Query (after parameter substitution) or statement SQL
+	StmtId uint32 // Prepared statement ID (0 for text queries)
+	Start  time.Time
+
+	// Data can be set by QueryStart (ExecStart) to pass a value (eg. span) to
+	// QueryEnd (ExecEnd).
+	Data interface{}
+
+	Duration     time.Duration
+	Rows         int64  // Number of rows read from all results
+	AffectedRows uint64 // Sum of affected rows of all results
+	Err          error
+}
+
+// StmtEvent describes preparing or closing a prepared statement.
+type StmtEvent struct {
+	Addr     string
+	SQL      string
+	StmtId   uint32
+	Duration time.Duration
+	Err      error
+}
+
+// NopTracer implements Tracer and ignores all events.
+type NopTracer struct{}
+
+func (NopTracer) Connect(ev *ConnectEvent)   {}
+func (NopTracer) Auth(ev *AuthEvent)         {}
+func (NopTracer) QueryStart(ev *QueryEvent)  {}
+func (NopTracer) QueryEnd(ev *QueryEvent)    {}
+func (NopTracer) Prepare(ev *StmtEvent)      {}
+func (NopTracer) ExecStart(ev *QueryEvent)   {}
+func (NopTracer) ExecEnd(ev *QueryEvent)     {}
+func (NopTracer) StmtClose(ev *StmtEvent)    {}
+func (NopTracer) Reconnect(ev *ConnectEvent) {}
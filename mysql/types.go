@@ -1,6 +1,7 @@
 package mysql
 
 import (
+	"database/sql/driver"
 	"errors"
 	"fmt"
 	"strconv"
@@ -223,3 +224,60 @@ type Timestamp struct {
 func (t Timestamp) String() string {
 	return TimeString(t.Time)
 }
+
+// Decimal is an exact MySQL DECIMAL value in its textual form, eg. "-12.340".
+// Use it instead of float64 when the rounding errors aren't acceptable.
+type Decimal string
+
+// ParseDecimal checks that str is a valid decimal number and converts it to
+// Decimal. Leading and trailing spaces and the leading plus sign are ignored.
+func ParseDecimal(str string) (Decimal, error) {
+	orig := str
+	str = strings.TrimSpace(str)
+	if len(str) > 0 && str[0] == '+' {
+		str = str[1:]
+	}
+	s := str
+	if len(s) > 0 && s[0] == '-' {
+		s = s[1:]
+	}
+	digits, dot := 0, false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] >= '0' && s[i] <= '9':
+			digits++
+		case s[i] == '.' && !dot:
+			dot = true
+		default:
+			return "", errors.New("invalid MySQL DECIMAL string: " + orig)
+		}
+	}
+	if digits == 0 {
+		return "", errors.New("invalid MySQL DECIMAL string: " + orig)
+	}
+	return Decimal(str), nil
+}
+
+func (d Decimal) String() string {
+	return string(d)
+}
+
+// Float64 converts d to float64. The result can be inexact.
+func (d Decimal) Float64() (float64, error) {
+	return strconv.ParseFloat(string(d), 64)
+}
+
+// Value implements database/sql/driver.Valuer interface.
+func (d Decimal) Value() (driver.Value, error) {
+	return string(d), nil
+}
+
+// DecimalMode specifies how DECIMAL values are returned by prepared
+// statements (text queries always return []byte).
+type DecimalMode int
+
+// Decimal modes
+const (
+	DecimalFloat DecimalMode = iota // float64, can lose precision (default)
+	DecimalExact                    // Decimal ([]byte if NarrowTypeSet)
+)
@@ -1,6 +1,7 @@
 package mysql
 
 import (
+	"math"
 	"testing"
 	"time"
 )
@@ -98,3 +99,97 @@ func TestEscapeQuotes(t *testing.T) {
 		t.Fatalf("escapeString: ret='%s' exp='%s'", out, exp)
 	}
 }
+
+var decimals = []sio{
+	sio{"12345678901234.123456", "12345678901234.123456"},
+	sio{" -0.100 ", "-0.100"},
+	sio{"+5", "5"},
+	sio{".5", ".5"},
+	sio{"1.2.3", "invalid MySQL DECIMAL string: 1.2.3"},
+	sio{"-", "invalid MySQL DECIMAL string: -"},
+	sio{"1e5", "invalid MySQL DECIMAL string: 1e5"},
+}
+
+func TestConvDecimal(t *testing.T) {
+	conv := func(str string) interface{} {
+		d, err := ParseDecimal(str)
+		if err != nil {
+			return err
+		}
+		return d
+	}
+	checkRow(t, decimals, conv)
+
+	row := Row{[]byte("99999999999999.999999"), int64(-3), 0.25, nil}
+	for i, exp := range []Decimal{"99999999999999.999999", "-3", "0.25", ""} {
+		if d := row.Decimal(i); d != exp {
+			t.Fatalf("Row.Decimal(%d): ret='%s' exp='%s'", i, d, exp)
+		}
+	}
+}
+
+type statusConn struct {
+	Conn
+	status ConnStatus
+}
+
+func (c statusConn) Status() ConnStatus {
+	return c.status
+}
+
+func TestInterpolate(t *testing.T) {
+	i8 := int8(-5)
+	var np *int
+	examples := []struct {
+		sql    string
+		params []interface{}
+		out    string
+	}{
+		{"SELECT ?, ?, ?", []interface{}{nil, "it's", []byte{0, 0xff}},
+			`SELECT NULL, 'it\'s', X'00ff'`},
+		{"SELECT ?, ?, ?, ?", []interface{}{uint16(7), &i8, np, true},
+			`SELECT 7, -5, NULL, 1`},
+		{"SELECT ?, ?", []interface{}{0.25, Decimal("-1.50")},
+			`SELECT 0.25, -1.50`},
+		{"SELECT ?, ?", []interface{}{
+			time.Date(2020, 1, 2, 3, 4, 5, 0, time.Local), 90 * time.Minute},
+			`SELECT '2020-01-02 03:04:05', '1:30:00'`},
+		{"SELECT '?', \"\\\"?\", `a?``?`, ? -- ?\n# ?\n/* ? */", []interface{}{1},
+			"SELECT '?', \"\\\"?\", `a?``?`, 1 -- ?\n# ?\n/* ? */"},
+		{"SELECT 1--?", []interface{}{2}, "SELECT 1--2"},
+	}
+	c := statusConn{}
+	for _, ex := range examples {
+		out, err := Interpolate(c, ex.sql, ex.params...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != ex.out {
+			t.Fatalf("Interpolate: ret=%q exp=%q", out, ex.out)
+		}
+	}
+
+	c.status = SERVER_STATUS_NO_BACKSLASH_ESCAPES
+	out, err := Interpolate(c, `SELECT 'a\', ?`, `x'\`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := `SELECT 'a\', 'x''\'`; out != exp {
+		t.Fatalf("Interpolate: ret=%q exp=%q", out, exp)
+	}
+
+	if _, err = Interpolate(c, "SELECT ?, ?", 1); err != ErrBindCount {
+		t.Fatal("Wrong number of parameters wasn't detected:", err)
+	}
+	if _, err = Interpolate(c, "SELECT ?", struct{}{}); err != ErrBindUnkType {
+		t.Fatal("Unknown type wasn't detected:", err)
+	}
+	if _, err = Interpolate(c, "SELECT ?", Decimal("1; DROP")); err == nil {
+		t.Fatal("Bad decimal wasn't detected")
+	}
+	for _, f := range []interface{}{math.NaN(), math.Inf(1), float32(math.Inf(-1))} {
+		if _, err = Interpolate(c, "SELECT ?", f); err == nil {
+			t.Fatal("Float without literal wasn't detected:", f)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package mysql
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Warning is a note, warning or error generated by a statement (one row of
+// SHOW WARNINGS).
+type Warning struct {
+	Level   string // "Note", "Warning" or "Error"
+	Code    uint16
+	Message string
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("%s %d: %s", w.Level, w.Code, w.Message)
+}
+
+// WarningError is returned instead of the result in the strict warnings mode
+// (see Conn.StrictWarnings) if the statement generated warnings.
+type WarningError struct {
+	Warnings []Warning
+}
+
+func (e *WarningError) Error() string {
+	if len(e.Warnings) == 0 {
+		return "statement generated warnings"
+	}
+	s := make([]string, len(e.Warnings))
+	for i, w := range e.Warnings {
+		s[i] = w.String()
+	}
+	return "statement generated warnings: " + strings.Join(s, "; ")
+}
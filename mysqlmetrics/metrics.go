@@ -0,0 +1,156 @@
+// Package mysqlmetrics provides Collector, an in-memory implementation of
+// mysql.Metrics.
+//
+// Example:
+//
+//	m := mysqlmetrics.New()
+//	db := mysql.New("tcp", "", "127.0.0.1:3306", user, pass, dbname)
+//	db.SetMetrics(m)
+//	// [...]
+//	s := m.Snapshot()
+//	fmt.Println(s.Counters[mysql.Queries], s.Errors[mysql.ER_LOCK_DEADLOCK])
+//
+// Use the prometheus subpackage to export the collected metrics.
+package mysqlmetrics
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ziutek/mymysql/mysql"
+)
+
+// DefaultBuckets contains upper bounds of histogram buckets used by New if no
+// buckets are specified.
+var DefaultBuckets = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+type histogram struct {
+	counts []uint64 // Non-cumulative, the last one is +Inf
+	count  uint64
+	sum    int64
+}
+
+// Collector collects metrics from any number of connections. It is safe for
+// concurrent use.
+type Collector struct {
+	buckets  []time.Duration
+	counters []uint64 // Indexed by mysql.Metric
+	hists    map[mysql.Metric]*histogram
+
+	mutex  sync.Mutex
+	errors map[uint16]uint64
+}
+
+// New creates a new collector. buckets are the upper bounds of histogram
+// buckets (DefaultBuckets if not specified).
+func New(buckets ...time.Duration) *Collector {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]time.Duration(nil), buckets...)
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i] < buckets[j] })
+	max := mysql.Metric(0)
+	for _, m := range mysql.Counters {
+		if m > max {
+			max = m
+		}
+	}
+	c := &Collector{
+		buckets:  buckets,
+		counters: make([]uint64, max+1),
+		hists:    make(map[mysql.Metric]*histogram),
+		errors:   make(map[uint16]uint64),
+	}
+	for _, m := range mysql.Histograms {
+		c.hists[m] = &histogram{counts: make([]uint64, len(buckets)+1)}
+	}
+	return c
+}
+
+// Add implements mysql.Metrics.
+func (c *Collector) Add(m mysql.Metric, n uint64) {
+	if m >= 0 && int(m) < len(c.counters) {
+		atomic.AddUint64(&c.counters[m], n)
+	}
+}
+
+// Observe implements mysql.Metrics.
+func (c *Collector) Observe(m mysql.Metric, d time.Duration) {
+	h := c.hists[m]
+	if h == nil {
+		return
+	}
+	i := sort.Search(len(c.buckets), func(i int) bool { return d <= c.buckets[i] })
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddUint64(&h.count, 1)
+	atomic.AddInt64(&h.sum, int64(d))
+}
+
+// Error implements mysql.Metrics.
+func (c *Collector) Error(code uint16) {
+	c.mutex.Lock()
+	c.errors[code]++
+	c.mutex.Unlock()
+}
+
+// Histogram contains a snapshot of histogram.
+type Histogram struct {
+	Buckets []time.Duration // Upper bounds of buckets
+	Counts  []uint64        // Cumulative counts (number of values <= bucket)
+	Count   uint64          // Number of all values
+	Sum     time.Duration   // Sum of all values
+}
+
+// Snapshot contains values of all metrics at some point in time.
+type Snapshot struct {
+	Counters   map[mysql.Metric]uint64
+	Errors     map[uint16]uint64 // Number of errors by MySQL error code (0 for other errors)
+	Histograms map[mysql.Metric]Histogram
+}
+
+// Snapshot returns the current values of all metrics.
+func (c *Collector) Snapshot() Snapshot {
+	s := Snapshot{
+		Counters:   make(map[mysql.Metric]uint64),
+		Errors:     make(map[uint16]uint64),
+		Histograms: make(map[mysql.Metric]Histogram),
+	}
+	for _, m := range mysql.Counters {
+		s.Counters[m] = atomic.LoadUint64(&c.counters[m])
+	}
+	for m, h := range c.hists {
+		sh := Histogram{
+			Buckets: c.buckets,
+			Counts:  make([]uint64, len(c.buckets)),
+			Count:   atomic.LoadUint64(&h.count),
+			Sum:     time.Duration(atomic.LoadInt64(&h.sum)),
+		}
+		var n uint64
+		for i := range c.buckets {
+			n += atomic.LoadUint64(&h.counts[i])
+			sh.Counts[i] = n
+		}
+		s.Histograms[m] = sh
+	}
+	c.mutex.Lock()
+	for code, n := range c.errors {
+		s.Errors[code] = n
+	}
+	c.mutex.Unlock()
+	return s
+}
@@ -0,0 +1,95 @@
+package mysqlmetrics
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ziutek/mymysql/mysql"
+	"github.com/ziutek/mymysql/mysqltest"
+	"github.com/ziutek/mymysql/native"
+	"github.com/ziutek/mymysql/thrsafe"
+)
+
+const (
+	user   = "testuser"
+	passwd = "TestPasswd9"
+)
+
+func startServer(t *testing.T) *mysqltest.Server {
+	srv := &mysqltest.Server{User: user, Passwd: passwd}
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	srv.HandleQuery("select 1", mysqltest.Rows("1").AddRow(1))
+	srv.HandleQuery("bad", mysqltest.Err(mysql.ER_PARSE_ERROR, "syntax"))
+	return srv
+}
+
+func checkErr(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCollector(t *testing.T) {
+	srv := startServer(t)
+	defer srv.Close()
+	m := New()
+	c := native.New("tcp", "", srv.Addr(), user, passwd)
+	c.SetMetrics(m)
+	checkErr(t, c.Connect())
+	defer c.Close()
+	_, _, err := c.Query("select 1")
+	checkErr(t, err)
+	if _, _, err = c.Query("bad"); err == nil {
+		t.Fatal("No error")
+	}
+	checkErr(t, c.Reconnect())
+
+	s := m.Snapshot()
+	cnt := s.Counters
+	if cnt[mysql.Queries] != 2 || cnt[mysql.Connects] != 2 ||
+		cnt[mysql.Reconnects] != 1 || cnt[mysql.Execs] != 0 ||
+		cnt[mysql.BytesIn] == 0 || cnt[mysql.BytesOut] == 0 ||
+		cnt[mysql.PacketsIn] == 0 || cnt[mysql.PacketsOut] == 0 {
+		t.Fatalf("Bad counters: %v", cnt)
+	}
+	if len(s.Errors) != 1 || s.Errors[mysql.ER_PARSE_ERROR] != 1 {
+		t.Fatalf("Bad errors: %v", s.Errors)
+	}
+	h := s.Histograms[mysql.QueryDuration]
+	if h.Count != 2 || h.Counts[len(h.Counts)-1] != 2 || h.Sum <= 0 {
+		t.Fatalf("Bad histogram: %+v", h)
+	}
+}
+
+func TestLockWait(t *testing.T) {
+	srv := startServer(t)
+	defer srv.Close()
+	srv.HandleQuery("select sleep", mysqltest.Delay(20*time.Millisecond),
+		mysqltest.Rows("1").AddRow(1))
+	m := New()
+	c := thrsafe.New("tcp", "", srv.Addr(), user, passwd)
+	c.SetMetrics(m)
+	checkErr(t, c.Connect())
+	defer c.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := c.Query("select sleep")
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	h := m.Snapshot().Histograms[mysql.LockWait]
+	if h.Count < 2 || h.Sum < 10*time.Millisecond {
+		t.Fatalf("Bad lock wait: %+v", h)
+	}
+}
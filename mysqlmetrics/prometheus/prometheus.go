@@ -0,0 +1,100 @@
+// Package prometheus exports metrics collected by mysqlmetrics.Collector in
+// the Prometheus text exposition format, without depending on the Prometheus
+// client library.
+//
+// Example:
+//
+//	m := mysqlmetrics.New()
+//	godrv.SetMetrics(m)
+//	http.Handle("/metrics", prometheus.Handler(m))
+//
+// Exported metrics (all prefixed with mymysql_):
+//
+//	bytes_in_total, bytes_out_total, packets_in_total, packets_out_total,
+//	connects_total, reconnects_total, queries_total, execs_total,
+//	retries_total, errors_total{code="..."},
+//	query_duration_seconds, lock_wait_seconds (histograms).
+package prometheus
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/ziutek/mymysql/mysql"
+	"github.com/ziutek/mymysql/mysqlmetrics"
+)
+
+// Prefix of all metric names.
+const Prefix = "mymysql_"
+
+var help = map[mysql.Metric]string{
+	mysql.BytesIn:       "Bytes read from MySQL connections.",
+	mysql.BytesOut:      "Bytes written to MySQL connections.",
+	mysql.PacketsIn:     "MySQL protocol packets received.",
+	mysql.PacketsOut:    "MySQL protocol packets sent.",
+	mysql.Connects:      "Successful connects and reconnects.",
+	mysql.Reconnects:    "Reconnect attempts (including autorc).",
+	mysql.Queries:       "Text queries.",
+	mysql.Execs:         "Executions of prepared statements.",
+	mysql.Retries:       "Operations retried by autorc.",
+	mysql.QueryDuration: "Time of queries and executions including reading the response.",
+	mysql.LockWait:      "Time waiting for the lock of thrsafe connection.",
+}
+
+func seconds(n int64) string {
+	return strconv.FormatFloat(float64(n)/1e9, 'g', -1, 64)
+}
+
+// Write writes s to w in the Prometheus text format.
+func Write(w io.Writer, s mysqlmetrics.Snapshot) error {
+	bw := bufio.NewWriter(w)
+	for _, m := range mysql.Counters {
+		name := Prefix + m.String() + "_total"
+		bw.WriteString("# HELP " + name + " " + help[m] + "\n")
+		bw.WriteString("# TYPE " + name + " counter\n")
+		bw.WriteString(name + " " + strconv.FormatUint(s.Counters[m], 10) + "\n")
+	}
+
+	name := Prefix + "errors_total"
+	bw.WriteString("# HELP " + name + " Errors by MySQL error code (0 for other errors).\n")
+	bw.WriteString("# TYPE " + name + " counter\n")
+	codes := make([]int, 0, len(s.Errors))
+	for code := range s.Errors {
+		codes = append(codes, int(code))
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		bw.WriteString(name + `{code="` + strconv.Itoa(code) + `"} ` +
+			strconv.FormatUint(s.Errors[uint16(code)], 10) + "\n")
+	}
+
+	for _, m := range mysql.Histograms {
+		h, ok := s.Histograms[m]
+		if !ok {
+			continue
+		}
+		name := Prefix + m.String() + "_seconds"
+		bw.WriteString("# HELP " + name + " " + help[m] + "\n")
+		bw.WriteString("# TYPE " + name + " histogram\n")
+		for i, b := range h.Buckets {
+			bw.WriteString(name + `_bucket{le="` + seconds(int64(b)) + `"} ` +
+				strconv.FormatUint(h.Counts[i], 10) + "\n")
+		}
+		count := strconv.FormatUint(h.Count, 10)
+		bw.WriteString(name + `_bucket{le="+Inf"} ` + count + "\n")
+		bw.WriteString(name + "_sum " + seconds(int64(h.Sum)) + "\n")
+		bw.WriteString(name + "_count " + count + "\n")
+	}
+	return bw.Flush()
+}
+
+// Handler returns a HTTP handler that serves the metrics collected by c.
+func Handler(c *mysqlmetrics.Collector) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		Write(w, c.Snapshot())
+	})
+}
@@ -0,0 +1,44 @@
+package prometheus
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ziutek/mymysql/mysql"
+	"github.com/ziutek/mymysql/mysqlmetrics"
+)
+
+func TestWrite(t *testing.T) {
+	m := mysqlmetrics.New(10*time.Millisecond, 100*time.Millisecond)
+	m.Add(mysql.Queries, 3)
+	m.Add(mysql.Retries, 1)
+	m.Error(mysql.ER_LOCK_DEADLOCK)
+	m.Error(0)
+	m.Observe(mysql.QueryDuration, 5*time.Millisecond)
+	m.Observe(mysql.QueryDuration, 50*time.Millisecond)
+	m.Observe(mysql.QueryDuration, time.Second)
+
+	var buf bytes.Buffer
+	if err := Write(&buf, m.Snapshot()); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, exp := range []string{
+		"# TYPE mymysql_queries_total counter\nmymysql_queries_total 3\n",
+		"mymysql_retries_total 1\n",
+		"mymysql_errors_total{code=\"0\"} 1\nmymysql_errors_total{code=\"1213\"} 1\n",
+		"# TYPE mymysql_query_duration_seconds histogram\n" +
+			"mymysql_query_duration_seconds_bucket{le=\"0.01\"} 1\n" +
+			"mymysql_query_duration_seconds_bucket{le=\"0.1\"} 2\n" +
+			"mymysql_query_duration_seconds_bucket{le=\"+Inf\"} 3\n" +
+			"mymysql_query_duration_seconds_sum 1.055\n" +
+			"mymysql_query_duration_seconds_count 3\n",
+		"mymysql_lock_wait_seconds_count 0\n",
+	} {
+		if !strings.Contains(out, exp) {
+			t.Fatalf("%q not found in:\n%s", exp, out)
+		}
+	}
+}
@@ -0,0 +1,744 @@
+package mysqltest
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/ziutek/mymysql/mysql"
+)
+
+type stmt struct {
+	id     uint32
+	sql    string
+	params int
+	types  []uint16
+	long   map[int][]byte
+	cursor *ResultSet // Rows not yet fetched from the open cursor
+}
+
+// conn is a server side of a client connection.
+type conn struct {
+	srv      *Server
+	raw_conn net.Conn // Closed by Server.Close and KILL CONNECTION
+	net_conn net.Conn
+	rd       *bufio.Reader
+	wr       *bufio.Writer
+	hdr      [4]byte
+	seq      byte
+	cio      *compressIO // nil if compression isn't used
+	thr_id   uint32
+	kill     chan struct{}
+
+	caps       uint32 // Capabilities of the client
+	scramble   []byte
+	user       string
+	db         string
+	srv_status mysql.ConnStatus
+
+	stmts       map[uint32]*stmt
+	last_stmtid uint32
+}
+
+func newConn(srv *Server, nc net.Conn, thr_id uint32) *conn {
+	return &conn{
+		srv:        srv,
+		raw_conn:   nc,
+		net_conn:   nc,
+		rd:         bufio.NewReader(nc),
+		wr:         bufio.NewWriter(nc),
+		thr_id:     thr_id,
+		kill:       make(chan struct{}, 1),
+		srv_status: mysql.SERVER_STATUS_AUTOCOMMIT,
+		stmts:      make(map[uint32]*stmt),
+	}
+}
+
+func (c *conn) serve() {
+	defer c.net_conn.Close()
+	if !c.handshake() {
+		return
+	}
+	for {
+		pkt, err := c.readPacket()
+		if err != nil || len(pkt) == 0 {
+			return
+		}
+		// Discard KILL QUERY that arrived when no command was executed
+		select {
+		case <-c.kill:
+		default:
+		}
+		if !c.dispatch(pkt) {
+			return
+		}
+		if c.flush() != nil {
+			return
+		}
+	}
+}
+
+// Handshake
+
+// bufConn is net.Conn that reads from rd.
+type bufConn struct {
+	net.Conn
+	rd *bufio.Reader
+}
+
+func (c bufConn) Read(buf []byte) (int, error) {
+	return c.rd.Read(buf)
+}
+
+func (c *conn) serverCaps() uint32 {
+	caps := uint32(_CLIENT_LONG_PASSWORD | _CLIENT_LONG_FLAG |
+		_CLIENT_CONNECT_WITH_DB | _CLIENT_LOCAL_FILES | _CLIENT_PROTOCOL_41 |
+		_CLIENT_TRANSACTIONS | _CLIENT_SECURE_CONN | _CLIENT_MULTI_STATEMENTS |
+		_CLIENT_MULTI_RESULTS | _CLIENT_PS_MULTI_RESULTS | _CLIENT_PLUGIN_AUTH |
+		_CLIENT_PLUGIN_AUTH_LENENC_CLIENT_DATA | _CLIENT_CONNECT_ATTRS |
+		_CLIENT_SESSION_TRACK)
+	if c.srv.TLSConfig != nil {
+		caps |= _CLIENT_SSL
+	}
+	if c.srv.Compress {
+		caps |= _CLIENT_COMPRESS
+	}
+	if !c.srv.NoDeprecateEOF {
+		caps |= _CLIENT_DEPRECATE_EOF
+	}
+	return caps
+}
+
+func (c *conn) plugin() string {
+	if c.srv.Plugin == "" {
+		return "mysql_native_password"
+	}
+	return c.srv.Plugin
+}
+
+func newScramble() []byte {
+	b := make([]byte, 20)
+	rand.Read(b)
+	for i := range b {
+		// Scramble can't contain 0 or '$'
+		b[i] = b[i]&0x7f | 1
+		if b[i] == '$' {
+			b[i]++
+		}
+	}
+	return b
+}
+
+func (c *conn) writeHandshake() error {
+	version := c.srv.Version
+	if version == "" {
+		version = "8.0.0-mysqltest"
+	}
+	caps := c.serverCaps()
+	c.scramble = newScramble()
+	pkt := appendNT([]byte{10}, version)
+	pkt = appendU32(pkt, c.thr_id)
+	pkt = append(append(pkt, c.scramble[:8]...), 0)
+	pkt = appendU16(pkt, uint16(caps))
+	pkt = append(pkt, _CHARSET_UTF8)
+	pkt = appendU16(pkt, uint16(c.srv_status))
+	pkt = appendU16(pkt, uint16(caps>>16))
+	pkt = append(pkt, byte(len(c.scramble)+1))
+	pkt = append(pkt, make([]byte, 10)...)
+	pkt = append(append(pkt, c.scramble[8:]...), 0)
+	pkt = appendNT(pkt, c.plugin())
+	if err := c.writePacket(pkt); err != nil {
+		return err
+	}
+	return c.flush()
+}
+
+// handshake performs the connection phase. It returns false if the
+// connection should be closed.
+func (c *conn) handshake() bool {
+	if c.writeHandshake() != nil {
+		return false
+	}
+	pkt, err := c.readPacket()
+	if err != nil {
+		return false
+	}
+	d := &decoder{buf: pkt}
+	c.caps = d.u32()
+	if c.caps&_CLIENT_SSL != 0 && len(pkt) == 32 && c.srv.TLSConfig != nil {
+		// SSLRequest. The client hello can be already buffered in c.rd.
+		tc := tls.Server(bufConn{c.net_conn, c.rd}, c.srv.TLSConfig)
+		if tc.Handshake() != nil {
+			return false
+		}
+		c.net_conn = tc
+		c.rd = bufio.NewReader(tc)
+		c.wr = bufio.NewWriter(tc)
+		if pkt, err = c.readPacket(); err != nil {
+			return false
+		}
+		d = &decoder{buf: pkt}
+		c.caps = d.u32()
+	}
+	d.next(4 + 1 + 23) // max packet size, charset, filler
+	c.user = d.nt()
+	var auth []byte
+	switch {
+	case c.caps&_CLIENT_PLUGIN_AUTH_LENENC_CLIENT_DATA != 0:
+		auth = d.bin()
+	case c.caps&_CLIENT_SECURE_CONN != 0:
+		auth = d.next(int(d.byte()))
+	default:
+		auth = []byte(d.nt())
+	}
+	if c.caps&_CLIENT_CONNECT_WITH_DB != 0 {
+		c.db = d.nt()
+	}
+	plugin := c.plugin()
+	if c.caps&_CLIENT_PLUGIN_AUTH != 0 {
+		plugin = d.nt()
+	}
+	if c.caps&_CLIENT_CONNECT_ATTRS != 0 {
+		ad := &decoder{buf: d.bin()}
+		attrs := make(map[string]string)
+		for len(ad.buf) > 0 && !ad.bad {
+			k := ad.bin()
+			attrs[string(k)] = string(ad.bin())
+		}
+		d.bad = d.bad || ad.bad
+		c.srv.recordAttrs(c.thr_id, attrs)
+	}
+	if d.bad {
+		c.writeError(Err(mysql.ER_HANDSHAKE_ERROR, "Bad handshake"))
+		c.flush()
+		return false
+	}
+	if !c.authenticate(plugin, auth) || c.flush() != nil {
+		return false
+	}
+	if c.caps&_CLIENT_COMPRESS != 0 && c.srv.Compress {
+		c.cio = &compressIO{rw: c.net_conn}
+		c.rd = bufio.NewReader(c.cio)
+		c.wr = bufio.NewWriter(c.cio)
+	}
+	return true
+}
+
+// authenticate checks the authentication response of c.user (sending the
+// auth switch request first if configured) and sends OK or access denied
+// error. It returns false if the authentication failed.
+func (c *conn) authenticate(plugin string, auth []byte) bool {
+	if sw := c.srv.AuthSwitch; sw != "" {
+		plugin = sw
+		pkt := appendNT([]byte{0xfe}, plugin)
+		if plugin == "client_ed25519" || plugin == "parsec" {
+			// 32 bytes scramble without terminating zero
+			c.scramble = make([]byte, 32)
+			rand.Read(c.scramble)
+			pkt = append(pkt, c.scramble...)
+		} else {
+			c.scramble = newScramble()
+			pkt = append(append(pkt, c.scramble...), 0)
+		}
+		if c.writePacket(pkt) != nil || c.flush() != nil {
+			return false
+		}
+		var err error
+		if auth, err = c.readPacket(); err != nil {
+			return false
+		}
+	}
+	var salt []byte
+	if plugin == "parsec" {
+		// The client requests the salt with an empty packet
+		if len(auth) != 0 {
+			return false
+		}
+		salt = make([]byte, 18)
+		rand.Read(salt)
+		pkt := append([]byte{'P', 0}, salt...) // 1024 << 0 iterations
+		if c.writePacket(pkt) != nil || c.flush() != nil {
+			return false
+		}
+		var err error
+		if auth, err = c.readPacket(); err != nil {
+			return false
+		}
+	}
+
+	passwd, ok := c.srv.passwd(c.user)
+	if ok {
+		if plugin == "parsec" {
+			ok = checkParsec(passwd, salt, c.scramble, auth)
+		} else {
+			ok = checkAuth(plugin, passwd, c.scramble, auth)
+		}
+	}
+	if !ok {
+		c.writeError(&Error{
+			Code:  mysql.ER_ACCESS_DENIED_ERROR,
+			State: "28000",
+			Msg:   "Access denied for user '" + c.user + "'",
+		})
+		c.flush()
+		return false
+	}
+	if plugin == "caching_sha2_password" && passwd != "" {
+		// Fast authentication success
+		if c.writePacket([]byte{1, 3}) != nil {
+			return false
+		}
+	}
+	return c.writeOK(&OK{}, false) == nil
+}
+
+// checkAuth checks the authentication response of the client.
+func checkAuth(plugin, passwd string, scramble, auth []byte) bool {
+	var expected []byte
+	switch plugin {
+	case "mysql_native_password":
+		expected = scrambleSHA1(passwd, scramble)
+	case "caching_sha2_password":
+		expected = scrambleSHA256(passwd, scramble)
+	case "client_ed25519":
+		return checkEd25519(passwd, scramble, auth)
+	case "mysql_clear_password":
+		expected = append([]byte(passwd), 0)
+	default:
+		return false
+	}
+	return bytes.Equal(auth, expected)
+}
+
+// SHA1(password) XOR SHA1(scramble, SHA1(SHA1(password)))
+func scrambleSHA1(passwd string, scramble []byte) []byte {
+	if passwd == "" {
+		return nil
+	}
+	stage1 := sha1.Sum([]byte(passwd))
+	stage2 := sha1.Sum(stage1[:])
+	h := sha1.New()
+	h.Write(scramble)
+	h.Write(stage2[:])
+	out := h.Sum(nil)
+	for i := range out {
+		out[i] ^= stage1[i]
+	}
+	return out
+}
+
+// SHA256(password) XOR SHA256(SHA256(SHA256(password)), scramble)
+func scrambleSHA256(passwd string, scramble []byte) []byte {
+	if passwd == "" {
+		return nil
+	}
+	stage1 := sha256.Sum256([]byte(passwd))
+	stage2 := sha256.Sum256(stage1[:])
+	h := sha256.New()
+	h.Write(stage2[:])
+	h.Write(scramble)
+	out := h.Sum(nil)
+	for i := range out {
+		out[i] ^= stage1[i]
+	}
+	return out
+}
+
+// Command phase
+
+// dispatch handles the command in pkt. It returns false if the connection
+// should be closed.
+func (c *conn) dispatch(pkt []byte) bool {
+	cmd := &Command{ThreadId: c.thr_id, Cmd: pkt[0], Data: pkt[1:]}
+	d := &decoder{buf: cmd.Data}
+	switch cmd.Cmd {
+	case COM_QUERY, COM_INIT_DB:
+		cmd.Query = string(cmd.Data)
+	case COM_CHANGE_USER:
+		cmd.Query = d.nt()
+	case COM_STMT_PREPARE:
+		cmd.Query = string(cmd.Data)
+		c.last_stmtid++
+		cmd.StmtId = c.last_stmtid
+	case COM_STMT_EXECUTE, COM_STMT_SEND_LONG_DATA, COM_STMT_CLOSE,
+		COM_STMT_RESET, COM_STMT_FETCH:
+		cmd.StmtId = d.u32()
+		if st := c.stmts[cmd.StmtId]; st != nil {
+			cmd.Query = st.sql
+			switch cmd.Cmd {
+			case COM_STMT_EXECUTE:
+				st.decodeArgs(cmd, d)
+			case COM_STMT_FETCH:
+				cmd.FetchRows = d.u32()
+			}
+		}
+	}
+	c.srv.record(cmd)
+
+	switch cmd.Cmd {
+	case COM_QUERY:
+		return c.query(cmd)
+	case COM_STMT_PREPARE:
+		return c.prepare(cmd)
+	case COM_STMT_EXECUTE:
+		return c.execute(cmd)
+	}
+	if f := c.srv.cmdHandler(cmd.Cmd); f != nil {
+		return c.respond(cmd, f(cmd), false)
+	}
+	switch cmd.Cmd {
+	case COM_QUIT:
+		return false
+	case COM_PING:
+		return c.writeOK(&OK{}, false) == nil
+	case COM_INIT_DB:
+		c.db = cmd.Query
+		return c.writeOK(&OK{Session: []mysql.SessionChange{
+			{Type: mysql.SessionTrackSchema, Value: c.db},
+		}}, false) == nil
+	case COM_STMT_SEND_LONG_DATA:
+		if st := c.stmts[cmd.StmtId]; st != nil {
+			n := int(d.u16())
+			st.long[n] = append(st.long[n], d.rest()...)
+		}
+		return true // No response
+	case COM_STMT_CLOSE:
+		delete(c.stmts, cmd.StmtId)
+		return true // No response
+	case COM_STMT_RESET:
+		st := c.stmts[cmd.StmtId]
+		if st == nil {
+			return c.writeError(unknownStmt(cmd.StmtId)) == nil
+		}
+		st.long = make(map[int][]byte)
+		st.cursor = nil
+		return c.writeOK(&OK{}, false) == nil
+	case COM_STMT_FETCH:
+		return c.fetch(cmd)
+	case COM_RESET_CONNECTION:
+		c.resetSession()
+		return c.writeOK(&OK{}, false) == nil
+	case COM_REGISTER_SLAVE:
+		return c.writeOK(&OK{}, false) == nil
+	case COM_CHANGE_USER:
+		return c.changeUser(cmd.Query, d)
+	}
+	return c.writeError(Err(mysql.ER_UNKNOWN_COM_ERROR, "Unknown command")) == nil
+}
+
+// resetSession closes all prepared statements.
+func (c *conn) resetSession() {
+	c.stmts = make(map[uint32]*stmt)
+	c.srv_status = mysql.SERVER_STATUS_AUTOCOMMIT
+}
+
+// changeUser authenticates the user from COM_CHANGE_USER (d points after
+// the user name). The connection is closed if the authentication fails.
+func (c *conn) changeUser(user string, d *decoder) bool {
+	var auth []byte
+	if c.caps&_CLIENT_SECURE_CONN != 0 {
+		auth = d.next(int(d.byte()))
+	} else {
+		auth = []byte(d.nt())
+	}
+	db := d.nt()
+	plugin := c.plugin()
+	if len(d.buf) > 0 {
+		d.u16() // charset
+		if c.caps&_CLIENT_PLUGIN_AUTH != 0 {
+			plugin = d.nt()
+		}
+	}
+	if d.bad {
+		c.writeError(Err(mysql.ER_UNKNOWN_COM_ERROR, "Bad change user packet"))
+		c.flush()
+		return false
+	}
+	c.user = user
+	if !c.authenticate(plugin, auth) {
+		return false
+	}
+	c.db = db
+	c.resetSession()
+	return true
+}
+
+func unknownStmt(id uint32) *Error {
+	return Err(mysql.ER_UNKNOWN_STMT_HANDLER, fmt.Sprintf(
+		"Unknown prepared statement handler (%d) given to mysqltest", id))
+}
+
+func (c *conn) query(cmd *Command) bool {
+	f := c.srv.handler(cmd.Query)
+	if f == nil {
+		return c.writeError(noHandler(cmd.Query)) == nil
+	}
+	return c.respond(cmd, f(cmd), false)
+}
+
+func (c *conn) prepare(cmd *Command) bool {
+	f := c.srv.handler(cmd.Query)
+	if f == nil {
+		return c.writeError(noHandler(cmd.Query)) == nil
+	}
+	var cols []Column
+	for _, r := range f(cmd) {
+		if e, ok := r.(*Error); ok {
+			return c.writeError(e) == nil
+		}
+		if rs, ok := r.(*ResultSet); ok {
+			cols = rs.Columns
+			break
+		}
+	}
+	st := &stmt{
+		id:     cmd.StmtId,
+		sql:    cmd.Query,
+		params: countParams(cmd.Query),
+		long:   make(map[int][]byte),
+	}
+	c.stmts[st.id] = st
+
+	pkt := appendU32([]byte{0}, st.id)
+	pkt = appendU16(pkt, uint16(len(cols)))
+	pkt = appendU16(pkt, uint16(st.params))
+	pkt = append(pkt, 0, 0, 0) // filler, warnings
+	if c.writePacket(pkt) != nil {
+		return false
+	}
+	status := c.status(0, false)
+	if st.params > 0 {
+		params := make([]Column, st.params)
+		for i := range params {
+			params[i].Name = "?"
+		}
+		if c.writeColumns(params, status) != nil {
+			return false
+		}
+	}
+	if len(cols) > 0 {
+		if c.writeColumns(cols, status) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// decodeArgs decodes parameters of COM_STMT_EXECUTE.
+func (st *stmt) decodeArgs(cmd *Command, d *decoder) {
+	cmd.Flags = d.byte()
+	d.u32() // iteration count
+	if st.params == 0 {
+		return
+	}
+	null_bitmap := d.next((st.params + 7) >> 3)
+	if d.byte() == 1 {
+		st.types = make([]uint16, st.params)
+		for i := range st.types {
+			st.types[i] = d.u16()
+		}
+	}
+	cmd.Args = make([]interface{}, st.params)
+	for i := range cmd.Args {
+		if data, ok := st.long[i]; ok {
+			cmd.Args[i] = data
+		} else if len(null_bitmap) > i>>3 &&
+			null_bitmap[i>>3]&(1<<uint(i&7)) != 0 {
+			cmd.Args[i] = nil
+		} else if i < len(st.types) {
+			cmd.Args[i] = d.binValue(st.types[i])
+		}
+	}
+	st.long = make(map[int][]byte)
+}
+
+func (c *conn) execute(cmd *Command) bool {
+	st := c.stmts[cmd.StmtId]
+	if st == nil {
+		return c.writeError(unknownStmt(cmd.StmtId)) == nil
+	}
+	st.cursor = nil
+	f := c.srv.handler(st.sql)
+	if f == nil {
+		return c.writeError(noHandler(st.sql)) == nil
+	}
+	results := f(cmd)
+	if cmd.Flags&_CURSOR_TYPE_READ_ONLY != 0 && len(results) == 1 {
+		if rs, ok := results[0].(*ResultSet); ok {
+			return c.openCursor(st, rs) == nil
+		}
+	}
+	return c.respond(cmd, results, true)
+}
+
+// openCursor sends the columns of rs and keeps its rows for COM_STMT_FETCH.
+func (c *conn) openCursor(st *stmt, rs *ResultSet) error {
+	status := c.status(rs.Status, false) | uint16(mysql.SERVER_STATUS_CURSOR_EXISTS)
+	err := c.writePacket(appendLCB(nil, uint64(len(rs.Columns))))
+	if err != nil {
+		return err
+	}
+	if err = c.writeColumns(rs.Columns, status); err != nil {
+		return err
+	}
+	if c.caps&_CLIENT_DEPRECATE_EOF != 0 {
+		// Columns aren't followed by EOF, but the client has to know that
+		// the cursor was opened
+		if err = c.writeEOF(0, status); err != nil {
+			return err
+		}
+	}
+	st.cursor = &ResultSet{
+		Columns:  rs.Columns,
+		Rows:     rs.Rows,
+		Status:   rs.Status,
+		Warnings: rs.Warnings,
+	}
+	return nil
+}
+
+// fetch sends next cmd.FetchRows rows from the open cursor.
+func (c *conn) fetch(cmd *Command) bool {
+	st := c.stmts[cmd.StmtId]
+	if st == nil {
+		return c.writeError(unknownStmt(cmd.StmtId)) == nil
+	}
+	cur := st.cursor
+	if cur == nil {
+		return c.writeError(Err(mysql.ER_STMT_HAS_NO_OPEN_CURSOR, fmt.Sprintf(
+			"The statement (%d) has no open cursor.", cmd.StmtId))) == nil
+	}
+	n := int(cmd.FetchRows)
+	if n > len(cur.Rows) {
+		n = len(cur.Rows)
+	}
+	for _, row := range cur.Rows[:n] {
+		if err := c.writePacket(binRow(cur.Columns, row)); err != nil {
+			return false
+		}
+	}
+	cur.Rows = cur.Rows[n:]
+	status := c.status(cur.Status, false)
+	if len(cur.Rows) == 0 {
+		// The server closes the cursor after the last row
+		status |= uint16(mysql.SERVER_STATUS_LAST_ROW_SENT)
+		st.cursor = nil
+	} else {
+		status |= uint16(mysql.SERVER_STATUS_CURSOR_EXISTS)
+	}
+	return c.writeEOF(cur.Warnings, status) == nil
+}
+
+// respond sends results. It returns false if the connection should be
+// closed.
+func (c *conn) respond(cmd *Command, results []Result, binary bool) bool {
+	// Index of the last OK or result set, to set SERVER_MORE_RESULTS_EXISTS
+	last := -1
+	for i, r := range results {
+		switch r.(type) {
+		case *OK, *ResultSet, *Error:
+			last = i
+		}
+	}
+	var err error
+	for i, r := range results {
+		more := i < last
+		switch r := r.(type) {
+		case *OK:
+			err = c.writeOK(r, more)
+		case *ResultSet:
+			err = c.writeResultSet(r, binary, more)
+		case *Error:
+			return c.writeError(r) == nil
+		case *LocalInfile:
+			if err = c.localInfile(cmd, r); err == nil && i > last {
+				// No next result
+				err = c.writeOK(&OK{}, false)
+			}
+		case BinlogEvent:
+			err = c.writePacket(append([]byte{0}, r...))
+		case Delay:
+			if !c.delay(time.Duration(r)) {
+				return c.writeError(&Error{
+					Code:  mysql.ER_QUERY_INTERRUPTED,
+					State: "70100",
+					Msg:   "Query execution was interrupted",
+				}) == nil
+			}
+		}
+		if err != nil {
+			return false
+		}
+	}
+	switch {
+	case last != -1:
+		return true
+	case isBinlog(results):
+		// End of the binlog
+		return c.writeEOF(0, c.status(0, false)) == nil
+	case !isLocalInfile(results):
+		// Empty response
+		return c.writeOK(&OK{}, false) == nil
+	}
+	return true
+}
+
+func isLocalInfile(results []Result) bool {
+	for _, r := range results {
+		if _, ok := r.(*LocalInfile); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func isBinlog(results []Result) bool {
+	for _, r := range results {
+		if _, ok := r.(BinlogEvent); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// delay waits for d. It returns false if the query was killed.
+func (c *conn) delay(d time.Duration) bool {
+	if err := c.flush(); err != nil {
+		return false
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-c.kill:
+		return false
+	case <-c.srv.closed:
+		return false
+	}
+}
+
+func (c *conn) localInfile(cmd *Command, li *LocalInfile) error {
+	if err := c.writePacket(append([]byte{0xfb}, li.Name...)); err != nil {
+		return err
+	}
+	if err := c.flush(); err != nil {
+		return err
+	}
+	for {
+		pkt, err := c.readPacket()
+		if err != nil {
+			return err
+		}
+		if len(pkt) == 0 {
+			return nil
+		}
+		c.srv.recordInfile(cmd, pkt)
+	}
+}
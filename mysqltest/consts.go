@@ -0,0 +1,80 @@
+package mysqltest
+
+// Command codes of recorded commands.
+const (
+	COM_QUIT                = 0x01
+	COM_INIT_DB             = 0x02
+	COM_QUERY               = 0x03
+	COM_FIELD_LIST          = 0x04
+	COM_STATISTICS          = 0x09
+	COM_PROCESS_KILL        = 0x0c
+	COM_PING                = 0x0e
+	COM_CHANGE_USER         = 0x11
+	COM_BINLOG_DUMP         = 0x12
+	COM_REGISTER_SLAVE      = 0x15
+	COM_STMT_PREPARE        = 0x16
+	COM_STMT_EXECUTE        = 0x17
+	COM_STMT_SEND_LONG_DATA = 0x18
+	COM_STMT_CLOSE          = 0x19
+	COM_STMT_RESET          = 0x1a
+	COM_SET_OPTION          = 0x1b
+	COM_STMT_FETCH          = 0x1c
+	COM_RESET_CONNECTION    = 0x1f
+	COM_BINLOG_DUMP_GTID    = 0x1e
+)
+
+// Capability flags
+const (
+	_CLIENT_LONG_PASSWORD                  = 1 << 0
+	_CLIENT_LONG_FLAG                      = 1 << 2
+	_CLIENT_CONNECT_WITH_DB                = 1 << 3
+	_CLIENT_COMPRESS                       = 1 << 5
+	_CLIENT_LOCAL_FILES                    = 1 << 7
+	_CLIENT_PROTOCOL_41                    = 1 << 9
+	_CLIENT_SSL                            = 1 << 11
+	_CLIENT_TRANSACTIONS                   = 1 << 13
+	_CLIENT_SECURE_CONN                    = 1 << 15
+	_CLIENT_MULTI_STATEMENTS               = 1 << 16
+	_CLIENT_MULTI_RESULTS                  = 1 << 17
+	_CLIENT_PS_MULTI_RESULTS               = 1 << 18
+	_CLIENT_PLUGIN_AUTH                    = 1 << 19
+	_CLIENT_CONNECT_ATTRS                  = 1 << 20
+	_CLIENT_PLUGIN_AUTH_LENENC_CLIENT_DATA = 1 << 21
+	_CLIENT_SESSION_TRACK                  = 1 << 23
+	_CLIENT_DEPRECATE_EOF                  = 1 << 24
+	_CLIENT_ZSTD_COMPRESSION_ALGORITHM     = 1 << 26
+)
+
+// Protocol types
+const (
+	_TYPE_DECIMAL    = 0x00
+	_TYPE_TINY       = 0x01
+	_TYPE_SHORT      = 0x02
+	_TYPE_LONG       = 0x03
+	_TYPE_FLOAT      = 0x04
+	_TYPE_DOUBLE     = 0x05
+	_TYPE_NULL       = 0x06
+	_TYPE_TIMESTAMP  = 0x07
+	_TYPE_LONGLONG   = 0x08
+	_TYPE_INT24      = 0x09
+	_TYPE_DATE       = 0x0a
+	_TYPE_TIME       = 0x0b
+	_TYPE_DATETIME   = 0x0c
+	_TYPE_YEAR       = 0x0d
+	_TYPE_NEWDATE    = 0x0e
+	_TYPE_VAR_STRING = 0xfd
+	_TYPE_STRING     = 0xfe
+)
+
+const (
+	_FLAG_UNSIGNED = 1 << 5
+	_FLAG_BINARY   = 1 << 7
+)
+
+const (
+	_CHARSET_UTF8   = 33
+	_CHARSET_BINARY = 63
+)
+
+// COM_STMT_EXECUTE flags
+const _CURSOR_TYPE_READ_ONLY = 0x01
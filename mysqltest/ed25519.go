@@ -0,0 +1,47 @@
+package mysqltest
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha512"
+
+	"filippo.io/edwards25519"
+)
+
+// MariaDB client_ed25519 and parsec authentication. The fake server stores
+// plain passwords so it derives public keys from them for every check.
+
+// checkEd25519 checks the client_ed25519 signature of scramble. The secret
+// scalar is derived from SHA512(password) like from the seed in RFC 8032.
+func checkEd25519(passwd string, scramble, auth []byte) bool {
+	h := sha512.Sum512([]byte(passwd))
+	a, _ := edwards25519.NewScalar().SetBytesWithClamping(h[:32])
+	pub := new(edwards25519.Point).ScalarBaseMult(a).Bytes()
+	return len(auth) == ed25519.SignatureSize &&
+		ed25519.Verify(pub, scramble, auth)
+}
+
+// checkParsec checks the parsec response: the client scramble followed by
+// the signature of scramble + client scramble. The key seed is
+// PBKDF2-HMAC-SHA512(password, salt) with 1024 iterations.
+func checkParsec(passwd string, salt, scramble, auth []byte) bool {
+	if len(auth) != 32+ed25519.SignatureSize {
+		return false
+	}
+	prf := hmac.New(sha512.New, []byte(passwd))
+	prf.Write(salt)
+	prf.Write([]byte{0, 0, 0, 1})
+	u := prf.Sum(nil)
+	seed := append([]byte{}, u...)
+	for i := 1; i < 1024; i++ {
+		prf.Reset()
+		prf.Write(u)
+		u = prf.Sum(u[:0])
+		for j := range seed {
+			seed[j] ^= u[j]
+		}
+	}
+	pub := ed25519.NewKeyFromSeed(seed[:32]).Public().(ed25519.PublicKey)
+	msg := append(append([]byte{}, scramble...), auth[:32]...)
+	return ed25519.Verify(pub, msg, auth[32:])
+}
@@ -0,0 +1,553 @@
+package mysqltest
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/ziutek/mymysql/mysql"
+)
+
+// readPacket reads a packet (possibly split into many protocol packets).
+func (c *conn) readPacket() ([]byte, error) {
+	var pkt []byte
+	for {
+		if _, err := io.ReadFull(c.rd, c.hdr[:]); err != nil {
+			return nil, err
+		}
+		n := int(uint32(c.hdr[0]) | uint32(c.hdr[1])<<8 | uint32(c.hdr[2])<<16)
+		c.seq = c.hdr[3] + 1
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(c.rd, buf); err != nil {
+			return nil, err
+		}
+		if c.cio != nil {
+			// Like MySQL: the server doesn't check sequence numbers of
+			// packets inside compressed packets and responds with the
+			// sequence number of the next compressed packet
+			c.seq = c.cio.seq
+		}
+		pkt = append(pkt, buf...)
+		if n < 0xffffff {
+			return pkt, nil
+		}
+	}
+}
+
+// writePacket writes a packet to the output buffer. flush sends it.
+func (c *conn) writePacket(pkt []byte) error {
+	for {
+		n := len(pkt)
+		if n > 0xffffff {
+			n = 0xffffff
+		}
+		hdr := [4]byte{byte(n), byte(n >> 8), byte(n >> 16), c.seq}
+		c.seq++
+		if _, err := c.wr.Write(hdr[:]); err != nil {
+			return err
+		}
+		if _, err := c.wr.Write(pkt[:n]); err != nil {
+			return err
+		}
+		pkt = pkt[n:]
+		if n < 0xffffff {
+			return nil
+		}
+	}
+}
+
+func (c *conn) flush() error {
+	err := c.wr.Flush()
+	if c.cio != nil {
+		c.seq = c.cio.seq // See net_flush in MySQL
+	}
+	return err
+}
+
+// Encoding functions
+
+func appendU16(b []byte, v uint16) []byte {
+	return append(b, byte(v), byte(v>>8))
+}
+
+func appendU32(b []byte, v uint32) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func appendU64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func appendLCB(b []byte, v uint64) []byte {
+	switch {
+	case v < 251:
+		return append(b, byte(v))
+	case v <= 0xffff:
+		return appendU16(append(b, 0xfc), uint16(v))
+	case v <= 0xffffff:
+		return append(b, 0xfd, byte(v), byte(v>>8), byte(v>>16))
+	}
+	return appendU64(append(b, 0xfe), v)
+}
+
+func appendBin(b, s []byte) []byte {
+	return append(appendLCB(b, uint64(len(s))), s...)
+}
+
+func appendStr(b []byte, s string) []byte {
+	return append(appendLCB(b, uint64(len(s))), s...)
+}
+
+func appendNT(b []byte, s string) []byte {
+	return append(append(b, s...), 0)
+}
+
+// Decoding functions. They never panic: a malformed payload results in zero
+// values.
+
+type decoder struct {
+	buf []byte
+	bad bool
+}
+
+func (d *decoder) next(n int) []byte {
+	if n > len(d.buf) || n < 0 {
+		d.bad = true
+		n = len(d.buf)
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) byte() byte {
+	if b := d.next(1); len(b) == 1 {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *decoder) u16() uint16 {
+	if b := d.next(2); len(b) == 2 {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (d *decoder) u32() uint32 {
+	if b := d.next(4); len(b) == 4 {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (d *decoder) u64() uint64 {
+	if b := d.next(8); len(b) == 8 {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (d *decoder) lcb() uint64 {
+	switch b := d.byte(); b {
+	case 0xfc:
+		return uint64(d.u16())
+	case 0xfd:
+		b := d.next(3)
+		if len(b) == 3 {
+			return uint64(b[0]) | uint64(b[1])<<8 | uint64(b[2])<<16
+		}
+		return 0
+	case 0xfe:
+		return d.u64()
+	default:
+		return uint64(b)
+	}
+}
+
+func (d *decoder) bin() []byte {
+	return d.next(int(d.lcb()))
+}
+
+func (d *decoder) nt() string {
+	i := bytes.IndexByte(d.buf, 0)
+	if i < 0 {
+		d.bad = true
+		i = len(d.buf)
+	}
+	s := string(d.buf[:i])
+	d.next(i + 1)
+	return s
+}
+
+func (d *decoder) rest() []byte {
+	return d.next(len(d.buf))
+}
+
+// Values
+
+// textValue returns the text protocol representation of v.
+func textValue(v interface{}, typ byte) []byte {
+	switch v := v.(type) {
+	case []byte:
+		return v
+	case string:
+		return []byte(v)
+	case bool:
+		if v {
+			return []byte{'1'}
+		}
+		return []byte{'0'}
+	case float32:
+		return strconv.AppendFloat(nil, float64(v), 'g', -1, 32)
+	case float64:
+		return strconv.AppendFloat(nil, v, 'g', -1, 64)
+	case time.Time:
+		if typ == _TYPE_DATE || typ == _TYPE_NEWDATE {
+			return []byte(v.Format("2006-01-02"))
+		}
+		return []byte(mysql.TimeString(v))
+	case time.Duration:
+		return []byte(mysql.DurationString(v))
+	case mysql.Date:
+		return []byte(v.String())
+	case mysql.Timestamp:
+		return []byte(v.String())
+	case mysql.Decimal:
+		return []byte(v)
+	case json.RawMessage:
+		return v
+	}
+	if i, ok := toInt64(v); ok {
+		return strconv.AppendInt(nil, i, 10)
+	}
+	if u, ok := v.(uint64); ok {
+		return strconv.AppendUint(nil, u, 10)
+	}
+	if u, ok := v.(uint); ok {
+		return strconv.AppendUint(nil, uint64(u), 10)
+	}
+	panic("mysqltest: unsupported value type")
+}
+
+func toInt64(v interface{}) (int64, bool) {
+	switch v := v.(type) {
+	case int:
+		return int64(v), true
+	case int8:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case uint8:
+		return int64(v), true
+	case uint16:
+		return int64(v), true
+	case uint32:
+		return int64(v), true
+	case uint:
+		if uint64(v) <= math.MaxInt64 {
+			return int64(v), true
+		}
+	case uint64:
+		if v <= math.MaxInt64 {
+			return int64(v), true
+		}
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+func toUint64(v interface{}) uint64 {
+	switch v := v.(type) {
+	case uint:
+		return uint64(v)
+	case uint64:
+		return v
+	case float32:
+		return uint64(v)
+	case float64:
+		return uint64(v)
+	case string:
+		u, _ := strconv.ParseUint(v, 10, 64)
+		return u
+	case []byte:
+		u, _ := strconv.ParseUint(string(v), 10, 64)
+		return u
+	}
+	i, _ := toInt64(v)
+	return uint64(i)
+}
+
+func toFloat64(v interface{}) float64 {
+	switch v := v.(type) {
+	case float32:
+		return float64(v)
+	case float64:
+		return v
+	case string:
+		f, _ := strconv.ParseFloat(v, 64)
+		return f
+	case []byte:
+		f, _ := strconv.ParseFloat(string(v), 64)
+		return f
+	}
+	if i, ok := toInt64(v); ok {
+		return float64(i)
+	}
+	return float64(toUint64(v))
+}
+
+func toTime(v interface{}) time.Time {
+	switch v := v.(type) {
+	case time.Time:
+		return v
+	case mysql.Timestamp:
+		return v.Time
+	case mysql.Date:
+		return v.Time(time.Local)
+	case string:
+		t, _ := mysql.ParseTime(v, time.Local)
+		return t
+	case []byte:
+		t, _ := mysql.ParseTime(string(v), time.Local)
+		return t
+	}
+	panic("mysqltest: value can't be converted to time")
+}
+
+func toDuration(v interface{}) time.Duration {
+	switch v := v.(type) {
+	case time.Duration:
+		return v
+	case string:
+		d, _ := mysql.ParseDuration(v)
+		return d
+	case []byte:
+		d, _ := mysql.ParseDuration(string(v))
+		return d
+	}
+	i, _ := toInt64(v)
+	return time.Duration(i)
+}
+
+// appendBinValue appends the binary protocol representation of v.
+func appendBinValue(b []byte, v interface{}, typ byte) []byte {
+	switch typ {
+	case _TYPE_TINY:
+		return append(b, byte(toUint64(v)))
+	case _TYPE_SHORT, _TYPE_YEAR:
+		return appendU16(b, uint16(toUint64(v)))
+	case _TYPE_LONG, _TYPE_INT24:
+		return appendU32(b, uint32(toUint64(v)))
+	case _TYPE_LONGLONG:
+		return appendU64(b, toUint64(v))
+	case _TYPE_FLOAT:
+		return appendU32(b, math.Float32bits(float32(toFloat64(v))))
+	case _TYPE_DOUBLE:
+		return appendU64(b, math.Float64bits(toFloat64(v)))
+	case _TYPE_DATE, _TYPE_NEWDATE, _TYPE_DATETIME, _TYPE_TIMESTAMP:
+		return appendTime(b, toTime(v), typ)
+	case _TYPE_TIME:
+		return appendDuration(b, toDuration(v))
+	}
+	return appendBin(b, textValue(v, typ))
+}
+
+func appendTime(b []byte, t time.Time, typ byte) []byte {
+	if t.IsZero() {
+		return append(b, 0)
+	}
+	y, mon, d := t.Date()
+	h, m, s := t.Clock()
+	us := uint32(t.Nanosecond() / 1000)
+	date := []byte{byte(y), byte(y >> 8), byte(mon), byte(d)}
+	switch {
+	case typ == _TYPE_DATE || typ == _TYPE_NEWDATE:
+		return append(append(b, 4), date...)
+	case us != 0:
+		return appendU32(append(append(append(b, 11), date...), byte(h),
+			byte(m), byte(s)), us)
+	case h != 0 || m != 0 || s != 0:
+		return append(append(append(b, 7), date...), byte(h), byte(m),
+			byte(s))
+	}
+	return append(append(b, 4), date...)
+}
+
+func appendDuration(b []byte, d time.Duration) []byte {
+	if d == 0 {
+		return append(b, 0)
+	}
+	var neg byte
+	if d < 0 {
+		neg = 1
+		d = -d
+	}
+	us := uint32(d % time.Second / time.Microsecond)
+	s := int64(d / time.Second)
+	b = append(b, 8, neg)
+	if us != 0 {
+		b[len(b)-2] = 12
+	}
+	b = appendU32(b, uint32(s/(24*3600)))
+	s %= 24 * 3600
+	b = append(b, byte(s/3600), byte(s/60%60), byte(s%60))
+	if us != 0 {
+		b = appendU32(b, us)
+	}
+	return b
+}
+
+// binValue decodes a parameter of COM_STMT_EXECUTE.
+func (d *decoder) binValue(typ uint16) interface{} {
+	unsigned := typ&0x8000 != 0
+	switch byte(typ) {
+	case _TYPE_NULL:
+		return nil
+	case _TYPE_TINY:
+		if unsigned {
+			return uint64(d.byte())
+		}
+		return int64(int8(d.byte()))
+	case _TYPE_SHORT, _TYPE_YEAR:
+		if unsigned {
+			return uint64(d.u16())
+		}
+		return int64(int16(d.u16()))
+	case _TYPE_LONG, _TYPE_INT24:
+		if unsigned {
+			return uint64(d.u32())
+		}
+		return int64(int32(d.u32()))
+	case _TYPE_LONGLONG:
+		if unsigned {
+			return d.u64()
+		}
+		return int64(d.u64())
+	case _TYPE_FLOAT:
+		return float64(math.Float32frombits(d.u32()))
+	case _TYPE_DOUBLE:
+		return math.Float64frombits(d.u64())
+	case _TYPE_DATE, _TYPE_NEWDATE, _TYPE_DATETIME, _TYPE_TIMESTAMP:
+		b := d.next(int(d.byte()))
+		if len(b) < 4 {
+			return time.Time{}
+		}
+		var h, m, s, us int
+		if len(b) >= 7 {
+			h, m, s = int(b[4]), int(b[5]), int(b[6])
+		}
+		if len(b) >= 11 {
+			us = int(binary.LittleEndian.Uint32(b[7:]))
+		}
+		return time.Date(int(binary.LittleEndian.Uint16(b)),
+			time.Month(b[2]), int(b[3]), h, m, s, us*1000, time.Local)
+	case _TYPE_TIME:
+		b := d.next(int(d.byte()))
+		if len(b) < 8 {
+			return time.Duration(0)
+		}
+		dur := time.Duration(binary.LittleEndian.Uint32(b[1:5])) * 24 * time.Hour
+		dur += time.Duration(b[5])*time.Hour + time.Duration(b[6])*time.Minute +
+			time.Duration(b[7])*time.Second
+		if len(b) >= 12 {
+			dur += time.Duration(binary.LittleEndian.Uint32(b[8:]))
+		}
+		if b[0] != 0 {
+			dur = -dur
+		}
+		return dur
+	}
+	return d.bin()
+}
+
+// Compressed protocol (zlib only)
+
+type compressIO struct {
+	rw   io.ReadWriter
+	seq  byte
+	rbuf []byte
+	hdr  [7]byte
+}
+
+func (c *compressIO) Read(buf []byte) (int, error) {
+	for len(c.rbuf) == 0 {
+		if _, err := io.ReadFull(c.rw, c.hdr[:]); err != nil {
+			return 0, err
+		}
+		clen := int(c.hdr[0]) | int(c.hdr[1])<<8 | int(c.hdr[2])<<16
+		ulen := int(c.hdr[4]) | int(c.hdr[5])<<8 | int(c.hdr[6])<<16
+		c.seq = c.hdr[3] + 1
+		payload := make([]byte, clen)
+		if _, err := io.ReadFull(c.rw, payload); err != nil {
+			return 0, err
+		}
+		if ulen == 0 {
+			c.rbuf = payload
+			continue
+		}
+		zr, err := zlib.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return 0, err
+		}
+		if c.rbuf, err = ioutil.ReadAll(zr); err != nil {
+			return 0, err
+		}
+		if len(c.rbuf) != ulen {
+			return 0, mysql.ErrPkt
+		}
+	}
+	n := copy(buf, c.rbuf)
+	c.rbuf = c.rbuf[n:]
+	return n, nil
+}
+
+func (c *compressIO) Write(buf []byte) (int, error) {
+	written := 0
+	for len(buf) > 0 {
+		n := len(buf)
+		if n > 0xffffff {
+			n = 0xffffff
+		}
+		payload := buf[:n]
+		ulen := 0
+		if n >= 50 {
+			var zb bytes.Buffer
+			zw := zlib.NewWriter(&zb)
+			zw.Write(payload)
+			zw.Close()
+			if zb.Len() < n {
+				payload = zb.Bytes()
+				ulen = n
+			}
+		}
+		pkt := []byte{
+			byte(len(payload)), byte(len(payload) >> 8), byte(len(payload) >> 16),
+			c.seq,
+			byte(ulen), byte(ulen >> 8), byte(ulen >> 16),
+		}
+		c.seq++
+		if _, err := c.rw.Write(append(pkt, payload...)); err != nil {
+			return written, err
+		}
+		written += n
+		buf = buf[n:]
+	}
+	return written, nil
+}
@@ -0,0 +1,258 @@
+package mysqltest
+
+import (
+	"time"
+
+	"github.com/ziutek/mymysql/mysql"
+)
+
+// Result is a scripted server response. It is one of *OK, *Error,
+// *ResultSet, *LocalInfile or Delay.
+type Result interface {
+	result()
+}
+
+// OK is an OK packet.
+type OK struct {
+	AffectedRows uint64
+	InsertId     uint64
+	Status       mysql.ConnStatus // Default SERVER_STATUS_AUTOCOMMIT
+	Warnings     uint16
+	Info         string
+	// Session state changes, sent if the client supports session tracking
+	Session []mysql.SessionChange
+}
+
+// Error is an error packet. It ends the response: results after it are
+// ignored.
+type Error struct {
+	Code  uint16
+	State string // Default "HY000"
+	Msg   string
+}
+
+// Column describes a result set column.
+type Column struct {
+	Name     string
+	Table    string
+	Type     byte   // One of native.MYSQL_TYPE_*, 0 means MYSQL_TYPE_VAR_STRING
+	Flags    uint16 // Column flags, e.g. UNSIGNED (0x20)
+	Length   uint32
+	Decimals byte
+}
+
+// ResultSet is a result set. Values in Rows can be nil, []byte, string,
+// bool, integers, floats, time.Time, time.Duration, mysql.Date,
+// mysql.Timestamp, mysql.Decimal or json.RawMessage. They are converted to
+// the column type when sent in binary protocol (response to
+// COM_STMT_EXECUTE).
+type ResultSet struct {
+	Columns  []Column
+	Rows     [][]interface{}
+	Status   mysql.ConnStatus // Default SERVER_STATUS_AUTOCOMMIT
+	Warnings uint16
+}
+
+// LocalInfile is a LOAD DATA LOCAL INFILE request for file Name. Data sent
+// by the client is recorded in Command.Infile and the server continues with
+// the next result (OK if there is no next result).
+type LocalInfile struct {
+	Name string
+}
+
+// Delay delays the next result. The command can be interrupted during delay
+// using KILL QUERY. In this case ER_QUERY_INTERRUPTED error is sent.
+type Delay time.Duration
+
+// BinlogEvent is a binlog event (header, body and checksum if used) sent in
+// response to COM_BINLOG_DUMP or COM_BINLOG_DUMP_GTID. If the response ends
+// with events, EOF is sent after them, like in non-blocking mode.
+type BinlogEvent []byte
+
+func (*OK) result()          {}
+func (*Error) result()       {}
+func (*ResultSet) result()   {}
+func (*LocalInfile) result() {}
+func (Delay) result()        {}
+func (BinlogEvent) result()  {}
+
+// Err returns an error result with given code and message.
+func Err(code uint16, msg string) *Error {
+	return &Error{Code: code, Msg: msg}
+}
+
+// Rows returns a result set with columns of type MYSQL_TYPE_VAR_STRING named
+// cols and no rows. Use AddRow to add rows.
+func Rows(cols ...string) *ResultSet {
+	rs := &ResultSet{Columns: make([]Column, len(cols))}
+	for i, name := range cols {
+		rs.Columns[i].Name = name
+	}
+	return rs
+}
+
+// AddRow appends a row to rs and returns rs.
+func (rs *ResultSet) AddRow(vals ...interface{}) *ResultSet {
+	rs.Rows = append(rs.Rows, vals)
+	return rs
+}
+
+func (col *Column) typ() byte {
+	if col.Type == 0 {
+		return _TYPE_VAR_STRING
+	}
+	return col.Type
+}
+
+func (c *conn) status(s mysql.ConnStatus, more bool) uint16 {
+	if s == 0 {
+		s = c.srv_status
+	}
+	if more {
+		s |= mysql.SERVER_MORE_RESULTS_EXISTS
+	}
+	return uint16(s)
+}
+
+func (c *conn) writeOK(ok *OK, more bool) error {
+	pkt := []byte{0}
+	pkt = appendLCB(pkt, ok.AffectedRows)
+	pkt = appendLCB(pkt, ok.InsertId)
+	status := c.status(ok.Status, more)
+	track := c.caps&_CLIENT_SESSION_TRACK != 0
+	if track && len(ok.Session) != 0 {
+		status |= uint16(mysql.SERVER_SESSION_STATE_CHANGED)
+	}
+	pkt = appendU16(pkt, status)
+	pkt = appendU16(pkt, ok.Warnings)
+	switch {
+	case !track:
+		pkt = append(pkt, ok.Info...)
+	case len(ok.Session) != 0:
+		pkt = appendStr(pkt, ok.Info)
+		pkt = appendStr(pkt, string(sessionState(ok.Session)))
+	case ok.Info != "":
+		pkt = appendStr(pkt, ok.Info)
+	}
+	return c.writePacket(pkt)
+}
+
+// sessionState encodes the session state change information.
+func sessionState(changes []mysql.SessionChange) []byte {
+	var b []byte
+	for _, ch := range changes {
+		var data []byte
+		switch ch.Type {
+		case mysql.SessionTrackSystemVariables:
+			data = appendStr(appendStr(nil, ch.Name), ch.Value)
+		case mysql.SessionTrackGTIDs:
+			data = appendStr([]byte{0}, ch.Value)
+		default:
+			data = appendStr(nil, ch.Value)
+		}
+		b = appendStr(append(b, byte(ch.Type)), string(data))
+	}
+	return b
+}
+
+func (c *conn) writeError(e *Error) error {
+	state := e.State
+	if len(state) != 5 {
+		state = "HY000"
+	}
+	pkt := appendU16([]byte{0xff}, e.Code)
+	pkt = append(append(pkt, '#'), state...)
+	pkt = append(pkt, e.Msg...)
+	return c.writePacket(pkt)
+}
+
+// writeEOF writes the packet that ends rows: EOF or, if the client uses
+// CLIENT_DEPRECATE_EOF, OK with the 0xfe header.
+func (c *conn) writeEOF(warnings uint16, status uint16) error {
+	if c.caps&_CLIENT_DEPRECATE_EOF != 0 {
+		pkt := []byte{0xfe, 0, 0} // affected rows, insert id
+		return c.writePacket(appendU16(appendU16(pkt, status), warnings))
+	}
+	return c.writePacket(appendU16(appendU16([]byte{0xfe}, warnings), status))
+}
+
+func (c *conn) writeColumn(col *Column) error {
+	charset := uint16(_CHARSET_UTF8)
+	if col.Flags&_FLAG_BINARY != 0 {
+		charset = _CHARSET_BINARY
+	}
+	pkt := appendStr(nil, "def")
+	pkt = appendStr(pkt, c.db)
+	pkt = appendStr(pkt, col.Table)
+	pkt = appendStr(pkt, col.Table)
+	pkt = appendStr(pkt, col.Name)
+	pkt = appendStr(pkt, col.Name)
+	pkt = append(pkt, 0x0c)
+	pkt = appendU16(pkt, charset)
+	pkt = appendU32(pkt, col.Length)
+	pkt = append(pkt, col.typ())
+	pkt = appendU16(pkt, col.Flags)
+	pkt = append(pkt, col.Decimals, 0, 0)
+	return c.writePacket(pkt)
+}
+
+// writeColumns writes column definitions followed by EOF (if the client
+// doesn't use CLIENT_DEPRECATE_EOF).
+func (c *conn) writeColumns(cols []Column, status uint16) error {
+	for i := range cols {
+		if err := c.writeColumn(&cols[i]); err != nil {
+			return err
+		}
+	}
+	if c.caps&_CLIENT_DEPRECATE_EOF != 0 {
+		return nil
+	}
+	return c.writeEOF(0, status)
+}
+
+func (c *conn) writeResultSet(rs *ResultSet, binary, more bool) error {
+	status := c.status(rs.Status, more)
+	err := c.writePacket(appendLCB(nil, uint64(len(rs.Columns))))
+	if err != nil {
+		return err
+	}
+	if err = c.writeColumns(rs.Columns, status); err != nil {
+		return err
+	}
+	for _, row := range rs.Rows {
+		if binary {
+			err = c.writePacket(binRow(rs.Columns, row))
+		} else {
+			err = c.writePacket(textRow(rs.Columns, row))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return c.writeEOF(rs.Warnings, status)
+}
+
+func textRow(cols []Column, row []interface{}) []byte {
+	var pkt []byte
+	for i, v := range row {
+		if v == nil {
+			pkt = append(pkt, 0xfb)
+			continue
+		}
+		pkt = appendBin(pkt, textValue(v, cols[i].typ()))
+	}
+	return pkt
+}
+
+func binRow(cols []Column, row []interface{}) []byte {
+	null_bitmap := make([]byte, (len(cols)+7+2)>>3)
+	pkt := append([]byte{0}, null_bitmap...)
+	for i, v := range row {
+		if v == nil {
+			pkt[1+(i+2)>>3] |= 1 << uint((i+2)&7)
+			continue
+		}
+		pkt = appendBinValue(pkt, v, cols[i].typ())
+	}
+	return pkt
+}
@@ -0,0 +1,367 @@
+// Package mysqltest implements an in-process fake MySQL server for tests that
+// can't use a real one.
+//
+// The server speaks the MySQL client/server protocol over TCP so any mymysql
+// connection (native, thrsafe, autorc, godrv) can be connected to it. It
+// implements the handshake (with TLS and compression if enabled), COM_QUERY,
+// COM_INIT_DB, COM_PING, COM_QUIT, COM_RESET_CONNECTION, COM_CHANGE_USER and
+// prepared statements (with read-only cursors fetched by COM_STMT_FETCH).
+// Responses to queries are scripted by the test and all received commands are
+// recorded, so the test can check what was sent to the server.
+//
+//	srv := &mysqltest.Server{User: "testuser", Passwd: "TestPasswd9"}
+//	if err := srv.Start(); err != nil {
+//		t.Fatal(err)
+//	}
+//	defer srv.Close()
+//	srv.HandleQuery("select id, name from t",
+//		mysqltest.Rows("id", "name").AddRow(1, "foo").AddRow(2, nil),
+//	)
+//	db := mysql.New("tcp", "", srv.Addr(), "testuser", "TestPasswd9")
+package mysqltest
+
+import (
+	"crypto/tls"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ziutek/mymysql/mysql"
+)
+
+// Command is a command received by the server.
+type Command struct {
+	ThreadId uint32 // Thread ID of the connection
+	Cmd      byte   // One of COM_*
+
+	// SQL text for COM_QUERY, COM_STMT_PREPARE, COM_STMT_EXECUTE; database
+	// name for COM_INIT_DB; user name for COM_CHANGE_USER
+	Query string
+
+	StmtId    uint32        // COM_STMT_* statement id
+	Flags     byte          // COM_STMT_EXECUTE flags (1: open a cursor)
+	Args      []interface{} // COM_STMT_EXECUTE parameters
+	FetchRows uint32        // COM_STMT_FETCH number of rows
+	Data      []byte        // Raw payload (without the command byte)
+	Infile    []byte        // Data sent in response to LocalInfile
+}
+
+// HandlerFunc returns results for cmd. For COM_STMT_PREPARE it is called to
+// obtain the statement columns (from the first *ResultSet) or an error.
+type HandlerFunc func(cmd *Command) []Result
+
+// Server is a fake MySQL server. Its fields should be set before Start and
+// must not be modified after it.
+type Server struct {
+	User   string // Expected user name, any user is accepted if empty
+	Passwd string // Expected password
+
+	// Additional accounts (user name -> password) accepted besides User
+	Users map[string]string
+
+	// Authentication plugin announced in the initial handshake. Supported:
+	// mysql_native_password (default), caching_sha2_password. MariaDB
+	// client_ed25519 and parsec can be announced with AuthSwitch set to the
+	// same plugin (MariaDB sends the 32 bytes nonce in the auth switch
+	// request).
+	Plugin string
+
+	// If not empty, the server sends the auth switch request for this
+	// plugin. Besides the above, mysql_clear_password, MariaDB
+	// client_ed25519 and parsec are supported.
+	AuthSwitch string
+
+	Version   string      // Server version, default "8.0.0-mysqltest"
+	TLSConfig *tls.Config // Enables TLS if not nil, see NewTLSConfig
+	Compress  bool        // Enables zlib compressed protocol
+
+	// Don't offer CLIENT_DEPRECATE_EOF, so result sets are ended by EOF
+	// packets (as by MySQL < 5.7.5).
+	NoDeprecateEOF bool
+
+	mu          sync.Mutex
+	ln          net.Listener
+	wg          sync.WaitGroup
+	handlers    map[string]HandlerFunc
+	fallback    HandlerFunc
+	cmd_handler map[byte]HandlerFunc
+	commands    []*Command
+	conns       map[uint32]*conn
+	attrs       map[uint32]map[string]string
+	last_thr_id uint32
+	closed      chan struct{} // Interrupts delays
+}
+
+// Start starts listening on a random port of the loopback interface.
+func (s *Server) Start() error {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.ln = ln
+	if s.handlers == nil {
+		s.handlers = make(map[string]HandlerFunc)
+	}
+	if s.cmd_handler == nil {
+		s.cmd_handler = make(map[byte]HandlerFunc)
+	}
+	s.conns = make(map[uint32]*conn)
+	s.closed = make(chan struct{})
+	s.mu.Unlock()
+	s.wg.Add(1)
+	go s.serve()
+	return nil
+}
+
+// Addr returns the server address in host:port form.
+func (s *Server) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// Close stops the server and closes all its connections.
+func (s *Server) Close() error {
+	err := s.ln.Close()
+	s.mu.Lock()
+	select {
+	case <-s.closed:
+		// Already closed
+	default:
+		close(s.closed)
+	}
+	for _, c := range s.conns {
+		c.raw_conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		nc, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.last_thr_id++
+		c := newConn(s, nc, s.last_thr_id)
+		s.conns[c.thr_id] = c
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			c.serve()
+			s.mu.Lock()
+			delete(s.conns, c.thr_id)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// HandleQuery registers results returned for the query sql (sent using
+// COM_QUERY or prepared). Leading and trailing spaces are ignored.
+func (s *Server) HandleQuery(sql string, results ...Result) {
+	s.HandleFunc(sql, func(*Command) []Result { return results })
+}
+
+// HandleFunc registers f as handler for the query sql. If sql is empty f is
+// used for all queries without a handler.
+func (s *Server) HandleFunc(sql string, f HandlerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sql == "" {
+		s.fallback = f
+		return
+	}
+	if s.handlers == nil {
+		s.handlers = make(map[string]HandlerFunc)
+	}
+	s.handlers[strings.TrimSpace(sql)] = f
+}
+
+// HandleCommand registers f as handler for the command cmd. It overrides the
+// built-in handling of cmd (if any). The handler is called for commands
+// other than COM_QUERY, COM_STMT_PREPARE and COM_STMT_EXECUTE.
+func (s *Server) HandleCommand(cmd byte, f HandlerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cmd_handler == nil {
+		s.cmd_handler = make(map[byte]HandlerFunc)
+	}
+	s.cmd_handler[cmd] = f
+}
+
+// Commands returns copies of all commands received by the server.
+func (s *Server) Commands() []Command {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cmds := make([]Command, len(s.commands))
+	for i, c := range s.commands {
+		cmds[i] = *c
+	}
+	return cmds
+}
+
+// Queries returns SQL texts of all received COM_QUERY commands.
+func (s *Server) Queries() []string {
+	var queries []string
+	for _, c := range s.Commands() {
+		if c.Cmd == COM_QUERY {
+			queries = append(queries, c.Query)
+		}
+	}
+	return queries
+}
+
+// Reset forgets all received commands.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	s.commands = nil
+	s.mu.Unlock()
+}
+
+// ConnectAttrs returns the connection attributes sent by the client in the
+// handshake of the connection with thread ID thr_id (also after the
+// connection was closed).
+func (s *Server) ConnectAttrs(thr_id uint32) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.attrs[thr_id]
+}
+
+// ConnCount returns the number of open connections.
+func (s *Server) ConnCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+func (s *Server) record(cmd *Command) {
+	s.mu.Lock()
+	s.commands = append(s.commands, cmd)
+	s.mu.Unlock()
+}
+
+func (s *Server) recordAttrs(thr_id uint32, attrs map[string]string) {
+	s.mu.Lock()
+	if s.attrs == nil {
+		s.attrs = make(map[uint32]map[string]string)
+	}
+	s.attrs[thr_id] = attrs
+	s.mu.Unlock()
+}
+
+func (s *Server) recordInfile(cmd *Command, data []byte) {
+	s.mu.Lock()
+	cmd.Infile = append(cmd.Infile, data...)
+	s.mu.Unlock()
+}
+
+var killRegexp = regexp.MustCompile(`(?i)^KILL\s+(QUERY\s+|CONNECTION\s+)?(\d+)$`)
+
+// handler returns the handler for query or nil if there is no handler.
+// Registered handlers take precedence over KILL handling and the fallback
+// handler takes precedence over default handling of SET statements.
+func (s *Server) handler(query string) HandlerFunc {
+	query = strings.TrimSpace(query)
+	s.mu.Lock()
+	f, fallback := s.handlers[query], s.fallback
+	s.mu.Unlock()
+	if f != nil {
+		return f
+	}
+	if m := killRegexp.FindStringSubmatch(query); m != nil {
+		id, _ := strconv.ParseUint(m[2], 10, 32)
+		query_only := strings.HasPrefix(strings.ToUpper(m[1]), "QUERY")
+		return func(*Command) []Result { return s.kill(uint32(id), query_only) }
+	}
+	if fallback != nil {
+		return fallback
+	}
+	if len(query) > 4 && strings.EqualFold(query[:4], "SET ") {
+		// Session variables are accepted by default
+		return func(*Command) []Result { return []Result{&OK{}} }
+	}
+	return nil
+}
+
+func (s *Server) cmdHandler(cmd byte) HandlerFunc {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cmd_handler[cmd]
+}
+
+func (s *Server) kill(id uint32, query_only bool) []Result {
+	s.mu.Lock()
+	c := s.conns[id]
+	s.mu.Unlock()
+	if c == nil {
+		return []Result{Err(mysql.ER_NO_SUCH_THREAD,
+			"Unknown thread id: "+strconv.FormatUint(uint64(id), 10))}
+	}
+	if query_only {
+		select {
+		case c.kill <- struct{}{}:
+		default:
+		}
+	} else {
+		c.raw_conn.Close()
+	}
+	return []Result{&OK{}}
+}
+
+// countParams returns the number of '?' placeholders outside quotes and
+// comments.
+func countParams(sql string) int {
+	n := 0
+	var quote byte
+	for i := 0; i < len(sql); i++ {
+		ch := sql[i]
+		switch {
+		case quote != 0:
+			if ch == '\\' && quote != '`' {
+				i++
+			} else if ch == quote {
+				quote = 0
+			}
+		case ch == '\'' || ch == '"' || ch == '`':
+			quote = ch
+		case ch == '?':
+			n++
+		case ch == '#' || ch == '-' && strings.HasPrefix(sql[i:], "-- "):
+			if j := strings.IndexByte(sql[i:], '\n'); j >= 0 {
+				i += j
+			} else {
+				i = len(sql)
+			}
+		case ch == '/' && strings.HasPrefix(sql[i:], "/*"):
+			if j := strings.Index(sql[i+2:], "*/"); j >= 0 {
+				i += j + 3
+			} else {
+				i = len(sql)
+			}
+		}
+	}
+	return n
+}
+
+// passwd returns the password of the user. Any user is accepted with Passwd
+// if User is empty.
+func (s *Server) passwd(user string) (string, bool) {
+	if passwd, ok := s.Users[user]; ok {
+		return passwd, true
+	}
+	if s.User != "" && user != s.User {
+		return "", false
+	}
+	return s.Passwd, true
+}
+
+// noHandler returns the error sent for queries without a handler.
+func noHandler(query string) *Error {
+	return Err(mysql.ER_UNKNOWN_ERROR, "mysqltest: no handler for query: "+query)
+}
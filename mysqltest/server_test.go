@@ -0,0 +1,225 @@
+package mysqltest_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ziutek/mymysql/mysql"
+	"github.com/ziutek/mymysql/mysqltest"
+	"github.com/ziutek/mymysql/native"
+)
+
+const (
+	user   = "testuser"
+	passwd = "TestPasswd9"
+	dbname = "test"
+)
+
+func startServer(t *testing.T, srv *mysqltest.Server) {
+	if srv.User == "" {
+		srv.User, srv.Passwd = user, passwd
+	}
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func connect(t *testing.T, srv *mysqltest.Server) mysql.Conn {
+	my := native.New("tcp", "", srv.Addr(), user, passwd, dbname)
+	if err := my.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	return my
+}
+
+func TestQuery(t *testing.T) {
+	srv := new(mysqltest.Server)
+	startServer(t, srv)
+	defer srv.Close()
+
+	srv.HandleQuery("select id, name from t",
+		mysqltest.Rows("id", "name").AddRow(1, "foo").AddRow(2, nil),
+	)
+	srv.HandleQuery("update t set name = 'bar'",
+		&mysqltest.OK{AffectedRows: 2, Info: "Rows matched: 2"},
+	)
+	srv.HandleQuery("drop table t",
+		mysqltest.Err(mysql.ER_BAD_TABLE_ERROR, "Unknown table 't'"),
+	)
+
+	my := connect(t, srv)
+	rows, _, err := my.Query("select id, name from t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0].Int(0) != 1 || rows[0].Str(1) != "foo" ||
+		rows[1].Int(0) != 2 || rows[1][1] != nil {
+		t.Fatalf("Bad rows: %v", rows)
+	}
+	_, res, err := my.Query("update t set name = 'bar'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.AffectedRows() != 2 || res.Message() != "Rows matched: 2" {
+		t.Fatalf("Bad result: %d %q", res.AffectedRows(), res.Message())
+	}
+	_, _, err = my.Query("drop table t")
+	if e, ok := err.(*mysql.Error); !ok || e.Code != mysql.ER_BAD_TABLE_ERROR {
+		t.Fatalf("Bad error: %v", err)
+	}
+	_, _, err = my.Query("select 1")
+	if e, ok := err.(*mysql.Error); !ok || e.Code != mysql.ER_UNKNOWN_ERROR {
+		t.Fatalf("Bad error for query without handler: %v", err)
+	}
+	if err = my.Use("other"); err != nil {
+		t.Fatal(err)
+	}
+	if err = my.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	defer my.Close()
+
+	exp := []string{
+		"select id, name from t",
+		"update t set name = 'bar'",
+		"drop table t",
+		"select 1",
+	}
+	if q := srv.Queries(); !reflect.DeepEqual(q, exp) {
+		t.Fatalf("Bad queries: %q", q)
+	}
+	var cmds []byte
+	for _, c := range srv.Commands() {
+		cmds = append(cmds, c.Cmd)
+	}
+	exp_cmds := []byte{
+		mysqltest.COM_QUERY, mysqltest.COM_QUERY, mysqltest.COM_QUERY,
+		mysqltest.COM_QUERY, mysqltest.COM_INIT_DB, mysqltest.COM_PING,
+	}
+	if !reflect.DeepEqual(cmds, exp_cmds) {
+		t.Fatalf("Bad commands: %v", cmds)
+	}
+}
+
+func TestMultiResults(t *testing.T) {
+	srv := new(mysqltest.Server)
+	startServer(t, srv)
+	defer srv.Close()
+
+	srv.HandleQuery("select 1; insert t values (1); select 2",
+		mysqltest.Rows("1").AddRow(1),
+		&mysqltest.OK{AffectedRows: 1, InsertId: 7},
+		mysqltest.Rows("2").AddRow(2),
+	)
+	my := connect(t, srv)
+	defer my.Close()
+
+	res, err := my.Start("select 1; insert t values (1); select 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	row, err := res.GetFirstRow()
+	if err != nil || row.Int(0) != 1 || !res.MoreResults() {
+		t.Fatalf("First result: %v %v", row, err)
+	}
+	if res, err = res.NextResult(); err != nil {
+		t.Fatal(err)
+	}
+	if !res.StatusOnly() || res.InsertId() != 7 || !res.MoreResults() {
+		t.Fatal("Bad second result")
+	}
+	if res, err = res.NextResult(); err != nil {
+		t.Fatal(err)
+	}
+	row, err = res.GetLastRow()
+	if err != nil || row.Int(0) != 2 || res.MoreResults() {
+		t.Fatalf("Third result: %v %v", row, err)
+	}
+}
+
+func TestPrepared(t *testing.T) {
+	srv := new(mysqltest.Server)
+	startServer(t, srv)
+	defer srv.Close()
+
+	cols := []mysqltest.Column{
+		{Name: "i", Type: native.MYSQL_TYPE_LONGLONG},
+		{Name: "s", Type: native.MYSQL_TYPE_VAR_STRING},
+		{Name: "f", Type: native.MYSQL_TYPE_DOUBLE},
+		{Name: "t", Type: native.MYSQL_TYPE_DATETIME},
+		{Name: "d", Type: native.MYSQL_TYPE_TIME},
+		{Name: "n", Type: native.MYSQL_TYPE_LONG},
+	}
+	tm := time.Date(2020, 2, 29, 12, 30, 15, 0, time.Local)
+	srv.HandleFunc("select ?, ?, ?, ?, ?, '?'", func(cmd *mysqltest.Command) []mysqltest.Result {
+		rs := &mysqltest.ResultSet{Columns: cols}
+		if cmd.Cmd == mysqltest.COM_STMT_EXECUTE {
+			rs.AddRow(append(cmd.Args, nil)...)
+		}
+		return []mysqltest.Result{rs}
+	})
+
+	my := connect(t, srv)
+	defer my.Close()
+
+	stmt, err := my.Prepare("select ?, ?, ?, ?, ?, '?'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stmt.NumParam() != 5 || len(stmt.Fields()) != len(cols) {
+		t.Fatalf("Bad statement: %d params, %d fields", stmt.NumParam(),
+			len(stmt.Fields()))
+	}
+	row, _, err := stmt.ExecFirst(-5, "abc", 1.5, tm, 90*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row.Int(0) != -5 || row.Str(1) != "abc" || row.Float(2) != 1.5 ||
+		!row.Time(3, time.Local).Equal(tm) ||
+		row.Duration(4) != 90*time.Minute || row[5] != nil {
+		t.Fatalf("Bad row: %v", row)
+	}
+	if err = stmt.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	// COM_STMT_CLOSE has no response
+	if err = my.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	cmds := srv.Commands()
+	if len(cmds) != 4 || cmds[0].Cmd != mysqltest.COM_STMT_PREPARE ||
+		cmds[1].Cmd != mysqltest.COM_STMT_EXECUTE ||
+		cmds[2].Cmd != mysqltest.COM_STMT_CLOSE ||
+		cmds[1].StmtId != cmds[0].StmtId || cmds[2].StmtId != cmds[0].StmtId {
+		t.Fatalf("Bad commands: %+v", cmds)
+	}
+	args := cmds[1].Args
+	if args[0] != int64(-5) || string(args[1].([]byte)) != "abc" ||
+		args[2] != 1.5 || !args[3].(time.Time).Equal(tm) ||
+		args[4] != 90*time.Minute {
+		t.Fatalf("Bad args: %v", args)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	for _, srv := range []*mysqltest.Server{
+		{Plugin: "caching_sha2_password"},
+		{AuthSwitch: "mysql_native_password", Plugin: "caching_sha2_password"},
+		{AuthSwitch: "caching_sha2_password"},
+	} {
+		startServer(t, srv)
+		connect(t, srv).Close()
+		srv.Close()
+	}
+
+	srv := new(mysqltest.Server)
+	startServer(t, srv)
+	defer srv.Close()
+	my := native.New("tcp", "", srv.Addr(), user, "bad password")
+	err := my.Connect()
+	if e, ok := err.(*mysql.Error); !ok || e.Code != mysql.ER_ACCESS_DENIED_ERROR {
+		t.Fatalf("Bad password accepted: %v", err)
+	}
+}
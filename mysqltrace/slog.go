@@ -0,0 +1,104 @@
+//go:build go1.21
+// +build go1.21
+
+package mysqltrace
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/ziutek/mymysql/mysql"
+)
+
+// SlogTracer is a mysql.Tracer that logs events using log/slog. Events that
+// ended with an error are logged at slog.LevelError, other events at Level.
+// Query events are logged once, after the query ends.
+type SlogTracer struct {
+	Logger *slog.Logger // nil means slog.Default()
+	Level  slog.Level   // Level of successful events (default slog.LevelInfo)
+}
+
+func (t *SlogTracer) log(ctx context.Context, msg string, err error,
+	attrs ...slog.Attr) {
+
+	l := t.Logger
+	if l == nil {
+		l = slog.Default()
+	}
+	level := t.Level
+	if err != nil {
+		level = slog.LevelError
+		attrs = append(attrs, slog.Any("err", err))
+	}
+	l.LogAttrs(ctx, level, msg, attrs...)
+}
+
+func (t *SlogTracer) connect(msg string, ev *mysql.ConnectEvent) {
+	t.log(context.Background(), msg, ev.Err,
+		slog.String("addr", ev.Addr),
+		slog.Any("thread_id", ev.ThreadId),
+		slog.String("server_version", ev.ServerVersion),
+		slog.Duration("duration", ev.Duration),
+	)
+}
+
+func (t *SlogTracer) Connect(ev *mysql.ConnectEvent) {
+	t.connect("mysql connect", ev)
+}
+
+func (t *SlogTracer) Reconnect(ev *mysql.ConnectEvent) {
+	t.connect("mysql reconnect", ev)
+}
+
+func (t *SlogTracer) Auth(ev *mysql.AuthEvent) {
+	t.log(context.Background(), "mysql auth", ev.Err,
+		slog.String("addr", ev.Addr),
+		slog.String("user", ev.User),
+		slog.String("plugin", ev.Plugin),
+	)
+}
+
+func (t *SlogTracer) QueryStart(ev *mysql.QueryEvent) {}
+
+func (t *SlogTracer) QueryEnd(ev *mysql.QueryEvent) {
+	t.query("mysql query", ev)
+}
+
+func (t *SlogTracer) ExecStart(ev *mysql.QueryEvent) {}
+
+func (t *SlogTracer) ExecEnd(ev *mysql.QueryEvent) {
+	t.query("mysql exec", ev)
+}
+
+func (t *SlogTracer) query(msg string, ev *mysql.QueryEvent) {
+	attrs := []slog.Attr{
+		slog.String("addr", ev.Addr),
+		slog.String("sql", ev.SQL),
+	}
+	if ev.StmtId != 0 {
+		attrs = append(attrs, slog.Any("stmt_id", ev.StmtId))
+	}
+	attrs = append(attrs,
+		slog.Duration("duration", ev.Duration),
+		slog.Int64("rows", ev.Rows),
+		slog.Uint64("affected_rows", ev.AffectedRows),
+	)
+	t.log(ev.Ctx, msg, ev.Err, attrs...)
+}
+
+func (t *SlogTracer) Prepare(ev *mysql.StmtEvent) {
+	t.stmt("mysql prepare", ev)
+}
+
+func (t *SlogTracer) StmtClose(ev *mysql.StmtEvent) {
+	t.stmt("mysql stmt close", ev)
+}
+
+func (t *SlogTracer) stmt(msg string, ev *mysql.StmtEvent) {
+	t.log(context.Background(), msg, ev.Err,
+		slog.String("addr", ev.Addr),
+		slog.String("sql", ev.SQL),
+		slog.Any("stmt_id", ev.StmtId),
+		slog.Duration("duration", ev.Duration),
+	)
+}
@@ -0,0 +1,44 @@
+//go:build go1.21
+// +build go1.21
+
+package mysqltrace
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ziutek/mymysql/mysql"
+)
+
+func TestSlogTracer(t *testing.T) {
+	var buf bytes.Buffer
+	tr := &SlogTracer{
+		Logger: slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{
+			Level: slog.LevelDebug,
+		})),
+		Level: slog.LevelDebug,
+	}
+	ev := &mysql.QueryEvent{
+		Ctx:      context.Background(),
+		Addr:     "db:3306",
+		SQL:      "select 1",
+		Duration: time.Millisecond,
+		Rows:     1,
+	}
+	tr.QueryStart(ev)
+	tr.QueryEnd(ev)
+	tr.Connect(&mysql.ConnectEvent{Addr: "db:3306", Err: errors.New("refused")})
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 ||
+		!strings.Contains(lines[0], `level=DEBUG msg="mysql query" addr=db:3306 sql="select 1" duration=1ms rows=1`) ||
+		!strings.Contains(lines[1], `level=ERROR msg="mysql connect"`) ||
+		!strings.Contains(lines[1], "err=refused") {
+		t.Fatalf("Bad log:\n%s", buf.String())
+	}
+}
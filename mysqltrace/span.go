@@ -0,0 +1,141 @@
+// Package mysqltrace contains adapters of mysql.Tracer to logging and tracing
+// libraries.
+//
+// SpanTracer creates spans for distributed tracing systems. It doesn't depend
+// on any of them: with OpenTelemetry, wrap trace.Span to implement Span:
+//
+//	type otelSpan struct{ trace.Span }
+//
+//	func (s otelSpan) SetAttribute(key string, val interface{}) {
+//		var kv attribute.KeyValue
+//		switch v := val.(type) {
+//		case string:
+//			kv = attribute.String(key, v)
+//		case int64:
+//			kv = attribute.Int64(key, v)
+//		default:
+//			kv = attribute.String(key, fmt.Sprint(v))
+//		}
+//		s.Span.SetAttributes(kv)
+//	}
+//
+//	func (s otelSpan) RecordError(err error) {
+//		s.Span.RecordError(err)
+//		s.Span.SetStatus(codes.Error, err.Error())
+//	}
+//
+//	func (s otelSpan) End() { s.Span.End() }
+//
+//	tracer := otel.Tracer("mymysql")
+//	db.SetTracer(&mysqltrace.SpanTracer{
+//		Start: func(ctx context.Context, name string, start time.Time) mysqltrace.Span {
+//			_, s := tracer.Start(ctx, name, trace.WithTimestamp(start),
+//				trace.WithSpanKind(trace.SpanKindClient))
+//			return otelSpan{s}
+//		},
+//	})
+//
+// Use StartContext and RunContext to make the query spans children of the
+// span in ctx.
+//
+// With Go 1.21 or later SlogTracer logs events using log/slog.
+package mysqltrace
+
+import (
+	"context"
+	"time"
+
+	"github.com/ziutek/mymysql/mysql"
+)
+
+// Span is a tracing span (a subset of OpenTelemetry trace.Span).
+type Span interface {
+	SetAttribute(key string, val interface{})
+	RecordError(err error)
+	End()
+}
+
+// StartFunc starts a new span as a child of the span in ctx (if any).
+type StartFunc func(ctx context.Context, name string, start time.Time) Span
+
+// SpanTracer is a mysql.Tracer that creates a span for every query, statement
+// execution, Prepare, Connect and Reconnect. Attribute names follow the
+// OpenTelemetry semantic conventions for databases where possible.
+type SpanTracer struct {
+	Start StartFunc
+}
+
+func (t *SpanTracer) span(ctx context.Context, name, addr string,
+	start time.Time) Span {
+
+	s := t.Start(ctx, name, start)
+	s.SetAttribute("db.system", "mysql")
+	s.SetAttribute("server.address", addr)
+	return s
+}
+
+func end(s Span, err error) {
+	if err != nil {
+		s.RecordError(err)
+		if e, ok := err.(*mysql.Error); ok {
+			s.SetAttribute("db.mysql.error_code", int64(e.Code))
+		}
+	}
+	s.End()
+}
+
+func (t *SpanTracer) connect(name string, ev *mysql.ConnectEvent) {
+	s := t.span(context.Background(), name, ev.Addr,
+		time.Now().Add(-ev.Duration))
+	if ev.Err == nil {
+		s.SetAttribute("db.mysql.thread_id", int64(ev.ThreadId))
+		s.SetAttribute("db.mysql.server_version", ev.ServerVersion)
+	}
+	end(s, ev.Err)
+}
+
+func (t *SpanTracer) Connect(ev *mysql.ConnectEvent) {
+	t.connect("mysql.connect", ev)
+}
+
+func (t *SpanTracer) Reconnect(ev *mysql.ConnectEvent) {
+	t.connect("mysql.reconnect", ev)
+}
+
+func (t *SpanTracer) Auth(ev *mysql.AuthEvent) {}
+
+func (t *SpanTracer) QueryStart(ev *mysql.QueryEvent) {
+	s := t.span(ev.Ctx, "mysql.query", ev.Addr, ev.Start)
+	s.SetAttribute("db.statement", ev.SQL)
+	ev.Data = s
+}
+
+func (t *SpanTracer) QueryEnd(ev *mysql.QueryEvent) {
+	s, ok := ev.Data.(Span)
+	if !ok {
+		return
+	}
+	s.SetAttribute("db.mysql.rows", ev.Rows)
+	s.SetAttribute("db.mysql.affected_rows", int64(ev.AffectedRows))
+	end(s, ev.Err)
+}
+
+func (t *SpanTracer) ExecStart(ev *mysql.QueryEvent) {
+	s := t.span(ev.Ctx, "mysql.exec", ev.Addr, ev.Start)
+	s.SetAttribute("db.statement", ev.SQL)
+	s.SetAttribute("db.mysql.stmt_id", int64(ev.StmtId))
+	ev.Data = s
+}
+
+func (t *SpanTracer) ExecEnd(ev *mysql.QueryEvent) {
+	t.QueryEnd(ev)
+}
+
+func (t *SpanTracer) Prepare(ev *mysql.StmtEvent) {
+	s := t.span(context.Background(), "mysql.prepare", ev.Addr,
+		time.Now().Add(-ev.Duration))
+	s.SetAttribute("db.statement", ev.SQL)
+	end(s, ev.Err)
+}
+
+func (t *SpanTracer) StmtClose(ev *mysql.StmtEvent) {}
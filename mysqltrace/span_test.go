@@ -0,0 +1,86 @@
+package mysqltrace
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ziutek/mymysql/mysql"
+	"github.com/ziutek/mymysql/mysqltest"
+	_ "github.com/ziutek/mymysql/native"
+)
+
+const (
+	user   = "testuser"
+	passwd = "TestPasswd9"
+)
+
+type testSpan struct {
+	name  string
+	ctx   context.Context
+	attrs map[string]interface{}
+	err   error
+	ended bool
+}
+
+func (s *testSpan) SetAttribute(key string, val interface{}) { s.attrs[key] = val }
+func (s *testSpan) RecordError(err error)                    { s.err = err }
+func (s *testSpan) End()                                     { s.ended = true }
+
+type ctxKey struct{}
+
+func TestSpanTracer(t *testing.T) {
+	srv := &mysqltest.Server{User: user, Passwd: passwd}
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+	srv.HandleQuery("select a", mysqltest.Rows("a").AddRow(1).AddRow(2))
+	srv.HandleQuery("bad", mysqltest.Err(mysql.ER_PARSE_ERROR, "syntax"))
+
+	var spans []*testSpan
+	c := mysql.New("tcp", "", srv.Addr(), user, passwd)
+	c.SetTracer(&SpanTracer{
+		Start: func(ctx context.Context, name string, start time.Time) Span {
+			s := &testSpan{name: name, ctx: ctx, attrs: make(map[string]interface{})}
+			spans = append(spans, s)
+			return s
+		},
+	})
+	if err := c.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	ctx := context.WithValue(context.Background(), ctxKey{}, "parent")
+	res, err := c.StartContext(ctx, "select a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = res.GetRows(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = c.Query("bad"); err == nil {
+		t.Fatal("No error")
+	}
+
+	if len(spans) != 3 {
+		t.Fatalf("Bad number of spans: %d", len(spans))
+	}
+	for _, s := range spans {
+		if !s.ended || s.attrs["db.system"] != "mysql" ||
+			s.attrs["server.address"] != srv.Addr() {
+			t.Fatalf("Bad span: %+v", s)
+		}
+	}
+	s := spans[1]
+	if s.name != "mysql.query" || s.ctx.Value(ctxKey{}) != "parent" ||
+		s.attrs["db.statement"] != "select a" ||
+		s.attrs["db.mysql.rows"] != int64(2) || s.err != nil {
+		t.Fatalf("Bad query span: %+v", s)
+	}
+	s = spans[2]
+	if s.err == nil || fmt.Sprint(s.attrs["db.mysql.error_code"]) != "1064" {
+		t.Fatalf("Bad error span: %+v", s)
+	}
+}
@@ -0,0 +1,264 @@
+package native
+
+import (
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"sync"
+
+	"github.com/ziutek/mymysql/mysql"
+)
+
+// AuthInfo contains data used by an AuthPlugin to authenticate the client.
+type AuthInfo struct {
+	User   string
+	Passwd string
+
+	// Plugin data sent by the server in the initial handshake or in the
+	// auth switch request (usually the random scramble).
+	Scramble []byte
+
+	// Secure is true if the connection uses TLS or Unix socket, so the
+	// password can be sent in clear text.
+	Secure bool
+}
+
+// AuthPlugin is the client side of a MySQL authentication plugin. Plugins
+// are shared by all connections so they should keep the state of the
+// authentication only in AuthInfo.
+type AuthPlugin interface {
+	// InitialResponse returns the authentication data sent in the handshake
+	// response, in the COM_CHANGE_USER packet or in response to the auth
+	// switch request.
+	InitialResponse(info *AuthInfo) ([]byte, error)
+
+	// Continue is called for every packet with plugin data sent by the
+	// server before the authentication result. It returns the data that
+	// should be sent to the server or nil if nothing should be sent.
+	Continue(info *AuthInfo, data []byte) ([]byte, error)
+
+	// RequiresSecureTransport reports whether the plugin can be used only
+	// over TLS or Unix socket connection.
+	RequiresSecureTransport() bool
+}
+
+var (
+	authPluginsMutex sync.RWMutex
+	authPlugins      = map[string]AuthPlugin{
+		"mysql_native_password": nativePasswordPlugin{},
+		"caching_sha2_password": cachingSHA2PasswordPlugin{},
+		"sha256_password":       sha256PasswordPlugin{},
+		"mysql_old_password":    oldPasswordPlugin{},
+		"mysql_clear_password":  clearPasswordPlugin{},
+		"client_ed25519":        ed25519Plugin{},
+		"parsec":                parsecPlugin{},
+	}
+)
+
+// RegisterAuthPlugin registers the authentication plugin with given name.
+// It can replace a built-in plugin. Built-in plugins: mysql_native_password,
+// caching_sha2_password, sha256_password, mysql_old_password,
+// mysql_clear_password, client_ed25519 (MariaDB) and parsec (MariaDB).
+func RegisterAuthPlugin(name string, plugin AuthPlugin) {
+	authPluginsMutex.Lock()
+	authPlugins[name] = plugin
+	authPluginsMutex.Unlock()
+}
+
+func getAuthPlugin(name string) AuthPlugin {
+	authPluginsMutex.RLock()
+	defer authPluginsMutex.RUnlock()
+	return authPlugins[name]
+}
+
+// authPlugin returns the plugin for my.plugin. It panics if the plugin isn't
+// registered or can't be used for the connection.
+func (my *Conn) authPlugin() AuthPlugin {
+	plugin := getAuthPlugin(my.plugin)
+	if plugin == nil {
+		panic(mysql.ClientError("unknown authentication plugin: " + my.plugin))
+	}
+	if plugin.RequiresSecureTransport() && !my.secureTransport() {
+		panic(mysql.ClientError("authentication plugin requires secure connection: " +
+			my.plugin))
+	}
+	return plugin
+}
+
+// secureTransport reports whether the connection uses TLS or Unix socket.
+func (my *Conn) secureTransport() bool {
+	if _, ok := my.net_conn.(*tls.Conn); ok {
+		return true
+	}
+	return my.proto == "unix"
+}
+
+func (my *Conn) authInfo(scramble []byte) *AuthInfo {
+	return &AuthInfo{
+		User:     my.user,
+		Passwd:   my.passwd,
+		Scramble: scramble,
+		Secure:   my.secureTransport(),
+	}
+}
+
+// scramble20 returns the scramble without the terminating zero sent in the
+// auth switch request.
+func scramble20(scramble []byte) []byte {
+	if len(scramble) > 20 {
+		return scramble[:20]
+	}
+	return scramble
+}
+
+// clearPasswd returns the password terminated by zero.
+func clearPasswd(password string) []byte {
+	return append([]byte(password), 0)
+}
+
+// parsePublicKey parses the PEM encoded RSA public key sent by the server.
+func parsePublicKey(data []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, mysql.ErrAuthentication
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, mysql.ErrAuthentication
+	}
+	rsa_pub, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return nil, mysql.ErrAuthentication
+	}
+	return rsa_pub, nil
+}
+
+type nativePasswordPlugin struct{}
+
+func (nativePasswordPlugin) InitialResponse(info *AuthInfo) ([]byte, error) {
+	return encryptedPasswd(info.Passwd, scramble20(info.Scramble)), nil
+}
+
+func (nativePasswordPlugin) Continue(info *AuthInfo, data []byte) ([]byte, error) {
+	return nil, mysql.ErrUnkResultPkt
+}
+
+func (nativePasswordPlugin) RequiresSecureTransport() bool { return false }
+
+// https://insidemysql.com/preparing-your-community-connector-for-mysql-8-part-2-sha256/
+type cachingSHA2PasswordPlugin struct{}
+
+func (cachingSHA2PasswordPlugin) InitialResponse(info *AuthInfo) ([]byte, error) {
+	return encryptedSHA256Passwd(info.Passwd, scramble20(info.Scramble)), nil
+}
+
+func (cachingSHA2PasswordPlugin) Continue(info *AuthInfo, data []byte) ([]byte, error) {
+	if len(data) != 1 {
+		// Public key
+		pub, err := parsePublicKey(data)
+		if err != nil {
+			return nil, err
+		}
+		return encryptPassword(info.Passwd, scramble20(info.Scramble), pub)
+	}
+	switch data[0] {
+	case 3: // cachingSha2PasswordFastAuthSuccess
+		return nil, nil
+	case 4: // cachingSha2PasswordPerformFullAuthentication
+		if info.Secure {
+			return clearPasswd(info.Passwd), nil
+		}
+		return []byte{2}, nil // request public key from server
+	}
+	return nil, mysql.ErrUnkResultPkt
+}
+
+func (cachingSHA2PasswordPlugin) RequiresSecureTransport() bool { return false }
+
+type sha256PasswordPlugin struct{}
+
+func (sha256PasswordPlugin) InitialResponse(info *AuthInfo) ([]byte, error) {
+	switch {
+	case info.Passwd == "":
+		return []byte{0}, nil
+	case info.Secure:
+		return clearPasswd(info.Passwd), nil
+	}
+	return []byte{1}, nil // request public key from server
+}
+
+func (sha256PasswordPlugin) Continue(info *AuthInfo, data []byte) ([]byte, error) {
+	pub, err := parsePublicKey(data)
+	if err != nil {
+		return nil, err
+	}
+	return encryptPassword(info.Passwd, scramble20(info.Scramble), pub)
+}
+
+func (sha256PasswordPlugin) RequiresSecureTransport() bool { return false }
+
+type oldPasswordPlugin struct{}
+
+func (oldPasswordPlugin) InitialResponse(info *AuthInfo) ([]byte, error) {
+	if len(info.Scramble) < 8 {
+		return nil, mysql.ErrAuthentication
+	}
+	// old_password response is terminated by zero
+	return append(encryptedOldPassword(info.Passwd, info.Scramble), 0), nil
+}
+
+func (oldPasswordPlugin) Continue(info *AuthInfo, data []byte) ([]byte, error) {
+	return nil, mysql.ErrUnkResultPkt
+}
+
+func (oldPasswordPlugin) RequiresSecureTransport() bool { return false }
+
+// mysql_clear_password sends the password in clear text, eg. for PAM or LDAP
+// authentication.
+type clearPasswordPlugin struct{}
+
+func (clearPasswordPlugin) InitialResponse(info *AuthInfo) ([]byte, error) {
+	return clearPasswd(info.Passwd), nil
+}
+
+func (clearPasswordPlugin) Continue(info *AuthInfo, data []byte) ([]byte, error) {
+	return nil, mysql.ErrUnkResultPkt
+}
+
+func (clearPasswordPlugin) RequiresSecureTransport() bool { return true }
+
+type ed25519Plugin struct{}
+
+func (ed25519Plugin) InitialResponse(info *AuthInfo) ([]byte, error) {
+	if len(info.Scramble) < 32 {
+		// 20 bytes scramble from the initial handshake: the server sends
+		// the auth switch request with 32 bytes nonce
+		return []byte{}, nil
+	}
+	return ed25519Passwd(info.Passwd, info.Scramble[:32]), nil
+}
+
+func (ed25519Plugin) Continue(info *AuthInfo, data []byte) ([]byte, error) {
+	return nil, mysql.ErrUnkResultPkt
+}
+
+func (ed25519Plugin) RequiresSecureTransport() bool { return false }
+
+type parsecPlugin struct{}
+
+func (parsecPlugin) InitialResponse(info *AuthInfo) ([]byte, error) {
+	// Empty packet requests the salt. After the initial handshake (20 bytes
+	// scramble) the server sends the auth switch request with 32 bytes nonce
+	// instead.
+	return []byte{}, nil
+}
+
+func (parsecPlugin) Continue(info *AuthInfo, data []byte) ([]byte, error) {
+	if len(info.Scramble) < 32 {
+		return nil, mysql.ErrAuthentication
+	}
+	return parsecPasswd(info.Passwd, info.Scramble[:32], data)
+}
+
+func (parsecPlugin) RequiresSecureTransport() bool { return false }
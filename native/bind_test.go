@@ -384,3 +384,14 @@ func TestWrite(t *testing.T) {
 		}
 	}
 }
+
+func TestEncodeDurationReuse(t *testing.T) {
+	// pktWriter reuses its buffer, so the sign byte must be always written
+	buf := make([]byte, 13)
+	EncodeDuration(buf, -time.Second)
+	n := EncodeDuration(buf, 90*time.Minute)
+	exp := []byte{8, 0, 0, 0, 0, 0, 1, 30, 0}
+	if !bytes.Equal(buf[:n], exp) {
+		t.Fatalf("exp: %v res: %v", exp, buf[:n])
+	}
+}
@@ -13,6 +13,7 @@ var (
 	durationType  = reflect.TypeOf(time.Duration(0))
 	blobType      = reflect.TypeOf(mysql.Blob{})
 	rawType       = reflect.TypeOf(mysql.Raw{})
+	decimalType   = reflect.TypeOf(mysql.Decimal(""))
 )
 
 // val should be an addressable value
@@ -39,6 +40,11 @@ func bindValue(val reflect.Value) (out paramValue) {
 	// Obtain value type
 	switch typ.Kind() {
 	case reflect.String:
+		if typ == decimalType {
+			out.typ = MYSQL_TYPE_NEWDECIMAL
+			out.length = -1
+			return
+		}
 		out.typ = MYSQL_TYPE_STRING
 		out.length = -1
 		return
@@ -0,0 +1,100 @@
+package native
+
+import (
+	"io"
+	"log"
+
+	"github.com/ziutek/mymysql/mysql"
+)
+
+// Low level binlog streaming, see the replication package for the event
+// decoder.
+
+// RegisterSlave registers the connection as a replica with server_id using
+// COM_REGISTER_SLAVE. host, user, passwd and port are only reported by SHOW
+// REPLICAS on the source server.
+func (my *Conn) RegisterSlave(server_id uint32, host, user, passwd string, port uint16) (err error) {
+	defer catchError(&err)
+
+	if my.net_conn == nil {
+		return mysql.ErrNotConn
+	}
+	if my.unreaded_reply {
+		return mysql.ErrUnreadedReply
+	}
+	my.sendRegisterSlave(server_id, host, user, passwd, port)
+	my.getResult(nil, nil)
+	return
+}
+
+// BinlogDump requests the binlog stream starting at pos in file using
+// COM_BINLOG_DUMP. After that, the events must be read using ReadBinlogEvent
+// and the connection can't be used for other commands until ReadBinlogEvent
+// returns an error.
+func (my *Conn) BinlogDump(server_id uint32, file string, pos uint32, flags uint16) (err error) {
+	defer catchError(&err)
+
+	if my.net_conn == nil {
+		return mysql.ErrNotConn
+	}
+	if my.unreaded_reply {
+		return mysql.ErrUnreadedReply
+	}
+	my.sendBinlogDump(server_id, file, pos, flags)
+	my.unreaded_reply = true
+	return
+}
+
+// BinlogDumpGTID works like BinlogDump but uses COM_BINLOG_DUMP_GTID.
+// gtid_set is the set of already received transactions in the binary format
+// of the protocol.
+func (my *Conn) BinlogDumpGTID(server_id uint32, file string, pos uint64, gtid_set []byte, flags uint16) (err error) {
+	defer catchError(&err)
+
+	if my.net_conn == nil {
+		return mysql.ErrNotConn
+	}
+	if my.unreaded_reply {
+		return mysql.ErrUnreadedReply
+	}
+	my.sendBinlogDumpGTID(server_id, file, pos, gtid_set, flags)
+	my.unreaded_reply = true
+	return
+}
+
+// ReadBinlogEvent reads the next binlog event (header and body) requested by
+// BinlogDump or BinlogDumpGTID. It returns io.EOF if the server ended the
+// stream (non-blocking mode). To stop a blocking stream close NetConn (it
+// can be done from another goroutine) and use Reconnect.
+func (my *Conn) ReadBinlogEvent() (event []byte, err error) {
+	defer catchError(&err)
+
+	if my.net_conn == nil {
+		return nil, mysql.ErrNotConn
+	}
+	if !my.unreaded_reply {
+		return nil, io.EOF
+	}
+	// Allow other commands after an error
+	defer func() {
+		if err != nil {
+			my.unreaded_reply = false
+		}
+	}()
+	pr := my.newPktReader()
+	switch pkt0 := pr.readByte(); {
+	case pkt0 == 255:
+		my.getErrorPacket(pr)
+	case pkt0 == 254 && pr.last:
+		my.getEndPacket(pr, new(Result))
+		return nil, io.EOF
+	case pkt0 != 0:
+		panic(mysql.ErrUnkResultPkt)
+	}
+	event = pr.readAll()
+	if my.Debug {
+		log.Printf("[%2d ->] Binlog event packet: %d bytes", my.seq-1,
+			len(event))
+	}
+	return
+}
@@ -289,6 +289,7 @@ func (pr *pktReader) readDuration() time.Duration {
 
 func EncodeDuration(buf []byte, d time.Duration) int {
 	buf[0] = 0
+	buf[1] = 0
 	if d < 0 {
 		buf[1] = 1
 		d = -d
@@ -8,7 +8,7 @@ import (
 
 // _COM_QUIT, _COM_STATISTICS, _COM_PROCESS_INFO, _COM_DEBUG, _COM_PING:
 func (my *Conn) sendCmd(cmd byte) {
-	my.seq = 0
+	my.resetSeq()
 	pw := my.newPktWriter(1)
 	pw.writeByte(cmd)
 	if my.Debug {
@@ -18,7 +18,7 @@ func (my *Conn) sendCmd(cmd byte) {
 
 // _COM_QUERY, _COM_INIT_DB, _COM_CREATE_DB, _COM_DROP_DB, _COM_STMT_PREPARE:
 func (my *Conn) sendCmdStr(cmd byte, s string) {
-	my.seq = 0
+	my.resetSeq()
 	pw := my.newPktWriter(1 + len(s))
 	pw.writeByte(cmd)
 	pw.write([]byte(s))
@@ -29,7 +29,7 @@ func (my *Conn) sendCmdStr(cmd byte, s string) {
 
 // _COM_PROCESS_KILL, _COM_STMT_CLOSE, _COM_STMT_RESET:
 func (my *Conn) sendCmdU32(cmd byte, u uint32) {
-	my.seq = 0
+	my.resetSeq()
 	pw := my.newPktWriter(1 + 4)
 	pw.writeByte(cmd)
 	pw.writeU32(u)
@@ -38,8 +38,75 @@ func (my *Conn) sendCmdU32(cmd byte, u uint32) {
 	}
 }
 
+// _COM_STMT_FETCH:
+func (my *Conn) sendFetch(stmtid, rows uint32) {
+	my.resetSeq()
+	pw := my.newPktWriter(1 + 4 + 4)
+	pw.writeByte(_COM_STMT_FETCH)
+	pw.writeU32(stmtid) // Statement ID
+	pw.writeU32(rows)   // Number of rows
+	if my.Debug {
+		log.Printf("[%2d <-] Fetch packet: rows=%d", my.seq-1, rows)
+	}
+}
+
+// _COM_REGISTER_SLAVE:
+func (my *Conn) sendRegisterSlave(server_id uint32, host, user, passwd string, port uint16) {
+	my.resetSeq()
+	pw := my.newPktWriter(1 + 4 + 1 + len(host) + 1 + len(user) + 1 +
+		len(passwd) + 2 + 4 + 4)
+	pw.writeByte(_COM_REGISTER_SLAVE)
+	pw.writeU32(server_id)
+	pw.writeByte(byte(len(host)))
+	pw.write([]byte(host))
+	pw.writeByte(byte(len(user)))
+	pw.write([]byte(user))
+	pw.writeByte(byte(len(passwd)))
+	pw.write([]byte(passwd))
+	pw.writeU16(port)
+	pw.writeU32(0) // Replication rank (ignored)
+	pw.writeU32(0) // Master id (filled by the server)
+	if my.Debug {
+		log.Printf("[%2d <-] Register slave packet: ServerId=%d", my.seq-1,
+			server_id)
+	}
+}
+
+// _COM_BINLOG_DUMP:
+func (my *Conn) sendBinlogDump(server_id uint32, file string, pos uint32, flags uint16) {
+	my.resetSeq()
+	pw := my.newPktWriter(1 + 4 + 2 + 4 + len(file))
+	pw.writeByte(_COM_BINLOG_DUMP)
+	pw.writeU32(pos)
+	pw.writeU16(flags)
+	pw.writeU32(server_id)
+	pw.write([]byte(file))
+	if my.Debug {
+		log.Printf("[%2d <-] Binlog dump packet: File=\"%s\" Pos=%d",
+			my.seq-1, file, pos)
+	}
+}
+
+// _COM_BINLOG_DUMP_GTID:
+func (my *Conn) sendBinlogDumpGTID(server_id uint32, file string, pos uint64, gtid_set []byte, flags uint16) {
+	my.resetSeq()
+	pw := my.newPktWriter(1 + 2 + 4 + 4 + len(file) + 8 + 4 + len(gtid_set))
+	pw.writeByte(_COM_BINLOG_DUMP_GTID)
+	pw.writeU16(flags)
+	pw.writeU32(server_id)
+	pw.writeU32(uint32(len(file)))
+	pw.write([]byte(file))
+	pw.writeU64(pos)
+	pw.writeU32(uint32(len(gtid_set)))
+	pw.write(gtid_set)
+	if my.Debug {
+		log.Printf("[%2d <-] Binlog dump GTID packet: File=\"%s\" Pos=%d",
+			my.seq-1, file, pos)
+	}
+}
+
 func (my *Conn) sendLongData(stmtid uint32, pnum uint16, data []byte) {
-	my.seq = 0
+	my.resetSeq()
 	pw := my.newPktWriter(1 + 4 + 2 + len(data))
 	pw.writeByte(_COM_STMT_SEND_LONG_DATA)
 	pw.writeU32(stmtid) // Statement ID
@@ -0,0 +1,267 @@
+package native
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"io"
+	"log"
+	"sync"
+
+	"github.com/ziutek/mymysql/mysql"
+)
+
+// Compressor compresses and decompresses payloads of compressed protocol
+// packets. Every connection uses its own Compressor, so it doesn't need to be
+// thread safe.
+type Compressor interface {
+	// Compress appends compressed src to dst and returns the result.
+	Compress(dst, src []byte) ([]byte, error)
+	// Decompress appends decompressed src to dst and returns the result.
+	Decompress(dst, src []byte) ([]byte, error)
+}
+
+var (
+	compressorsMutex sync.RWMutex
+	compressors      = map[string]func(level int) Compressor{
+		"zlib": newZlibCompressor,
+	}
+)
+
+// RegisterCompressor registers a function that creates Compressor for given
+// compression level (0 means default level). name can be "zlib" or "zstd"
+// because only these algorithms are supported by the MySQL protocol. zlib
+// is registered by default. zstd isn't implemented in the standard library so
+// you have to register it yourself if you want to use it.
+func RegisterCompressor(name string, newCompressor func(level int) Compressor) {
+	if name != "zlib" && name != "zstd" {
+		panic("unknown compression algorithm: " + name)
+	}
+	compressorsMutex.Lock()
+	compressors[name] = newCompressor
+	compressorsMutex.Unlock()
+}
+
+func getCompressor(name string) func(level int) Compressor {
+	compressorsMutex.RLock()
+	defer compressorsMutex.RUnlock()
+	return compressors[name]
+}
+
+type zlibCompressor struct {
+	level int
+	zw    *zlib.Writer
+	zr    io.ReadCloser
+	wbuf  bytes.Buffer
+	rbuf  bytes.Reader
+}
+
+func newZlibCompressor(level int) Compressor {
+	if level == 0 {
+		level = zlib.DefaultCompression
+	}
+	return &zlibCompressor{level: level}
+}
+
+func (z *zlibCompressor) Compress(dst, src []byte) ([]byte, error) {
+	z.wbuf.Reset()
+	if z.zw == nil {
+		var err error
+		if z.zw, err = zlib.NewWriterLevel(&z.wbuf, z.level); err != nil {
+			return dst, err
+		}
+	} else {
+		z.zw.Reset(&z.wbuf)
+	}
+	if _, err := z.zw.Write(src); err != nil {
+		return dst, err
+	}
+	if err := z.zw.Close(); err != nil {
+		return dst, err
+	}
+	return append(dst, z.wbuf.Bytes()...), nil
+}
+
+func (z *zlibCompressor) Decompress(dst, src []byte) ([]byte, error) {
+	z.rbuf.Reset(src)
+	var err error
+	if z.zr == nil {
+		z.zr, err = zlib.NewReader(&z.rbuf)
+	} else {
+		err = z.zr.(zlib.Resetter).Reset(&z.rbuf, nil)
+	}
+	if err != nil {
+		return dst, err
+	}
+	buf := bytes.NewBuffer(dst)
+	if _, err = buf.ReadFrom(z.zr); err != nil {
+		return dst, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Payloads smaller than this are sent uncompressed.
+const _MIN_COMPRESS_LENGTH = 50
+
+// compressIO implements the compressed protocol: every compressed packet
+// consists of the 7-byte header (3-byte length of payload, sequence number,
+// 3-byte length of uncompressed payload or 0 if payload isn't compressed) and
+// the payload that contains a part of the ordinary packet stream.
+type compressIO struct {
+	rw   io.ReadWriter
+	comp Compressor
+	seq  byte
+
+	hdr  [7]byte
+	rbuf []byte // Unreaded uncompressed data
+	cbuf []byte // Received payload
+	dbuf []byte // Decompressed payload
+	wbuf []byte // Compressed payload
+	obuf []byte // Packet to send
+
+	rtotal uint64 // Number of uncompressed bytes returned by Read
+	rstart uint64 // Value of rtotal at the beginning of the last packet
+	rseq   byte   // Sequence number of the last received packet
+}
+
+func newCompressIO(rw io.ReadWriter, comp Compressor) *compressIO {
+	return &compressIO{rw: rw, comp: comp}
+}
+
+func (c *compressIO) readPacket() error {
+	if _, err := io.ReadFull(c.rw, c.hdr[:]); err != nil {
+		return err
+	}
+	clen := int(DecodeU24(c.hdr[0:3]))
+	ulen := int(DecodeU24(c.hdr[4:7]))
+	if c.hdr[3] != c.seq {
+		return mysql.ErrSeq
+	}
+	c.rseq = c.seq
+	c.rstart = c.rtotal
+	c.seq++
+	if cap(c.cbuf) < clen {
+		c.cbuf = make([]byte, clen)
+	}
+	c.cbuf = c.cbuf[:clen]
+	if _, err := io.ReadFull(c.rw, c.cbuf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	if ulen == 0 {
+		// Uncompressed payload
+		c.rbuf = c.cbuf
+		return nil
+	}
+	var err error
+	c.dbuf, err = c.comp.Decompress(c.dbuf[:0], c.cbuf)
+	if err != nil {
+		return err
+	}
+	if len(c.dbuf) != ulen {
+		return mysql.ErrPkt
+	}
+	c.rbuf = c.dbuf
+	return nil
+}
+
+func (c *compressIO) Read(buf []byte) (int, error) {
+	for len(c.rbuf) == 0 {
+		if err := c.readPacket(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(buf, c.rbuf)
+	c.rbuf = c.rbuf[n:]
+	c.rtotal += uint64(n)
+	return n, nil
+}
+
+func (c *compressIO) Write(buf []byte) (int, error) {
+	written := 0
+	for len(buf) > 0 {
+		n := len(buf)
+		if n > 0xffffff {
+			n = 0xffffff
+		}
+		payload := buf[:n]
+		ulen := 0
+		if n >= _MIN_COMPRESS_LENGTH {
+			var err error
+			c.wbuf, err = c.comp.Compress(c.wbuf[:0], payload)
+			if err != nil {
+				return written, err
+			}
+			if len(c.wbuf) < n {
+				payload = c.wbuf
+				ulen = n
+			}
+		}
+		EncodeU24(c.hdr[0:3], uint32(len(payload)))
+		c.hdr[3] = c.seq
+		EncodeU24(c.hdr[4:7], uint32(ulen))
+		c.seq++
+		c.obuf = append(append(c.obuf[:0], c.hdr[:]...), payload...)
+		if _, err := c.rw.Write(c.obuf); err != nil {
+			return written, err
+		}
+		written += n
+		buf = buf[n:]
+	}
+	return written, nil
+}
+
+// SetCompression enables compressed protocol for Connect and Reconnect.
+// algorithm can be "zlib", "zstd" or "" (compression disabled). level is
+// the compression level, 0 means default level. If the server doesn't support
+// specified algorithm, the algorithm is unknown or its Compressor isn't
+// registered (zstd isn't registered by default, see RegisterCompressor) the
+// connection is uncompressed.
+func (my *Conn) SetCompression(algorithm string, level int) {
+	my.compress = algorithm
+	my.compress_level = level
+}
+
+// compressFlag returns client capability flag for the compression algorithm
+// or 0 if compression is disabled, the algorithm can't be used by the client
+// or isn't supported by the server.
+func (my *Conn) compressFlag() uint32 {
+	var flag uint32
+	switch my.compress {
+	case "zlib":
+		flag = _CLIENT_COMPRESS
+	case "zstd":
+		flag = _CLIENT_ZSTD_COMPRESSION_ALGORITHM
+	default:
+		return 0
+	}
+	if getCompressor(my.compress) == nil || my.info.caps&flag == 0 {
+		return 0
+	}
+	return flag
+}
+
+// startCompression switches the connection to compressed protocol. It should
+// be called after successful authentication.
+func (my *Conn) startCompression() {
+	my.cio = newCompressIO(
+		my.net_conn,
+		getCompressor(my.compress)(my.compress_level),
+	)
+	my.rd = bufio.NewReader(my.cio)
+	my.wr = bufio.NewWriterSize(my.cio, 16*1024)
+	if my.Debug {
+		log.Printf(tab8s+"Compression enabled: %s", my.compress)
+	}
+}
+
+// resetSeq resets sequence numbers before sending a new command.
+func (my *Conn) resetSeq() {
+	my.cmd_count++ // See Result.Warnings
+	my.seq = 0
+	if my.cio != nil {
+		my.cio.seq = 0
+	}
+}
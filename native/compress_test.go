@@ -0,0 +1,103 @@
+package native
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/ziutek/mymysql/mysql"
+)
+
+func TestCompressIO(t *testing.T) {
+	data := [][]byte{
+		[]byte("short"),
+		bytes.Repeat([]byte("compressible data "), 1000),
+		make([]byte, 0x1000003),
+	}
+	for i := range data[2] {
+		data[2][i] = byte(i * 7919 >> 3)
+	}
+
+	var buf bytes.Buffer
+	w := newCompressIO(&buf, newZlibCompressor(0))
+	for _, d := range data {
+		n, err := w.Write(d)
+		if err != nil || n != len(d) {
+			t.Fatalf("Write: n=%d err=%v", n, err)
+		}
+	}
+	if w.seq != 4 {
+		t.Fatalf("Bad sequence number: %d", w.seq)
+	}
+	// Second payload should be compressed
+	if buf.Len() > len(data[0])+len(data[1])+len(data[2]) {
+		t.Fatal("Data wasn't compressed")
+	}
+
+	r := newCompressIO(&buf, newZlibCompressor(0))
+	all, err := ioutil.ReadAll(r)
+	if err != nil || buf.Len() != 0 {
+		t.Fatalf("ReadAll: err=%v, unreaded=%d", err, buf.Len())
+	}
+	if !bytes.Equal(all, bytes.Join(data, nil)) {
+		t.Fatal("Decompressed data doesn't match")
+	}
+
+	// Wrong sequence number
+	w.seq = 7
+	w.Write(data[0])
+	r.seq = 0
+	if _, err = r.Read(make([]byte, 10)); err == nil {
+		t.Fatal("Wrong sequence number accepted")
+	}
+}
+
+func TestCompressedSeq(t *testing.T) {
+	var buf bytes.Buffer
+	w := newCompressIO(&buf, newZlibCompressor(0))
+	w.seq = 1
+	w.Write([]byte{1, 0, 0, 1, 'a'})
+	w.Write([]byte{1, 0, 0, 2, 'b', 1, 0, 0, 3, 'c'})
+	// The server flushed its buffer: sequence number of the compressed packet
+	w.Write([]byte{1, 0, 0, 3, 'd', 1, 0, 0, 5, 'e'})
+
+	r := newCompressIO(&buf, newZlibCompressor(0))
+	r.seq = 1
+	seq := byte(1)
+	rd := bufio.NewReader(r)
+	read := func() (data []byte, err error) {
+		defer catchError(&err)
+		pr := &pktReader{rd: rd, seq: &seq, cio: r}
+		return pr.readAll(), nil
+	}
+	for _, want := range "abcd" {
+		data, err := read()
+		if err != nil || string(data) != string(want) {
+			t.Fatalf("Bad packet: %q %v", data, err)
+		}
+	}
+	// Out of order packet inside the compressed packet
+	if _, err := read(); err != mysql.ErrSeq {
+		t.Fatal("Bad sequence number accepted:", err)
+	}
+}
+
+func TestCompressFlag(t *testing.T) {
+	my := New("tcp", "", "127.0.0.1:3306", "", "").(*Conn)
+	my.info.caps = _CLIENT_COMPRESS | _CLIENT_ZSTD_COMPRESSION_ALGORITHM
+	for _, v := range []struct {
+		algorithm string
+		flag      uint32
+	}{
+		{"", 0},
+		{"zlib", _CLIENT_COMPRESS},
+		{"zstd", 0}, // Not registered
+		{"lz4", 0},
+	} {
+		my.SetCompression(v.algorithm, 0)
+		if f := my.compressFlag(); f != v.flag {
+			t.Errorf("%q: bad flag: %x", v.algorithm, f)
+		}
+	}
+}
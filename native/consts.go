@@ -29,6 +29,8 @@ const (
 	_CLIENT_CAN_HANDLE_EXPIRED_PASSWORDS               // Enable/disable expired passwords
 	_CLIENT_SESSION_TRACK                              // Can set SERVER_SESSION_STATE_CHANGED in the Status Flags and send session-state change data after a OK packet.
 	_CLIENT_DEPRECATE_EOF                              // Expects an OK (instead of EOF) after the resultset rows of a Text Resultset
+	_CLIENT_OPTIONAL_RESULTSET_METADATA                // The client can handle optional metadata information in the resultset
+	_CLIENT_ZSTD_COMPRESSION_ALGORITHM                 // Can use zstd compression protocol
 )
 
 // Commands - borrowed from GoMySQL
@@ -61,6 +63,14 @@ const (
 	_COM_STMT_RESET          = 0x1a
 	_COM_SET_OPTION          = 0x1b
 	_COM_STMT_FETCH          = 0x1c
+	_COM_BINLOG_DUMP_GTID    = 0x1e
+	_COM_RESET_CONNECTION    = 0x1f
+)
+
+// Cursor types for COM_STMT_EXECUTE
+const (
+	_CURSOR_TYPE_NO_CURSOR = 0x00
+	_CURSOR_TYPE_READ_ONLY = 0x01
 )
 
 // MySQL protocol types.
@@ -85,6 +95,10 @@ const (
 	MYSQL_TYPE_NEWDATE     = 0x0e
 	MYSQL_TYPE_VARCHAR     = 0x0f
 	MYSQL_TYPE_BIT         = 0x10
+	MYSQL_TYPE_TIMESTAMP2  = 0x11 // Only in binlog
+	MYSQL_TYPE_DATETIME2   = 0x12 // Only in binlog
+	MYSQL_TYPE_TIME2       = 0x13 // Only in binlog
+	MYSQL_TYPE_JSON        = 0xf5
 	MYSQL_TYPE_NEWDECIMAL  = 0xf6
 	MYSQL_TYPE_ENUM        = 0xf7
 	MYSQL_TYPE_SET         = 0xf8
@@ -143,8 +157,11 @@ const (
 	IN_LONGTEXT   = MYSQL_TYPE_LONG_BLOB   // []byte
 
 	// MySQL 5.x specific
-	IN_DECIMAL = MYSQL_TYPE_NEWDECIMAL // TODO
+	IN_DECIMAL = MYSQL_TYPE_NEWDECIMAL // float64 or mysql.Decimal
 	IN_BIT     = MYSQL_TYPE_BIT        // []byte
+
+	// MySQL 5.7+ specific
+	IN_JSON = MYSQL_TYPE_JSON // json.RawMessage
 )
 
 // Flags - borrowed from GoMySQL
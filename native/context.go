@@ -0,0 +1,147 @@
+package native
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/ziutek/mymysql/mysql"
+)
+
+// SetCancelTimeout sets the time that StartContext and RunContext wait for
+// the server response after the context is cancelled. It is also used as
+// connect timeout for the connection that sends KILL QUERY. Default 10s.
+func (my *Conn) SetCancelTimeout(timeout time.Duration) {
+	my.cancel_timeout = timeout
+}
+
+// killQuery kills the query currently executed by the server thread of my
+// connection. It uses a new short-lived connection.
+func (my *Conn) killQuery() (err error) {
+	c := my.Clone().(*Conn)
+	c.timeout = my.cancel_timeout
+	if err = c.Connect(); err != nil {
+		return
+	}
+	defer c.Close()
+	_, err = c.Start("KILL QUERY %d", my.info.thr_id)
+	return
+}
+
+// watchCancel waits for done or ctx.Done(). In the second case it sets the
+// deadline for the network connection and kills the current query. It sends
+// true to killed if the query was killed.
+func (my *Conn) watchCancel(ctx context.Context, done <-chan struct{},
+	killed chan<- bool) {
+
+	select {
+	case <-done:
+		killed <- false
+	case <-ctx.Done():
+		my.net_conn.SetDeadline(time.Now().Add(my.cancel_timeout))
+		err := my.killQuery()
+		if my.Debug {
+			log.Printf("Context done: %v, KILL QUERY %d: %v", ctx.Err(),
+				my.info.thr_id, err)
+		}
+		killed <- true
+	}
+}
+
+// markBroken closes the network connection without sending anything to the
+// server. After this IsConnected returns false and Reconnect can be used.
+func (my *Conn) markBroken() {
+	my.net_conn.Close()
+	my.net_conn = nil
+	my.unreaded_reply = false
+}
+
+// drain reads and discards all rows of res and all next results.
+func (my *Conn) drain(res mysql.Result) (err error) {
+	for res != nil {
+		if err = res.End(); err != nil {
+			break
+		}
+		if res, err = res.NextResult(); err != nil {
+			break
+		}
+	}
+	if _, ok := err.(*mysql.Error); ok {
+		// Error packet ends the result
+		my.unreaded_reply = false
+	}
+	return
+}
+
+// runContext calls f which should send a command and read the response. If ctx
+// is done before f returns, the command is killed and the connection is
+// restored to usable state or closed (if this is impossible). In this case
+// ctx.Err() is returned.
+func (my *Conn) runContext(ctx context.Context,
+	f func() (mysql.Result, error)) (mysql.Result, error) {
+
+	if ctx.Done() == nil || my.net_conn == nil || my.unreaded_reply {
+		// Context can't be cancelled or f returns an error immediately
+		return f()
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	done := make(chan struct{})
+	killed := make(chan bool, 1)
+	go my.watchCancel(ctx, done, killed)
+	res, err := f()
+	close(done)
+	if !<-killed {
+		return res, err
+	}
+
+	// The command was cancelled
+	if my.net_conn != nil {
+		my.net_conn.SetDeadline(time.Time{})
+		switch err.(type) {
+		case nil:
+			// Killed too late
+			if e := my.drain(res); e != nil {
+				if _, ok := e.(*mysql.Error); !ok {
+					my.markBroken()
+				}
+			}
+		case *mysql.Error:
+			// Killed, connection is usable
+		default:
+			my.markBroken()
+		}
+	}
+	my.traceEnd(ctx.Err())
+	return nil, ctx.Err()
+}
+
+// StartContext works like Start but if ctx is done before the server response
+// is readed, the query is killed using KILL QUERY sent by a clone of my
+// connection and ctx.Err() is returned. The connection is drained to usable
+// state or closed if it is impossible (in this case IsConnected returns false).
+// ctx doesn't affect reading the rows from the returned result.
+func (my *Conn) StartContext(ctx context.Context, sql string,
+	params ...interface{}) (mysql.Result, error) {
+
+	my.trace_ctx = ctx
+	defer func() { my.trace_ctx = nil }()
+	return my.runContext(ctx, func() (mysql.Result, error) {
+		return my.Start(sql, params...)
+	})
+}
+
+// RunContext works like Run but it can be cancelled using ctx. See
+// Conn.StartContext for details.
+func (stmt *Stmt) RunContext(ctx context.Context,
+	params ...interface{}) (mysql.Result, error) {
+
+	my := stmt.my
+	my.trace_ctx = ctx
+	defer func() { my.trace_ctx = nil }()
+	return my.runContext(ctx, func() (mysql.Result, error) {
+		return stmt.Run(params...)
+	})
+}
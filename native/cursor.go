@@ -0,0 +1,186 @@
+package native
+
+import (
+	"bufio"
+	"io"
+
+	"github.com/ziutek/mymysql/mysql"
+)
+
+// cursor is a read-only server side cursor opened by Stmt.Run.
+type cursor struct {
+	stmt   *Stmt
+	size   uint32      // Number of rows fetched at once
+	rows   []mysql.Row // Fetched and not yet scanned rows
+	done   bool        // All rows were fetched
+	closed bool        // Cursor was closed before all rows were fetched
+}
+
+// SetFetchSize enables server side cursors for the statement. If n > 0, Run
+// opens a read-only cursor on the server and the rows of the result are
+// fetched in batches of n rows using COM_STMT_FETCH. Fetched rows are
+// buffered, so between batches the connection can be used to execute other
+// queries and statements. The cursor is closed when all rows are read, by
+// Result.End, or when the statement is executed again, reset or deleted. If
+// n <= 0 (default) cursors aren't used.
+//
+// The server opens cursors only for statements that return result sets.
+// Cursors can't be used with statements that return multiple results.
+func (stmt *Stmt) SetFetchSize(n int) {
+	if n < 0 {
+		n = 0
+	}
+	stmt.fetch_size = n
+}
+
+// cursorOpened returns true if the server opened a cursor for res returned by
+// the execution. With CLIENT_DEPRECATE_EOF there is no EOF packet after the
+// fields, so the next packet is checked: if the cursor was opened it is the
+// OK packet (with 254 header) that contains SERVER_STATUS_CURSOR_EXISTS.
+// Otherwise the rows follow and the packet must be left unread.
+func (stmt *Stmt) cursorOpened(res *Result) bool {
+	my := stmt.my
+	if my.caps&_CLIENT_DEPRECATE_EOF != 0 && stmt.fetch_size > 0 &&
+		peekEndStatus(my.rd)&mysql.SERVER_STATUS_CURSOR_EXISTS != 0 {
+		my.getResult(res, nil)
+	}
+	return res.status&mysql.SERVER_STATUS_CURSOR_EXISTS != 0
+}
+
+// peekEndStatus returns the server status from the next packet without
+// reading it, if the packet is the OK packet that ends the rows (otherwise 0).
+func peekEndStatus(rd *bufio.Reader) mysql.ConnStatus {
+	hdr, err := rd.Peek(5)
+	if err != nil {
+		panic(err)
+	}
+	n := int(DecodeU24(hdr))
+	if hdr[4] != 254 || n == 0xffffff {
+		return 0
+	}
+	// Header, affected rows, insert id (at most 9 bytes each), status
+	if n > 1+9+9+2 {
+		n = 1 + 9 + 9 + 2
+	}
+	pkt, err := rd.Peek(4 + n)
+	if err != nil {
+		panic(err)
+	}
+	pkt = pkt[5:]
+	for i := 0; i < 2; i++ {
+		// Skip length coded binary
+		if len(pkt) == 0 {
+			return 0
+		}
+		k := 1
+		switch pkt[0] {
+		case 0xfc:
+			k = 3
+		case 0xfd:
+			k = 4
+		case 0xfe:
+			k = 9
+		}
+		if len(pkt) < k {
+			return 0
+		}
+		pkt = pkt[k:]
+	}
+	if len(pkt) < 2 {
+		return 0
+	}
+	return mysql.ConnStatus(DecodeU16(pkt))
+}
+
+// openCursor is called by Run if the server opened a cursor for res.
+func (stmt *Stmt) openCursor(res *Result) {
+	stmt.dropCursor()
+	stmt.cursor = &cursor{stmt: stmt, size: uint32(stmt.fetch_size)}
+	res.cursor = stmt.cursor
+	// Rows will be fetched on demand
+	stmt.my.unreaded_reply = false
+}
+
+// dropCursor marks the open cursor as closed. It should be called before
+// the server closes the cursor (COM_STMT_EXECUTE, COM_STMT_RESET,
+// COM_STMT_CLOSE).
+func (stmt *Stmt) dropCursor() {
+	if stmt.cursor != nil {
+		stmt.cursor.closed = true
+		stmt.cursor.rows = nil
+		stmt.cursor = nil
+	}
+}
+
+// fetch reads the next batch of rows from the cursor.
+func (res *Result) fetch() {
+	cur := res.cursor
+	res.my.sendFetch(cur.stmt.id, cur.size)
+	for {
+		row := res.MakeRow()
+		if res.my.getResult(res, row) != nil {
+			// EOF packet
+			break
+		}
+		cur.rows = append(cur.rows, row)
+	}
+	if res.status&mysql.SERVER_STATUS_LAST_ROW_SENT != 0 ||
+		res.status&mysql.SERVER_STATUS_CURSOR_EXISTS == 0 {
+		cur.done = true
+		cur.stmt.cursor = nil
+	}
+}
+
+// scanCursorRow works like getRow but reads rows from the cursor.
+func (res *Result) scanCursorRow(row mysql.Row) (err error) {
+	defer catchError(&err)
+
+	cur := res.cursor
+	if len(cur.rows) == 0 {
+		if cur.closed {
+			return mysql.ErrCursorClosed
+		}
+		if cur.done {
+			return io.EOF
+		}
+		if res.my.net_conn == nil {
+			return mysql.ErrNotConn
+		}
+		if res.my.unreaded_reply {
+			return mysql.ErrUnreadedReply
+		}
+		res.fetch()
+		if len(cur.rows) == 0 {
+			return io.EOF
+		}
+	}
+	copy(row, cur.rows[0])
+	cur.rows[0] = nil
+	cur.rows = cur.rows[1:]
+	return nil
+}
+
+// endCursor closes the cursor without fetching remaining rows.
+func (res *Result) endCursor() (err error) {
+	defer catchError(&err)
+
+	cur := res.cursor
+	res.eor_returned = true
+	cur.rows = nil
+	if cur.done || cur.closed {
+		return
+	}
+	stmt := cur.stmt
+	if stmt.my.net_conn == nil {
+		return mysql.ErrNotConn
+	}
+	if stmt.my.unreaded_reply {
+		return mysql.ErrUnreadedReply
+	}
+	cur.done = true
+	stmt.cursor = nil
+	stmt.rebind = true
+	stmt.my.sendCmdU32(_COM_STMT_RESET, stmt.id)
+	stmt.my.getResult(nil, nil)
+	return
+}
@@ -0,0 +1,972 @@
+package native
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ziutek/mymysql/mysql"
+	"github.com/ziutek/mymysql/mysqltest"
+)
+
+// Tests that use the fake server, so they don't need a MySQL server.
+
+func startFake(t *testing.T, srv *mysqltest.Server) {
+	srv.User, srv.Passwd = user, passwd
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func fakeConn(srv *mysqltest.Server) *Conn {
+	c := New("tcp", "", srv.Addr(), user, passwd, dbname).(*Conn)
+	c.Debug = debug
+	return c
+}
+
+func TestFakeTLS(t *testing.T) {
+	srv_cfg, cli_cfg, err := mysqltest.NewTLSConfig()
+	checkErr(t, err, nil)
+	srv := &mysqltest.Server{TLSConfig: srv_cfg}
+	startFake(t, srv)
+	defer srv.Close()
+	srv.HandleQuery("select 1", mysqltest.Rows("1").AddRow(1))
+
+	c := fakeConn(srv)
+	c.SetTLSConfig(cli_cfg) // verify-identity
+	checkErr(t, c.Connect(), nil)
+	if _, ok := c.NetConn().(*tls.Conn); !ok {
+		t.Fatal("Connection isn't encrypted")
+	}
+	row, _, err := c.QueryFirst("select 1")
+	checkErr(t, err, nil)
+	if row.Int(0) != 1 {
+		t.Fatal("Bad result")
+	}
+	checkErr(t, c.Close(), nil)
+
+	// Certificate doesn't match the server name
+	c = fakeConn(srv)
+	cfg := cli_cfg.Clone()
+	cfg.ServerName = "example.com"
+	c.SetTLSConfig(cfg)
+	if c.Connect() == nil {
+		t.Fatal("Bad server name accepted")
+	}
+	// User's configuration is verified also in the required mode
+	c.SetTLSMode(mysql.TLSRequired)
+	if c.Connect() == nil {
+		t.Fatal("User's TLS configuration wasn't verified")
+	}
+	// Default configuration doesn't verify the certificate. nil configuration
+	// doesn't disable TLS.
+	c = fakeConn(srv)
+	c.SetTLSMode(mysql.TLSRequired)
+	c.SetTLSConfig(nil)
+	checkErr(t, c.Connect(), nil)
+	if _, ok := c.NetConn().(*tls.Conn); !ok {
+		t.Fatal("Connection isn't encrypted")
+	}
+	checkErr(t, c.Close(), nil)
+
+	// Server without TLS
+	srv = new(mysqltest.Server)
+	startFake(t, srv)
+	defer srv.Close()
+	c = fakeConn(srv)
+	c.SetTLSMode(mysql.TLSRequired)
+	checkErr(t, c.Connect(), mysql.ErrNoTLS)
+	c.SetTLSMode(mysql.TLSPreferred)
+	checkErr(t, c.Connect(), nil)
+	checkErr(t, c.Close(), nil)
+}
+
+func TestFakeCompression(t *testing.T) {
+	srv := &mysqltest.Server{Compress: true}
+	startFake(t, srv)
+	defer srv.Close()
+
+	long := strings.Repeat("compressible ", 5000)
+	rs := mysqltest.Rows("s")
+	for i := 0; i < 100; i++ {
+		rs.AddRow(fmt.Sprint(i, long))
+	}
+	srv.HandleQuery("select s from t", rs)
+	srv.HandleFunc("select ?", func(cmd *mysqltest.Command) []mysqltest.Result {
+		rs := mysqltest.Rows("?")
+		if cmd.Cmd == mysqltest.COM_STMT_EXECUTE {
+			rs.AddRow(cmd.Args...)
+		}
+		return []mysqltest.Result{rs}
+	})
+
+	c := fakeConn(srv)
+	c.SetCompression("zlib", 0)
+	checkErr(t, c.Connect(), nil)
+	defer c.Close()
+	if c.cio == nil {
+		t.Fatal("Compression isn't enabled")
+	}
+	rows, _, err := c.Query("select s from t")
+	checkErr(t, err, nil)
+	if len(rows) != 100 || rows[99].Str(0) != fmt.Sprint(99, long) {
+		t.Fatal("Bad rows")
+	}
+	stmt, err := c.Prepare("select ?")
+	checkErr(t, err, nil)
+	big := strings.Repeat("x", 1024*1024)
+	row, _, err := stmt.ExecFirst(big)
+	checkErr(t, err, nil)
+	if row.Str(0) != big {
+		t.Fatal("Bad big value")
+	}
+	checkErr(t, c.Ping(), nil)
+}
+
+func TestFakeLocalInfile(t *testing.T) {
+	srv := new(mysqltest.Server)
+	startFake(t, srv)
+	defer srv.Close()
+
+	q := "LOAD DATA LOCAL INFILE 'Reader::lines' INTO TABLE t"
+	srv.HandleQuery(q,
+		&mysqltest.LocalInfile{Name: "Reader::lines"},
+		&mysqltest.OK{AffectedRows: 3},
+	)
+	srv.HandleQuery("LOAD DATA LOCAL INFILE '/etc/passwd' INTO TABLE t",
+		&mysqltest.LocalInfile{Name: "/etc/passwd"},
+		mysqltest.Err(mysql.ER_UNKNOWN_ERROR, "Empty file"),
+	)
+	// Sent in more than one packet
+	data := strings.Repeat("a\tb\n", 20000)
+	RegisterReaderHandler("lines", func() io.Reader {
+		return strings.NewReader(data)
+	})
+	defer DeregisterReaderHandler("lines")
+
+	c := fakeConn(srv)
+	checkErr(t, c.Connect(), nil)
+	defer c.Close()
+	_, res, err := c.Query(q)
+	checkErr(t, err, nil)
+	if res.AffectedRows() != 3 {
+		t.Fatal("Bad affected rows")
+	}
+	// Not registered file
+	if _, _, err = c.Query(
+		"LOAD DATA LOCAL INFILE '/etc/passwd' INTO TABLE t"); err == nil {
+		t.Fatal("Not registered file sent")
+	}
+	checkErr(t, c.Ping(), nil)
+
+	cmds := srv.Commands()
+	if !bytes.Equal(cmds[0].Infile, []byte(data)) {
+		t.Fatalf("Bad file content: %q", cmds[0].Infile)
+	}
+	if len(cmds[1].Infile) != 0 {
+		t.Fatal("Not registered file sent")
+	}
+}
+
+func TestFakeStartContext(t *testing.T) {
+	srv := new(mysqltest.Server)
+	startFake(t, srv)
+	defer srv.Close()
+	srv.HandleQuery("select sleep(10)",
+		mysqltest.Delay(10*time.Second),
+		mysqltest.Rows("sleep(10)").AddRow(0),
+	)
+	srv.HandleQuery("select 1", mysqltest.Rows("1").AddRow(1))
+
+	c := fakeConn(srv)
+	checkErr(t, c.Connect(), nil)
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(),
+		100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := c.StartContext(ctx, "select sleep(10)")
+	checkErr(t, err, context.DeadlineExceeded)
+	if time.Since(start) > 5*time.Second {
+		t.Fatal("Query wasn't killed")
+	}
+	if !c.IsConnected() {
+		t.Fatal("Connection was closed")
+	}
+	res, err := c.StartContext(context.Background(), "select 1")
+	checkErr(t, err, nil)
+	row, err := res.GetFirstRow()
+	checkErr(t, err, nil)
+	if row.Int(0) != 1 {
+		t.Fatal("Bad result after cancellation")
+	}
+	kill := fmt.Sprintf("KILL QUERY %d", c.ThreadId())
+	found := false
+	for _, q := range srv.Queries() {
+		found = found || q == kill
+	}
+	if !found {
+		t.Fatalf("%s wasn't sent: %q", kill, srv.Queries())
+	}
+}
+
+func TestFakeCursor(t *testing.T) {
+	srv := new(mysqltest.Server)
+	startFake(t, srv)
+	defer srv.Close()
+	rs := &mysqltest.ResultSet{
+		Columns: []mysqltest.Column{{Name: "id", Type: MYSQL_TYPE_LONGLONG}},
+	}
+	for i := 0; i < 5; i++ {
+		rs.AddRow(i)
+	}
+	srv.HandleQuery("select id from t", rs)
+	srv.HandleQuery("select 1", mysqltest.Rows("1").AddRow(1))
+
+	c := fakeConn(srv)
+	checkErr(t, c.Connect(), nil)
+	defer c.Close()
+	stmt, err := c.Prepare("select id from t")
+	checkErr(t, err, nil)
+	stmt.SetFetchSize(2)
+
+	res, err := stmt.Run()
+	checkErr(t, err, nil)
+	row := res.MakeRow()
+	for i := 0; i < 5; i++ {
+		checkErr(t, res.ScanRow(row), nil)
+		if row.Int(0) != i {
+			t.Fatalf("Bad row %d: %v", i, row)
+		}
+		// Connection can be used between fetches
+		_, _, err = c.QueryFirst("select 1")
+		checkErr(t, err, nil)
+	}
+	checkErr(t, res.ScanRow(row), io.EOF)
+
+	var fetches []uint32
+	for _, cmd := range srv.Commands() {
+		if cmd.Cmd == mysqltest.COM_STMT_FETCH {
+			fetches = append(fetches, cmd.FetchRows)
+		}
+	}
+	if len(fetches) != 3 || fetches[0] != 2 {
+		t.Fatalf("Bad fetches: %v", fetches)
+	}
+
+	// End closes the cursor
+	srv.Reset()
+	res, err = stmt.Run()
+	checkErr(t, err, nil)
+	checkErr(t, res.ScanRow(row), nil)
+	checkErr(t, res.End(), nil)
+	cmds := srv.Commands()
+	if last := cmds[len(cmds)-1]; last.Cmd != mysqltest.COM_STMT_RESET {
+		t.Fatalf("Cursor wasn't closed: %+v", last)
+	}
+
+	// Next execution closes the cursor
+	res, err = stmt.Run()
+	checkErr(t, err, nil)
+	checkErr(t, res.ScanRow(row), nil)
+	_, _, err = stmt.Exec()
+	checkErr(t, err, nil)
+	checkErr(t, res.ScanRow(row), mysql.ErrCursorClosed)
+
+	// Without fetch size rows are read from the connection
+	stmt.SetFetchSize(0)
+	res, err = stmt.Run()
+	checkErr(t, err, nil)
+	_, _, err = c.Query("select 1")
+	checkErr(t, err, mysql.ErrUnreadedReply)
+	checkErr(t, res.End(), nil)
+}
+
+func fakeCmds(srv *mysqltest.Server) []byte {
+	var cmds []byte
+	for _, cmd := range srv.Commands() {
+		cmds = append(cmds, cmd.Cmd)
+	}
+	return cmds
+}
+
+func TestFakeResetSession(t *testing.T) {
+	srv := &mysqltest.Server{Users: map[string]string{"tenant": "tenantpw"}}
+	startFake(t, srv)
+	defer srv.Close()
+	srv.HandleFunc("select ?", func(cmd *mysqltest.Command) []mysqltest.Result {
+		rs := mysqltest.Rows("?")
+		if cmd.Cmd == mysqltest.COM_STMT_EXECUTE {
+			rs.AddRow(cmd.Args...)
+		}
+		return []mysqltest.Result{rs}
+	})
+
+	c := fakeConn(srv)
+	c.Register("SET NAMES utf8")
+	checkErr(t, c.Connect(), nil)
+	defer c.Close()
+	stmt, err := c.Prepare("select ?")
+	checkErr(t, err, nil)
+
+	checkStmt := func() {
+		t.Helper()
+		row, _, err := stmt.ExecFirst(7)
+		checkErr(t, err, nil)
+		if row.Int(0) != 7 {
+			t.Fatalf("Bad row: %v", row)
+		}
+	}
+	srv.Reset()
+	checkErr(t, c.ResetSession(), nil)
+	checkStmt()
+	exp := []byte{
+		mysqltest.COM_RESET_CONNECTION, mysqltest.COM_QUERY,
+		mysqltest.COM_STMT_PREPARE, mysqltest.COM_STMT_EXECUTE,
+	}
+	if cmds := fakeCmds(srv); !bytes.Equal(cmds, exp) {
+		t.Fatalf("Bad commands: %v", cmds)
+	}
+
+	// Server without COM_RESET_CONNECTION
+	srv.HandleCommand(mysqltest.COM_RESET_CONNECTION,
+		func(cmd *mysqltest.Command) []mysqltest.Result {
+			return []mysqltest.Result{
+				mysqltest.Err(mysql.ER_UNKNOWN_COM_ERROR, "Unknown command"),
+			}
+		},
+	)
+	srv.Reset()
+	checkErr(t, c.ResetSession(), nil)
+	checkStmt()
+	cmds := srv.Commands()
+	if cmds[1].Cmd != mysqltest.COM_CHANGE_USER || cmds[1].Query != user {
+		t.Fatalf("Bad fallback: %+v", cmds[1])
+	}
+
+	checkErr(t, c.ChangeUser("tenant", "tenantpw", "other"), nil)
+	checkStmt()
+	if u, p := c.Credentials(); u != "tenant" || p != "tenantpw" {
+		t.Fatal("Credentials weren't changed")
+	}
+
+	// Bad password closes the connection
+	checkAccessDenied(t, c.ChangeUser(user, "bad", dbname))
+	if c.IsConnected() {
+		t.Fatal("Connection wasn't closed")
+	}
+	if u, _ := c.Credentials(); u != "tenant" {
+		t.Fatal("Credentials were changed")
+	}
+	checkErr(t, c.Reconnect(), nil)
+	checkStmt()
+}
+
+func TestFakeDecimal(t *testing.T) {
+	srv := new(mysqltest.Server)
+	startFake(t, srv)
+	defer srv.Close()
+	srv.HandleFunc("select ?", func(cmd *mysqltest.Command) []mysqltest.Result {
+		rs := &mysqltest.ResultSet{Columns: []mysqltest.Column{
+			{Name: "d", Type: MYSQL_TYPE_NEWDECIMAL, Decimals: 6},
+		}}
+		if cmd.Cmd == mysqltest.COM_STMT_EXECUTE {
+			rs.AddRow(cmd.Args...)
+		}
+		return []mysqltest.Result{rs}
+	})
+
+	c := fakeConn(srv)
+	checkErr(t, c.Connect(), nil)
+	defer c.Close()
+	stmt, err := c.Prepare("select ?")
+	checkErr(t, err, nil)
+
+	d := mysql.Decimal("12345678901234.123457")
+	row, _, err := stmt.ExecFirst(d)
+	checkErr(t, err, nil)
+	if _, ok := row[0].(float64); !ok {
+		t.Fatalf("Bad type of DECIMAL in float mode: %T", row[0])
+	}
+	if arg := srv.Commands()[1].Args[0]; string(arg.([]byte)) != string(d) {
+		t.Fatalf("Bad parameter: %v", arg)
+	}
+
+	c.SetDecimalMode(mysql.DecimalExact)
+	row, _, err = stmt.ExecFirst(d)
+	checkErr(t, err, nil)
+	if row[0] != d || row.Decimal(0) != d {
+		t.Fatalf("Bad DECIMAL in exact mode: %v", row[0])
+	}
+	c.NarrowTypeSet(true)
+	row, _, err = stmt.ExecFirst(d)
+	checkErr(t, err, nil)
+	if b, ok := row[0].([]byte); !ok || string(b) != string(d) {
+		t.Fatalf("Bad narrow DECIMAL in exact mode: %v", row[0])
+	}
+}
+
+func TestFakeJSON(t *testing.T) {
+	srv := new(mysqltest.Server)
+	startFake(t, srv)
+	defer srv.Close()
+	srv.HandleFunc("select ?", func(cmd *mysqltest.Command) []mysqltest.Result {
+		rs := &mysqltest.ResultSet{Columns: []mysqltest.Column{
+			{Name: "j", Type: MYSQL_TYPE_JSON},
+		}}
+		if cmd.Cmd == mysqltest.COM_STMT_EXECUTE {
+			rs.AddRow(cmd.Args...)
+		}
+		return []mysqltest.Result{rs}
+	})
+
+	c := fakeConn(srv)
+	checkErr(t, c.Connect(), nil)
+	defer c.Close()
+	stmt, err := c.Prepare("select ?")
+	checkErr(t, err, nil)
+
+	row, _, err := stmt.ExecFirst(json.RawMessage(`{"a":[1,2]}`))
+	checkErr(t, err, nil)
+	if _, ok := row[0].(json.RawMessage); !ok {
+		t.Fatalf("Bad type of JSON value: %T", row[0])
+	}
+	var v struct{ A []int }
+	checkErr(t, row.JSON(0, &v), nil)
+	if len(v.A) != 2 || v.A[1] != 2 {
+		t.Fatalf("Bad JSON value: %+v", v)
+	}
+}
+
+func TestFakeInterpolate(t *testing.T) {
+	srv := new(mysqltest.Server)
+	startFake(t, srv)
+	defer srv.Close()
+
+	c := fakeConn(srv)
+	checkErr(t, c.Connect(), nil)
+	defer c.Close()
+	c.InterpolateParams(true)
+
+	exp := `SELECT * FROM t WHERE a = 'x\'y' AND b = '?'`
+	srv.HandleQuery(exp, mysqltest.Rows("a", "b"))
+	srv.Reset()
+	_, _, err := c.Query("SELECT * FROM t WHERE a = ? AND b = '?'", "x'y")
+	checkErr(t, err, nil)
+	if q := srv.Commands()[0].Query; q != exp {
+		t.Fatalf("Bad query: %s", q)
+	}
+	_, _, err = c.Query("SELECT ?")
+	checkErr(t, err, mysql.ErrBindCount)
+}
+
+type testTracer struct {
+	mysql.NopTracer
+	events []string
+}
+
+func (t *testTracer) Connect(ev *mysql.ConnectEvent) {
+	t.events = append(t.events, fmt.Sprintf("connect %v", ev.Err))
+}
+
+func (t *testTracer) Auth(ev *mysql.AuthEvent) {
+	t.events = append(t.events, fmt.Sprintf("auth %s %v", ev.User, ev.Err))
+}
+
+func (t *testTracer) QueryStart(ev *mysql.QueryEvent) {
+	ev.Data = len(t.events)
+	t.events = append(t.events, "query "+ev.SQL)
+}
+
+func (t *testTracer) QueryEnd(ev *mysql.QueryEvent) {
+	t.events = append(t.events, fmt.Sprintf("end %d %d %d %v", ev.Data,
+		ev.Rows, ev.AffectedRows, ev.Err))
+}
+
+func (t *testTracer) Prepare(ev *mysql.StmtEvent) {
+	t.events = append(t.events, fmt.Sprintf("prepare %s %v", ev.SQL, ev.Err))
+}
+
+func (t *testTracer) ExecStart(ev *mysql.QueryEvent) {
+	t.events = append(t.events, "exec "+ev.SQL)
+}
+
+func (t *testTracer) ExecEnd(ev *mysql.QueryEvent) {
+	t.events = append(t.events, fmt.Sprintf("exec end %d %v", ev.Rows, ev.Err))
+}
+
+func (t *testTracer) StmtClose(ev *mysql.StmtEvent) {
+	t.events = append(t.events, fmt.Sprintf("close %s %v", ev.SQL, ev.Err))
+}
+
+func (t *testTracer) Reconnect(ev *mysql.ConnectEvent) {
+	t.events = append(t.events, fmt.Sprintf("reconnect %v", ev.Err))
+}
+
+func TestFakeTracer(t *testing.T) {
+	srv := new(mysqltest.Server)
+	startFake(t, srv)
+	defer srv.Close()
+	srv.HandleQuery("select a", mysqltest.Rows("a").AddRow(1).AddRow(2))
+	srv.HandleQuery("call p", mysqltest.Rows("a").AddRow(1),
+		&mysqltest.OK{AffectedRows: 3})
+	srv.HandleQuery("bad", mysqltest.Err(mysql.ER_PARSE_ERROR, "syntax"))
+	srv.HandleFunc("select ?", func(cmd *mysqltest.Command) []mysqltest.Result {
+		rs := mysqltest.Rows("?")
+		if cmd.Cmd == mysqltest.COM_STMT_EXECUTE {
+			rs.AddRow(cmd.Args...)
+		}
+		return []mysqltest.Result{rs}
+	})
+
+	tr := new(testTracer)
+	c := fakeConn(srv)
+	c.SetTracer(tr)
+	checkErr(t, c.Connect(), nil)
+	defer c.Close()
+	_, _, err := c.Query("select a")
+	checkErr(t, err, nil)
+	res, err := c.Start("call p")
+	checkErr(t, err, nil)
+	_, err = res.GetRows()
+	checkErr(t, err, nil)
+	_, err = res.NextResult()
+	checkErr(t, err, nil)
+	_, _, err = c.Query("bad")
+	if err == nil {
+		t.Fatal("No error")
+	}
+	stmt, err := c.Prepare("select ?")
+	checkErr(t, err, nil)
+	_, _, err = stmt.Exec(5)
+	checkErr(t, err, nil)
+	checkErr(t, stmt.Delete(), nil)
+	checkErr(t, c.Reconnect(), nil)
+
+	exp := []string{
+		"auth " + user + " <nil>",
+		"connect <nil>",
+		"query select a",
+		"end 2 2 0 <nil>",
+		"query call p",
+		"end 4 1 3 <nil>",
+		"query bad",
+		"end 6 0 0 Received #1064 error from MySQL server: \"syntax\"",
+		"prepare select ? <nil>",
+		"exec select ?",
+		"exec end 1 <nil>",
+		"close select ? <nil>",
+		"auth " + user + " <nil>",
+		"reconnect <nil>",
+	}
+	if strings.Join(tr.events, "\n") != strings.Join(exp, "\n") {
+		t.Fatalf("Bad events:\n%s", strings.Join(tr.events, "\n"))
+	}
+}
+
+func TestFakeErrorState(t *testing.T) {
+	srv := new(mysqltest.Server)
+	startFake(t, srv)
+	defer srv.Close()
+	srv.HandleQuery("insert dup", &mysqltest.Error{
+		Code:  mysql.ER_DUP_ENTRY,
+		State: "23000",
+		Msg:   "Duplicate entry '1' for key 'PRIMARY'",
+	})
+
+	c := fakeConn(srv)
+	checkErr(t, c.Connect(), nil)
+	defer c.Close()
+	_, _, err := c.Query("insert dup")
+	e, ok := err.(*mysql.Error)
+	if !ok || e.Code != mysql.ER_DUP_ENTRY || e.SQLState != "23000" ||
+		string(e.Msg) != "Duplicate entry '1' for key 'PRIMARY'" {
+		t.Fatalf("Bad error: %#v", err)
+	}
+	if !mysql.IsDuplicateKey(err) || !mysql.IsConstraintViolation(err) {
+		t.Fatal("Bad error class")
+	}
+	// The connection is usable after the error
+	srv.HandleQuery("select 1", mysqltest.Rows("1").AddRow(1))
+	_, _, err = c.Query("select 1")
+	checkErr(t, err, nil)
+}
+
+func TestFakeWarnings(t *testing.T) {
+	srv := new(mysqltest.Server)
+	startFake(t, srv)
+	defer srv.Close()
+	srv.HandleQuery("insert trunc", &mysqltest.OK{AffectedRows: 1, Warnings: 1})
+	rs := mysqltest.Rows("a").AddRow("ab")
+	rs.Warnings = 1
+	srv.HandleQuery("select trunc", rs)
+	srv.HandleQuery("select 1", mysqltest.Rows("1").AddRow(1))
+	show := mysqltest.Rows("Level", "Code", "Message").AddRow(
+		"Warning", 1265, "Data truncated for column 'a' at row 1",
+	)
+	show.Warnings = 1 // Mustn't be checked in strict mode
+	srv.HandleQuery("SHOW WARNINGS", show)
+	exp := []mysql.Warning{
+		{
+			Level:   "Warning",
+			Code:    1265,
+			Message: "Data truncated for column 'a' at row 1",
+		},
+	}
+
+	c := fakeConn(srv)
+	tr := new(testTracer)
+	c.SetTracer(tr)
+	checkErr(t, c.Connect(), nil)
+	defer c.Close()
+
+	_, res, err := c.Query("insert trunc")
+	checkErr(t, err, nil)
+	w, err := res.Warnings()
+	checkErr(t, err, nil)
+	if !reflect.DeepEqual(w, exp) {
+		t.Fatalf("Bad warnings: %+v", w)
+	}
+	srv.Reset()
+	if w, _ = res.Warnings(); !reflect.DeepEqual(w, exp) ||
+		len(srv.Queries()) != 0 {
+		t.Fatal("Warnings not cached")
+	}
+
+	// No warnings, no query
+	_, res, err = c.Query("select 1")
+	checkErr(t, err, nil)
+	if w, err = res.Warnings(); w != nil || err != nil ||
+		len(srv.Queries()) != 1 {
+		t.Fatalf("Warnings for no warnings: %v %v", w, err)
+	}
+
+	// Warnings after reading all rows, before the next command
+	r, err := c.Start("select trunc")
+	checkErr(t, err, nil)
+	_, err = r.Warnings()
+	checkErr(t, err, mysql.ErrUnreadedReply)
+	_, err = r.GetRows()
+	checkErr(t, err, nil)
+	_, _, err = c.Query("select 1")
+	checkErr(t, err, nil)
+	_, err = r.Warnings()
+	checkErr(t, err, mysql.ErrWarningsLost)
+
+	// Strict mode
+	c.StrictWarnings(true)
+	_, _, err = c.Query("insert trunc")
+	if e, ok := err.(*mysql.WarningError); !ok ||
+		!reflect.DeepEqual(e.Warnings, exp) {
+		t.Fatalf("Bad strict error: %v", err)
+	}
+	_, _, err = c.Query("select trunc")
+	if _, ok := err.(*mysql.WarningError); !ok {
+		t.Fatalf("Bad strict error: %v", err)
+	}
+	_, _, err = c.Query("select 1")
+	checkErr(t, err, nil)
+	for _, ev := range tr.events {
+		if strings.Contains(ev, "SHOW WARNINGS") {
+			t.Fatal("SHOW WARNINGS was traced")
+		}
+	}
+	if !c.Clone().(*Conn).strict_warnings {
+		t.Fatal("Clone doesn't copy strict mode")
+	}
+}
+
+func TestFakeSessionTrack(t *testing.T) {
+	srv := new(mysqltest.Server)
+	startFake(t, srv)
+	defer srv.Close()
+	changes := []mysql.SessionChange{
+		{Type: mysql.SessionTrackSystemVariables, Name: "autocommit", Value: "OFF"},
+		{Type: mysql.SessionTrackSchema, Value: "db2"},
+		{Type: mysql.SessionTrackStateChange, Value: "1"},
+		{Type: mysql.SessionTrackGTIDs, Value: "3e11fa47-71ca-11e1-9e33-c80aa9429562:23"},
+		{Type: mysql.SessionTrackTransactionState, Value: "T_____W_"},
+	}
+	srv.HandleQuery("insert track",
+		&mysqltest.OK{AffectedRows: 1, Info: "info", Session: changes})
+	srv.HandleQuery("insert info", &mysqltest.OK{AffectedRows: 1, Info: "x"})
+
+	c := fakeConn(srv)
+	checkErr(t, c.Connect(), nil)
+	defer c.Close()
+	if c.caps&_CLIENT_SESSION_TRACK == 0 {
+		t.Fatal("Session tracking not negotiated")
+	}
+	if s := c.CurrentSchema(); s != dbname {
+		t.Fatalf("Bad schema after connect: %q", s)
+	}
+
+	_, res, err := c.Query("insert track")
+	checkErr(t, err, nil)
+	if !reflect.DeepEqual(res.SessionChanges(), changes) {
+		t.Fatalf("Bad session changes: %+v", res.SessionChanges())
+	}
+	if res.Message() != "info" || res.AffectedRows() != 1 {
+		t.Fatalf("Bad result: %q %d", res.Message(), res.AffectedRows())
+	}
+	if c.CurrentSchema() != "db2" || c.LastGTIDs() != changes[3].Value {
+		t.Fatalf("Bad session state: %q %q", c.CurrentSchema(), c.LastGTIDs())
+	}
+
+	// Info without session state, GTIDs are kept
+	_, res, err = c.Query("insert info")
+	checkErr(t, err, nil)
+	if res.SessionChanges() != nil || res.Message() != "x" ||
+		c.LastGTIDs() != changes[3].Value {
+		t.Fatalf("Bad result: %+v %q", res.SessionChanges(), res.Message())
+	}
+
+	checkErr(t, c.Use("db3"), nil)
+	if c.CurrentSchema() != "db3" {
+		t.Fatalf("Bad schema after Use: %q", c.CurrentSchema())
+	}
+}
+
+func TestFakeDeprecateEOF(t *testing.T) {
+	for _, deprecate := range []bool{true, false} {
+		srv := &mysqltest.Server{NoDeprecateEOF: !deprecate}
+		startFake(t, srv)
+		rs := mysqltest.Rows("id", "name").AddRow(1, "a").AddRow(2, nil)
+		rs.Warnings = 1
+		srv.HandleQuery("select id, name from t where id > ?", rs)
+		srv.HandleQuery("select id from t where 0",
+			&mysqltest.ResultSet{Columns: rs.Columns[:1]})
+		srv.HandleQuery("select id from t; select 1", rs,
+			mysqltest.Rows("1").AddRow(1))
+
+		c := fakeConn(srv)
+		checkErr(t, c.Connect(), nil)
+		if (c.caps&_CLIENT_DEPRECATE_EOF != 0) != deprecate {
+			t.Fatalf("CLIENT_DEPRECATE_EOF negotiated: %t", !deprecate)
+		}
+
+		rows, res, err := c.Query("select id, name from t where id > ?")
+		checkErr(t, err, nil)
+		if len(rows) != 2 || rows[1][1] != nil || res.WarnCount() != 1 {
+			t.Fatalf("Bad text result: %v %d", rows, res.WarnCount())
+		}
+		rows, _, err = c.Query("select id from t where 0")
+		checkErr(t, err, nil)
+		if len(rows) != 0 {
+			t.Fatalf("Bad empty result: %v", rows)
+		}
+
+		// Multiple results
+		res, err = c.Start("select id from t; select 1")
+		checkErr(t, err, nil)
+		rows, err = res.GetRows()
+		checkErr(t, err, nil)
+		if len(rows) != 2 || !res.MoreResults() {
+			t.Fatalf("Bad first result: %v", rows)
+		}
+		res, err = res.NextResult()
+		checkErr(t, err, nil)
+		rows, err = res.GetRows()
+		checkErr(t, err, nil)
+		if len(rows) != 1 || rows[0].Int(0) != 1 || res.MoreResults() {
+			t.Fatalf("Bad second result: %v", rows)
+		}
+
+		// Prepared statement with parameters and fields, with and without
+		// a cursor
+		stmt, err := c.Prepare("select id, name from t where id > ?")
+		checkErr(t, err, nil)
+		if stmt.NumParam() != 1 || len(stmt.Fields()) != 2 {
+			t.Fatalf("Bad statement: %d %d", stmt.NumParam(),
+				len(stmt.Fields()))
+		}
+		for _, fetch_size := range []int{0, 1} {
+			stmt.SetFetchSize(fetch_size)
+			rows, res, err = stmt.Exec(0)
+			checkErr(t, err, nil)
+			if len(rows) != 2 || rows[1].Int(0) != 2 || res.WarnCount() != 1 {
+				t.Fatalf("Bad binary result (fetch size %d): %v", fetch_size,
+					rows)
+			}
+		}
+		checkErr(t, c.Ping(), nil)
+		c.Close()
+		srv.Close()
+	}
+}
+
+func TestFakeConnectAttrs(t *testing.T) {
+	srv := new(mysqltest.Server)
+	startFake(t, srv)
+	defer srv.Close()
+
+	c := fakeConn(srv)
+	long := strings.Repeat("x", 300)
+	c.SetConnectAttr("service", "billing")
+	c.SetConnectAttr("long", long)
+	c.SetConnectAttr("_platform", "")
+	checkErr(t, c.Connect(), nil)
+	defer c.Close()
+
+	attrs := srv.ConnectAttrs(c.ThreadId())
+	exp := map[string]string{
+		"_client_name":    "mymysql",
+		"_client_version": mysql.Version(),
+		"service":         "billing",
+		"long":            long,
+	}
+	for k, v := range exp {
+		if attrs[k] != v {
+			t.Fatalf("Bad attribute %s: %q", k, attrs[k])
+		}
+	}
+	if attrs["_pid"] == "" || attrs["_os"] == "" || attrs["program_name"] == "" {
+		t.Fatalf("Missing default attributes: %v", attrs)
+	}
+	if _, ok := attrs["_platform"]; ok {
+		t.Fatal("Removed attribute was sent")
+	}
+
+	// Attributes are sent by COM_CHANGE_USER too
+	checkErr(t, c.ChangeUser(user, passwd, dbname), nil)
+	_, _, err := c.Query("set @a = 1")
+	checkErr(t, err, nil)
+
+	cl := c.Clone().(*Conn)
+	c.SetConnectAttr("service", "other")
+	if cl.conn_attrs["service"] != "billing" {
+		t.Fatal("Clone doesn't copy attributes")
+	}
+}
+
+func TestFakeEd25519Auth(t *testing.T) {
+	for _, plugin := range []string{"client_ed25519", "parsec"} {
+		// Default plugin of the server: the handshake has only 20 bytes
+		// scramble, the nonce is sent in the auth switch request
+		srv := &mysqltest.Server{Plugin: plugin, AuthSwitch: plugin}
+		startFake(t, srv)
+		c := fakeConn(srv)
+		checkErr(t, c.Connect(), nil)
+		checkErr(t, c.Ping(), nil)
+		checkErr(t, c.Close(), nil)
+		srv.Close()
+
+		srv = &mysqltest.Server{
+			AuthSwitch: plugin,
+			Users:      map[string]string{"nopasswd": ""},
+		}
+		startFake(t, srv)
+
+		c = fakeConn(srv)
+		checkErr(t, c.Connect(), nil)
+		checkErr(t, c.Ping(), nil)
+		checkErr(t, c.ChangeUser("nopasswd", "", dbname), nil)
+		checkAccessDenied(t, c.ChangeUser(user, "bad", dbname))
+
+		c = New("tcp", "", srv.Addr(), user, "bad", dbname).(*Conn)
+		checkAccessDenied(t, c.Connect())
+		srv.Close()
+	}
+}
+
+type tokenAuthPlugin struct{ token string }
+
+func (p tokenAuthPlugin) InitialResponse(info *AuthInfo) ([]byte, error) {
+	return append([]byte(p.token), 0), nil
+}
+
+func (tokenAuthPlugin) Continue(info *AuthInfo, data []byte) ([]byte, error) {
+	return nil, mysql.ErrUnkResultPkt
+}
+
+func (tokenAuthPlugin) RequiresSecureTransport() bool { return false }
+
+func TestFakeAuthPlugin(t *testing.T) {
+	srv_cfg, cli_cfg, err := mysqltest.NewTLSConfig()
+	checkErr(t, err, nil)
+	srv := &mysqltest.Server{
+		AuthSwitch: "mysql_clear_password",
+		TLSConfig:  srv_cfg,
+		Users:      map[string]string{"service": "token"},
+	}
+	startFake(t, srv)
+	defer srv.Close()
+
+	// mysql_clear_password requires TLS
+	c := fakeConn(srv)
+	c.SetTLSMode(mysql.TLSDisabled)
+	if err := c.Connect(); err == nil ||
+		!strings.Contains(err.Error(), "secure connection") {
+		t.Fatal("Password sent over insecure connection:", err)
+	}
+	c = fakeConn(srv)
+	c.SetTLSConfig(cli_cfg)
+	checkErr(t, c.Connect(), nil)
+	checkErr(t, c.Ping(), nil)
+	checkErr(t, c.Close(), nil)
+
+	// Custom plugin
+	RegisterAuthPlugin("mysql_clear_password", tokenAuthPlugin{"token"})
+	defer RegisterAuthPlugin("mysql_clear_password", clearPasswordPlugin{})
+	c = New("tcp", "", srv.Addr(), "service", "", dbname).(*Conn)
+	c.SetTLSMode(mysql.TLSDisabled)
+	checkErr(t, c.Connect(), nil)
+	checkErr(t, c.Ping(), nil)
+	checkErr(t, c.Close(), nil)
+}
+
+func TestFakeCredentialProvider(t *testing.T) {
+	srv := &mysqltest.Server{
+		Users: map[string]string{"token1": "secret1", "token2": "secret2"},
+	}
+	startFake(t, srv)
+	defer srv.Close()
+
+	calls := 0
+	c := fakeConn(srv)
+	c.SetCredentialProvider(func() (string, string, error) {
+		calls++
+		if calls > 2 {
+			return "", "", fmt.Errorf("token service unavailable")
+		}
+		n := fmt.Sprint(calls)
+		return "token" + n, "secret" + n, nil
+	})
+	checkErr(t, c.Connect(), nil)
+	if u, _ := c.Credentials(); u != "token1" {
+		t.Fatal("Bad user:", u)
+	}
+	checkErr(t, c.Reconnect(), nil)
+	if u, p := c.Credentials(); u != "token2" || p != "secret2" {
+		t.Fatal("Credentials not refreshed:", u, p)
+	}
+	checkErr(t, c.Ping(), nil)
+
+	err := c.Reconnect()
+	if err == nil || err.Error() != "token service unavailable" {
+		t.Fatal("Provider error not returned:", err)
+	}
+	if c.IsConnected() {
+		t.Fatal("Connected without credentials")
+	}
+	if c.Clone().(*Conn).cred_provider == nil {
+		t.Fatal("Clone doesn't copy the credential provider")
+	}
+}
+
+// checkAccessDenied checks that err is the error sent by the server when the
+// authentication fails.
+func checkAccessDenied(t *testing.T, err error) {
+	e, ok := err.(*mysql.Error)
+	if !ok || e.Code != mysql.ER_ACCESS_DENIED_ERROR || e.SQLState != "28000" {
+		t.Fatalf("Error: %v\nExpected access denied error", err)
+	}
+}
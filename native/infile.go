@@ -0,0 +1,131 @@
+package native
+
+import (
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/ziutek/mymysql/mysql"
+)
+
+// Prefix of file name in LOAD DATA LOCAL INFILE query that selects a reader
+// handler registered by RegisterReaderHandler.
+const ReaderPrefix = "Reader::"
+
+var (
+	infileMutex    sync.RWMutex
+	localFiles     = make(map[string]bool)
+	readerHandlers = make(map[string]func() io.Reader)
+)
+
+// RegisterLocalFile adds path to the list of files that can be sent to the
+// server in response to LOAD DATA LOCAL INFILE query. Any other file
+// requested by the server is denied.
+func RegisterLocalFile(path string) {
+	infileMutex.Lock()
+	localFiles[strings.TrimSpace(path)] = true
+	infileMutex.Unlock()
+}
+
+// DeregisterLocalFile removes path from the list of allowed local files.
+func DeregisterLocalFile(path string) {
+	infileMutex.Lock()
+	delete(localFiles, strings.TrimSpace(path))
+	infileMutex.Unlock()
+}
+
+// RegisterReaderHandler registers handler that returns io.Reader which will be
+// used as data source for LOAD DATA LOCAL INFILE 'Reader::name' query. If the
+// returned reader implements io.Closer it is closed after sending all data.
+func RegisterReaderHandler(name string, handler func() io.Reader) {
+	infileMutex.Lock()
+	readerHandlers[name] = handler
+	infileMutex.Unlock()
+}
+
+// DeregisterReaderHandler removes handler registered by RegisterReaderHandler.
+func DeregisterReaderHandler(name string) {
+	infileMutex.Lock()
+	delete(readerHandlers, name)
+	infileMutex.Unlock()
+}
+
+func openLocalInfile(name string) (io.Reader, error) {
+	infileMutex.RLock()
+	defer infileMutex.RUnlock()
+
+	if strings.HasPrefix(name, ReaderPrefix) {
+		handler := readerHandlers[name[len(ReaderPrefix):]]
+		if handler == nil {
+			return nil, mysql.ClientError(
+				"reader handler isn't registered: " + name,
+			)
+		}
+		rd := handler()
+		if rd == nil {
+			return nil, mysql.ClientError("reader handler returned nil: " + name)
+		}
+		return rd, nil
+	}
+	if !localFiles[name] {
+		return nil, mysql.ClientError("local file isn't registered: " + name)
+	}
+	return os.Open(name)
+}
+
+// Maximum size of the data packet sent by handleLocalInfile
+const infileChunkSize = 64 * 1024
+
+// handleLocalInfile sends the content of the file requested in Load infile
+// response packet (its first byte was readed by getResult). The file is sent
+// in packets not greater than infileChunkSize (and max_pkt_size) and
+// terminated by empty packet. If the file can't be sent only empty packet is
+// sent and the error is returned after the server response is readed.
+func (my *Conn) handleLocalInfile(pr *pktReader) (err error) {
+	name := string(pr.readAll())
+	if my.Debug {
+		log.Printf("[%2d ->] Load infile response packet: \"%s\"", my.seq-1,
+			name)
+	}
+	var rd io.Reader
+	if rd, err = openLocalInfile(name); err == nil {
+		if c, ok := rd.(io.Closer); ok {
+			defer c.Close()
+		}
+		size := infileChunkSize
+		if size > my.max_pkt_size-4 {
+			size = my.max_pkt_size - 4
+		}
+		buf := make([]byte, size)
+		for {
+			var n int
+			n, err = rd.Read(buf)
+			if n > 0 {
+				pw := my.newPktWriter(n)
+				pw.write(buf[:n])
+				if my.Debug {
+					log.Printf("[%2d <-] Local infile data packet: len=%d",
+						my.seq-1, n)
+				}
+			}
+			if err != nil {
+				if err == io.EOF {
+					err = nil
+				}
+				break
+			}
+		}
+	}
+	// Empty packet marks end of data
+	pw := my.newPktWriter(0)
+	pw.writeHeader(0)
+	if e := pw.wr.Flush(); e != nil {
+		panic(e)
+	}
+	if my.Debug {
+		log.Printf("[%2d <-] Local infile end packet", my.seq-1)
+	}
+	return
+}
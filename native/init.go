@@ -1,18 +1,56 @@
 package native
 
 import (
+	"bufio"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha1"
-	"crypto/x509"
-	"encoding/pem"
+	"crypto/tls"
 	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
 
 	"github.com/ziutek/mymysql/mysql"
 )
 
+// defaultConnectAttrs returns the connection attributes set by New.
+func defaultConnectAttrs() map[string]string {
+	attrs := map[string]string{
+		"_client_name":    "mymysql",
+		"_client_version": mysql.Version(),
+		"_os":             runtime.GOOS,
+		"_platform":       runtime.GOARCH,
+		"_pid":            strconv.Itoa(os.Getpid()),
+	}
+	if len(os.Args) > 0 {
+		attrs["program_name"] = filepath.Base(os.Args[0])
+	}
+	return attrs
+}
+
+// encodeConnectAttrs returns the connection attributes encoded for the
+// handshake response and COM_CHANGE_USER.
+func (my *Conn) encodeConnectAttrs() []byte {
+	keys := make([]string, 0, len(my.conn_attrs))
+	for k := range my.conn_attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var kv []byte
+	for _, k := range keys {
+		v := my.conn_attrs[k]
+		kv = append(appendLengthEncodedInteger(kv, uint64(len(k))), k...)
+		kv = append(appendLengthEncodedInteger(kv, uint64(len(v))), v...)
+	}
+	return append(appendLengthEncodedInteger(nil, uint64(len(kv))), kv...)
+}
+
 func (my *Conn) init() {
-	my.seq = 0 // Reset sequence number, mainly for reconnect
+	my.resetSeq() // Reset sequence number, mainly for reconnect
 	if my.Debug {
 		log.Printf("[%2d ->] Init packet:", my.seq)
 	}
@@ -61,20 +99,40 @@ func (my *Conn) auth() {
 			_CLIENT_MULTI_RESULTS)
 	// Reset flags not supported by server
 	flags &= uint32(my.info.caps) | 0xffff0000
-	if my.plugin != string(my.info.plugin) {
-		my.plugin = string(my.info.plugin)
+	if my.info.caps&_CLIENT_SESSION_TRACK != 0 {
+		flags |= _CLIENT_SESSION_TRACK
+	}
+	if my.info.caps&_CLIENT_DEPRECATE_EOF != 0 {
+		flags |= _CLIENT_DEPRECATE_EOF
 	}
-	var scrPasswd []byte
-	switch my.plugin {
-	case "caching_sha2_password":
+	if my.info.caps&_CLIENT_PLUGIN_AUTH != 0 {
+		// Plugin name is always sent
 		flags |= _CLIENT_PLUGIN_AUTH
-		scrPasswd = encryptedSHA256Passwd(my.passwd, my.info.scramble[:])
-	case "mysql_old_password":
-		my.oldPasswd()
-		return
-	default:
-		// mysql_native_password by default
-		scrPasswd = encryptedPasswd(my.passwd, my.info.scramble[:])
+	}
+	if my.info.caps&_CLIENT_CONNECT_ATTRS != 0 && len(my.conn_attrs) != 0 {
+		flags |= _CLIENT_CONNECT_ATTRS
+	}
+	my.caps = flags
+	if my.tls_mode != mysql.TLSDisabled {
+		if my.info.caps&_CLIENT_SSL != 0 {
+			flags |= _CLIENT_SSL
+			my.startTLS(flags)
+		} else if my.tls_mode != mysql.TLSPreferred {
+			panic(mysql.ErrNoTLS)
+		}
+	}
+	compress := my.compressFlag()
+	flags |= compress
+	my.plugin = string(my.info.plugin)
+	if getAuthPlugin(my.plugin) == nil {
+		// Server will send the auth switch request if needed
+		my.plugin = "mysql_native_password"
+	}
+	scrPasswd, err := my.authPlugin().InitialResponse(
+		my.authInfo(my.info.scramble[:]),
+	)
+	if err != nil {
+		panic(err)
 	}
 
 	// encode length of the auth plugin data
@@ -86,12 +144,20 @@ func (my *Conn) auth() {
 		flags |= _CLIENT_PLUGIN_AUTH_LENENC_CLIENT_DATA
 	}
 
-	pay_len := 4 + 4 + 1 + 23 + len(my.user) + 1 + len(authRespLEI) + len(scrPasswd) + 21 + 1
+	pay_len := 4 + 4 + 1 + 23 + len(my.user) + 1 + len(authRespLEI) + len(scrPasswd) + len(my.plugin) + 1
+	if compress == _CLIENT_ZSTD_COMPRESSION_ALGORITHM {
+		pay_len++
+	}
 
 	if len(my.dbname) > 0 {
 		pay_len += len(my.dbname) + 1
 		flags |= _CLIENT_CONNECT_WITH_DB
 	}
+	var attrs []byte
+	if flags&_CLIENT_CONNECT_ATTRS != 0 {
+		attrs = my.encodeConnectAttrs()
+		pay_len += len(attrs)
+	}
 	pw := my.newPktWriter(pay_len)
 	pw.writeU32(flags)
 	pw.writeU32(uint32(my.max_pkt_size))
@@ -106,39 +172,79 @@ func (my *Conn) auth() {
 	}
 
 	// write plugin name
-	if my.plugin != "" {
-		pw.writeNTB([]byte(my.plugin))
-	} else {
-		pw.writeNTB([]byte("mysql_native_password"))
+	pw.writeNTB([]byte(my.plugin))
+
+	// write connection attributes
+	pw.write(attrs)
+
+	if compress == _CLIENT_ZSTD_COMPRESSION_ALGORITHM {
+		level := my.compress_level
+		if level == 0 {
+			level = 3 // zstd default level
+		}
+		pw.writeByte(byte(level))
 	}
 	return
 }
 
+// startTLS sends SSLRequest packet and switches the connection to TLS.
+func (my *Conn) startTLS(flags uint32) {
+	if my.Debug {
+		log.Printf("[%2d <-] SSL request packet", my.seq)
+	}
+	pw := my.newPktWriter(4 + 4 + 1 + 23)
+	pw.writeU32(flags)
+	pw.writeU32(uint32(my.max_pkt_size))
+	pw.writeByte(my.info.lang) // Charset number
+	pw.writeZeros(23)          // Filler
+
+	var cfg *tls.Config
+	if my.tls_config != nil {
+		// The configuration of the user decides how the certificate is
+		// verified
+		cfg = my.tls_config.Clone()
+	} else {
+		cfg = new(tls.Config)
+		cfg.InsecureSkipVerify = my.tls_mode != mysql.TLSVerifyIdentity
+	}
+	if cfg.ServerName == "" && !cfg.InsecureSkipVerify {
+		host, _, err := net.SplitHostPort(my.raddr)
+		if err != nil {
+			host = my.raddr
+		}
+		cfg.ServerName = host
+	}
+	tc := tls.Client(my.net_conn, cfg)
+	if err := tc.Handshake(); err != nil {
+		panic(err)
+	}
+	my.net_conn = tc
+	my.rd = bufio.NewReader(my.net_conn)
+	my.wr = bufio.NewWriter(my.net_conn)
+	if my.Debug {
+		log.Printf(tab8s+"TLS handshake done, ServerName=\"%s\"", cfg.ServerName)
+	}
+}
+
 func (my *Conn) authResponse() {
+	scramble := my.info.scramble[:]
+	plugin := my.authPlugin()
+
 	// Read Result Packet
 	authData, newPlugin := my.getAuthResult()
 
 	// handle auth plugin switch, if requested
 	if newPlugin != "" {
-		var scrPasswd []byte
-		if len(authData) >= 20 {
+		if len(authData) != 0 {
 			// old_password's len(authData) == 0
-			copy(my.info.scramble[:], authData[:20])
+			scramble = authData
 		}
 		my.info.plugin = []byte(newPlugin)
 		my.plugin = newPlugin
-		switch my.plugin {
-		case "caching_sha2_password":
-			scrPasswd = encryptedSHA256Passwd(my.passwd, my.info.scramble[:])
-		case "mysql_old_password":
-			scrPasswd = encryptedOldPassword(my.passwd, my.info.scramble[:])
-			// append \0 after old_password
-			scrPasswd = append(scrPasswd, 0)
-		case "sha256_password":
-			// request public key from server
-			scrPasswd = []byte{1}
-		default: // mysql_native_password
-			scrPasswd = encryptedPasswd(my.passwd, my.info.scramble[:])
+		plugin = my.authPlugin()
+		scrPasswd, err := plugin.InitialResponse(my.authInfo(scramble))
+		if err != nil {
+			panic(err)
 		}
 		my.writeAuthSwitchPacket(scrPasswd)
 
@@ -147,78 +253,42 @@ func (my *Conn) authResponse() {
 
 		// Do not allow to change the auth plugin more than once
 		if newPlugin != "" {
-			return
+			panic(mysql.ErrAuthentication)
 		}
 	}
 
-	switch my.plugin {
-
-	// https://insidemysql.com/preparing-your-community-connector-for-mysql-8-part-2-sha256/
-	case "caching_sha2_password":
-		switch len(authData) {
-		case 0:
-			return // auth successful
-		case 1:
-			switch authData[0] {
-			case 3: // cachingSha2PasswordFastAuthSuccess
-				my.getResult(nil, nil)
-
-			case 4: // cachingSha2PasswordPerformFullAuthentication
-				// request public key from server
-				pw := my.newPktWriter(1)
-				pw.writeByte(2)
-
-				// parse public key
-				pr := my.newPktReader()
-				pr.skipN(1)
-				data := pr.readAll()
-				block, _ := pem.Decode(data)
-				pkix, err := x509.ParsePKIXPublicKey(block.Bytes)
-				if err != nil {
-					panic(mysql.ErrAuthentication)
-				}
-				pubKey := pkix.(*rsa.PublicKey)
-
-				// send encrypted password
-				my.sendEncryptedPassword(my.info.scramble[:], pubKey)
-				my.getResult(nil, nil)
-			}
+	// Exchange plugin data until the server sends OK
+	info := my.authInfo(scramble)
+	for authData != nil {
+		resp, err := plugin.Continue(info, authData)
+		if err != nil {
+			panic(err)
+		}
+		if resp != nil {
+			my.writeAuthSwitchPacket(resp)
 		}
-	case "sha256_password":
-		switch len(authData) {
-		case 0:
-			return // auth successful
-		default:
-			// parse public key
-			block, _ := pem.Decode(authData)
-			pub, err := x509.ParsePKIXPublicKey(block.Bytes)
-			if err != nil {
-				panic(mysql.ErrAuthentication)
-			}
-
-			// send encrypted password
-			my.sendEncryptedPassword(my.info.scramble[:], pub.(*rsa.PublicKey))
-			my.getResult(nil, nil)
+		authData, newPlugin = my.getAuthResult()
+		if newPlugin != "" {
+			panic(mysql.ErrAuthentication)
 		}
 	}
-	return
 }
 
 // http://dev.mysql.com/doc/internals/en/connection-phase-packets.html#packet-Protocol::AuthSwitchResponse
 func (my *Conn) writeAuthSwitchPacket(scrPasswd []byte) {
 	pw := my.newPktWriter(len(scrPasswd))
+	if len(scrPasswd) == 0 {
+		// pw.write doesn't write anything for empty data
+		pw.writeHeader(0)
+		if err := pw.wr.Flush(); err != nil {
+			panic(err)
+		}
+		return
+	}
 	pw.write(scrPasswd) // Encrypted password
 	return
 }
 
-func (my *Conn) sendEncryptedPassword(seed []byte, pub *rsa.PublicKey) {
-	enc, err := encryptPassword(my.passwd, seed, pub)
-	if err != nil {
-		panic(mysql.ErrAuthentication)
-	}
-	my.writeAuthSwitchPacket(enc)
-}
-
 func encryptPassword(password string, seed []byte, pub *rsa.PublicKey) ([]byte, error) {
 	plain := make([]byte, len(password)+1)
 	copy(plain, password)
@@ -229,13 +299,3 @@ func encryptPassword(password string, seed []byte, pub *rsa.PublicKey) ([]byte,
 	sha1 := sha1.New()
 	return rsa.EncryptOAEP(sha1, rand.Reader, pub, plain, nil)
 }
-
-func (my *Conn) oldPasswd() {
-	if my.Debug {
-		log.Printf("[%2d <-] Password packet", my.seq)
-	}
-	scrPasswd := encryptedOldPassword(my.passwd, my.info.scramble[:])
-	pw := my.newPktWriter(len(scrPasswd) + 1)
-	pw.write(scrPasswd)
-	pw.writeByte(0)
-}
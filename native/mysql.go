@@ -3,6 +3,8 @@ package native
 
 import (
 	"bufio"
+	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"net"
@@ -39,6 +41,7 @@ type Conn struct {
 	wr       *bufio.Writer
 
 	info serverInfo // MySQL server information
+	caps uint32     // Client capabilities sent to the server
 	seq  byte       // MySQL sequence number
 
 	unreaded_reply bool
@@ -49,6 +52,10 @@ type Conn struct {
 	// Current status of MySQL server connection
 	status mysql.ConnStatus
 
+	// Session state tracked by the server (see CurrentSchema, LastGTIDs)
+	schema string
+	gtids  string
+
 	// Maximum packet size that client can accept from server.
 	// Default 16*1024*1024-1. You may change it before connect.
 	max_pkt_size int
@@ -56,14 +63,48 @@ type Conn struct {
 	// Timeout for connect
 	timeout time.Duration
 
+	// Timeout for query cancellation (see StartContext)
+	cancel_timeout time.Duration
+
 	dialer mysql.Dialer
 
+	// Returns credentials for every connection attempt
+	cred_provider mysql.CredentialProvider
+
+	// TLS configuration and mode
+	tls_config *tls.Config
+	tls_mode   mysql.TLSMode
+
+	// Compression algorithm and level
+	compress       string
+	compress_level int
+	cio            *compressIO // nil if compression isn't used
+
 	// Return only types accepted by godrv
 	narrowTypeSet bool
+	// How to return DECIMAL values
+	decimal_mode mysql.DecimalMode
+	// Replace ? placeholders in Start instead of using fmt.Sprintf
+	interpolate bool
 	// Store full information about fields in result
 	fullFieldInfo bool
+	// Return warnings as errors (see StrictWarnings)
+	strict_warnings bool
 
-	// Debug logging. You may change it at any time.
+	// Connection attributes sent in the handshake (see SetConnectAttr)
+	conn_attrs map[string]string
+
+	// Number of commands sent, used to detect cleared warnings
+	cmd_count uint32
+
+	// Tracing (see SetTracer)
+	tracer    mysql.Tracer
+	metrics   mysql.Metrics
+	trace_ev  *mysql.QueryEvent // Query or execution in progress
+	trace_ctx context.Context   // Context of StartContext or RunContext
+
+	// Debug logging of all packets. You may change it at any time. Use
+	// SetTracer for structured logging of connection events.
 	Debug bool
 }
 
@@ -72,16 +113,18 @@ type Conn struct {
 // is database name (you may not specify it and use Use() method later).
 func New(proto, laddr, raddr, user, passwd string, args ...string) mysql.Conn {
 	my := Conn{
-		proto:         proto,
-		laddr:         laddr,
-		raddr:         raddr,
-		plugin:        "mysql_native_password",
-		user:          user,
-		passwd:        passwd,
-		stmt_map:      make(map[uint32]*Stmt),
-		max_pkt_size:  16*1024*1024 - 1,
-		timeout:       2 * time.Minute,
-		fullFieldInfo: true,
+		proto:          proto,
+		laddr:          laddr,
+		raddr:          raddr,
+		plugin:         "mysql_native_password",
+		user:           user,
+		passwd:         passwd,
+		stmt_map:       make(map[uint32]*Stmt),
+		max_pkt_size:   16*1024*1024 - 1,
+		timeout:        2 * time.Minute,
+		cancel_timeout: 10 * time.Second,
+		fullFieldInfo:  true,
+		conn_attrs:     defaultConnectAttrs(),
 	}
 	if len(args) == 1 {
 		my.dbname = args[0]
@@ -102,10 +145,47 @@ func (my *Conn) NarrowTypeSet(narrow bool) {
 	my.narrowTypeSet = narrow
 }
 
+// SetDecimalMode sets the type of DECIMAL values returned by prepared
+// statements: float64 (mysql.DecimalFloat, default) or mysql.Decimal
+// (mysql.DecimalExact). Text queries always return DECIMAL as []byte.
+func (my *Conn) SetDecimalMode(mode mysql.DecimalMode) {
+	my.decimal_mode = mode
+}
+
+// InterpolateParams changes the meaning of the Start (and Query*) parameters.
+// If interpolate is true the parameters replace the ? placeholders in SQL
+// (see mysql.Interpolate) instead of being passed to fmt.Sprintf.
+func (my *Conn) InterpolateParams(interpolate bool) {
+	my.interpolate = interpolate
+}
+
 func (my *Conn) FullFieldInfo(full bool) {
 	my.fullFieldInfo = full
 }
 
+// SetConnectAttr sets the connection attribute sent to the server in the
+// handshake (and by ChangeUser) if the server supports them (MySQL 5.6+).
+// Attributes can be read from performance_schema.session_connect_attrs. By
+// default _client_name, _client_version, _os, _platform, _pid and
+// program_name are set. Empty value removes the attribute. Changes take
+// effect on the next Connect or Reconnect. Clone copies the attributes.
+func (my *Conn) SetConnectAttr(key, value string) {
+	if value == "" {
+		delete(my.conn_attrs, key)
+	} else {
+		my.conn_attrs[key] = value
+	}
+}
+
+// StrictWarnings enables the strict warnings mode. In this mode a query or an
+// execution that generated warnings returns *mysql.WarningError (with the
+// warnings read using SHOW WARNINGS) after the whole response was read: from
+// Start (Run) for a status result or from ScanRow (NextResult) instead of the
+// end of the last result. Clone copies the mode.
+func (my *Conn) StrictWarnings(strict bool) {
+	my.strict_warnings = strict
+}
+
 // Clone: Creates new (not connected) connection using configuration from current
 // connection.
 func (my *Conn) Clone() mysql.Conn {
@@ -117,6 +197,22 @@ func (my *Conn) Clone() mysql.Conn {
 	}
 	c.max_pkt_size = my.max_pkt_size
 	c.timeout = my.timeout
+	c.cancel_timeout = my.cancel_timeout
+	c.dialer = my.dialer
+	c.cred_provider = my.cred_provider
+	c.tls_config = my.tls_config
+	c.tls_mode = my.tls_mode
+	c.compress = my.compress
+	c.compress_level = my.compress_level
+	c.decimal_mode = my.decimal_mode
+	c.interpolate = my.interpolate
+	c.strict_warnings = my.strict_warnings
+	c.conn_attrs = make(map[string]string, len(my.conn_attrs))
+	for k, v := range my.conn_attrs {
+		c.conn_attrs[k] = v
+	}
+	c.tracer = my.tracer
+	c.metrics = my.metrics
 	c.Debug = my.Debug
 	return c
 }
@@ -130,6 +226,12 @@ func (my *Conn) SetMaxPktSize(new_size int) int {
 	return old_size
 }
 
+// SetRemoteAddr changes the server address used by the next Connect or
+// Reconnect.
+func (my *Conn) SetRemoteAddr(raddr string) {
+	my.raddr = raddr
+}
+
 // SetTimeout sets timeout for Connect and Reconnect
 func (my *Conn) SetTimeout(timeout time.Duration) {
 	my.timeout = timeout
@@ -186,10 +288,69 @@ func (my *Conn) SetDialer(d mysql.Dialer) {
 	my.dialer = d
 }
 
+// SetCredentialProvider sets the function that returns the user name and
+// password for Connect and Reconnect (and for COM_CHANGE_USER used by
+// ResetSession with old servers). It replaces credentials passed to New or
+// ChangeUser. If it returns an error the connection attempt fails with this
+// error. Clone copies the provider.
+func (my *Conn) SetCredentialProvider(p mysql.CredentialProvider) {
+	my.cred_provider = p
+}
+
+// refreshCredentials obtains new credentials from the credential provider.
+func (my *Conn) refreshCredentials() error {
+	if my.cred_provider == nil {
+		return nil
+	}
+	user, passwd, err := my.cred_provider()
+	if err != nil {
+		return err
+	}
+	my.user, my.passwd = user, passwd
+	return nil
+}
+
+// SetTLSConfig sets TLS configuration used for Connect and Reconnect. If cfg
+// isn't nil and TLS is disabled it also sets mysql.TLSVerifyIdentity mode.
+// If cfg is nil the default configuration is used and the TLS mode isn't
+// changed (use SetTLSMode(mysql.TLSDisabled) to disable TLS). If
+// cfg.ServerName is empty it is set to the host part of the server address.
+func (my *Conn) SetTLSConfig(cfg *tls.Config) {
+	my.tls_config = cfg
+	if cfg != nil && my.tls_mode == mysql.TLSDisabled {
+		my.tls_mode = mysql.TLSVerifyIdentity
+	}
+}
+
+// SetTLSMode sets TLS mode used for Connect and Reconnect. If TLS
+// configuration wasn't set by SetTLSConfig the default one is used (it
+// verifies the certificate only in mysql.TLSVerifyIdentity mode). The
+// configuration set by SetTLSConfig is used as is in all modes, so the
+// certificate is verified unless cfg.InsecureSkipVerify is true.
+func (my *Conn) SetTLSMode(mode mysql.TLSMode) {
+	my.tls_mode = mode
+}
+
 func (my *Conn) connect() (err error) {
+	in_auth := false
+	defer func() {
+		if in_auth {
+			my.traceAuth(err)
+		}
+		if err != nil && my.net_conn != nil {
+			// Handshake failed
+			my.net_conn.Close()
+			my.net_conn = nil
+		}
+	}()
 	defer catchError(&err)
 
 	my.net_conn = nil
+	my.cio = nil
+	my.trace_ev = nil
+	if err = my.refreshCredentials(); err != nil {
+		return
+	}
 	if my.dialer != nil {
 		my.net_conn, err = my.dialer(my.proto, my.laddr, my.raddr, my.timeout)
 		if err != nil {
@@ -204,15 +365,29 @@ func (my *Conn) connect() (err error) {
 			return
 		}
 	}
+	if my.metrics != nil {
+		my.net_conn = meteredConn{my.net_conn, my.metrics}
+	}
 	my.rd = bufio.NewReader(my.net_conn)
 	my.wr = bufio.NewWriter(my.net_conn)
 
 	// Initialisation
 	my.init()
+	in_auth = true
 	my.auth()
 	my.authResponse()
+	in_auth = false
+	my.traceAuth(nil)
+	my.schema = my.dbname
+	if my.compressFlag() != 0 {
+		my.startCompression()
+	}
+	return my.runInitCmds()
+}
 
-	// Execute all registered commands
+// runInitCmds executes all registered commands. It must be called from a
+// function that recovers panics using catchError.
+func (my *Conn) runInitCmds() (err error) {
 	for _, cmd := range my.init_cmds {
 		// Send command
 		my.sendCmdStr(_COM_QUERY, cmd)
@@ -250,8 +425,10 @@ func (my *Conn) Connect() (err error) {
 	if my.net_conn != nil {
 		return mysql.ErrAlredyConn
 	}
-
-	return my.connect()
+	start := time.Now()
+	err = my.connect()
+	my.traceConnect(false, start, err)
+	return
 }
 
 // IsConnected checks if connection is established
@@ -259,6 +436,12 @@ func (my *Conn) IsConnected() bool {
 	return my.net_conn != nil
 }
 
+// PendingReply reports whether the reply to the last command wasn't read
+// completely (other commands return mysql.ErrUnreadedReply until it is read).
+func (my *Conn) PendingReply() bool {
+	return my.unreaded_reply
+}
+
 func (my *Conn) closeConn() (err error) {
 	defer catchError(&err)
 
@@ -289,6 +472,8 @@ func (my *Conn) Close() (err error) {
 // Reconnect: Close and reopen connection.
 // Ignore unreaded rows, reprepare all prepared statements.
 func (my *Conn) Reconnect() (err error) {
+	start := time.Now()
+	defer func() { my.traceConnect(true, start, err) }()
 	if my.net_conn != nil {
 		// Close connection, ignore all errors
 		my.closeConn()
@@ -297,8 +482,12 @@ func (my *Conn) Reconnect() (err error) {
 	if err = my.connect(); err != nil {
 		return
 	}
+	return my.reprepare()
+}
 
-	// Reprepare all prepared statements
+// reprepare prepares all statements again after the server closed them
+// (Reconnect, ResetSession, ChangeUser). Stmt handlers remain valid.
+func (my *Conn) reprepare() (err error) {
 	var (
 		new_stmt *Stmt
 		new_map  = make(map[uint32]*Stmt)
@@ -312,6 +501,7 @@ func (my *Conn) Reconnect() (err error) {
 		// corresponding fields in stmt. Why can they be different?
 		stmt.id = new_stmt.id
 		stmt.rebind = true
+		stmt.dropCursor()
 		new_map[stmt.id] = stmt
 	}
 	// Replace the stmt_map
@@ -337,6 +527,7 @@ func (my *Conn) Use(dbname string) (err error) {
 	my.getResult(nil, nil)
 	// Save new database name if no errors
 	my.dbname = dbname
+	my.schema = dbname
 
 	return
 }
@@ -347,17 +538,20 @@ func (my *Conn) getResponse() (res *Result) {
 		panic(mysql.ErrBadResult)
 	}
 	my.unreaded_reply = !res.StatusOnly()
+	res.cmd_num = my.cmd_count
+	if my.trace_ev != nil && res.StatusOnly() {
+		my.trace_ev.AffectedRows += res.affected_rows
+	}
 	return
 }
 
 // Start new query.
 //
 // If you specify the parameters, the SQL string will be a result of
-// fmt.Sprintf(sql, params...).
+// fmt.Sprintf(sql, params...) or, if InterpolateParams(true) was called,
+// mysql.Interpolate(my, sql, params...).
 // You must get all result rows (if they exists) before next query.
 func (my *Conn) Start(sql string, params ...interface{}) (res mysql.Result, err error) {
-	defer catchError(&err)
-
 	if my.net_conn == nil {
 		return nil, mysql.ErrNotConn
 	}
@@ -365,9 +559,26 @@ func (my *Conn) Start(sql string, params ...interface{}) (res mysql.Result, err
 		return nil, mysql.ErrUnreadedReply
 	}
 
-	if len(params) != 0 {
+	if my.interpolate {
+		if sql, err = mysql.Interpolate(my, sql, params...); err != nil {
+			return
+		}
+	} else if len(params) != 0 {
 		sql = fmt.Sprintf(sql, params...)
 	}
+	my.traceStart(sql, 0)
+	res, err = my.query(sql)
+	my.traceResult(err)
+	if err == nil {
+		err = res.(*Result).checkLastWarnings()
+	}
+	return
+}
+
+// query sends the query and reads the response.
+func (my *Conn) query(sql string) (res mysql.Result, err error) {
+	defer catchError(&err)
+
 	// Send query
 	my.sendCmdStr(_COM_QUERY, sql)
 
@@ -406,12 +617,30 @@ func (res *Result) ScanRow(row mysql.Row) error {
 		res.eor_returned = true
 		return io.EOF
 	}
+	if res.cursor != nil {
+		err := res.scanCursorRow(row)
+		if err == io.EOF {
+			res.eor_returned = true
+		}
+		return err
+	}
 	err := res.getRow(row)
-	if err == io.EOF {
+	switch {
+	case err == nil:
+		if res.my.trace_ev != nil {
+			res.my.trace_ev.Rows++
+		}
+	case err == io.EOF:
 		res.eor_returned = true
 		if !res.MoreResults() {
 			res.my.unreaded_reply = false
+			res.my.traceEnd(nil)
+			if e := res.checkWarnings(); e != nil {
+				err = e
+			}
 		}
+	default:
+		res.my.traceEnd(err)
 	}
 	return err
 }
@@ -423,6 +652,7 @@ func (res *Result) GetRow() (mysql.Row, error) {
 }
 
 func (res *Result) nextResult() (next *Result, err error) {
+	defer res.my.traceNext(&err)
 	defer catchError(&err)
 	if res.MoreResults() {
 		next = res.my.getResponse()
@@ -440,8 +670,11 @@ func (res *Result) NextResult() (mysql.Result, error) {
 	if !res.MoreResults() {
 		return nil, nil
 	}
-	res, err := res.nextResult()
-	return res, err
+	next, err := res.nextResult()
+	if err == nil {
+		err = next.checkLastWarnings()
+	}
+	return next, err
 }
 
 // Ping: Send MySQL PING to the server.
@@ -493,7 +726,15 @@ func (my *Conn) Prepare(sql string) (mysql.Stmt, error) {
 		return nil, mysql.ErrUnreadedReply
 	}
 
+	start := time.Now()
 	stmt, err := my.prepare(sql)
+	if my.tracer != nil || my.metrics != nil {
+		var id uint32
+		if err == nil {
+			id = stmt.id
+		}
+		my.traceStmt(false, id, sql, start, err)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -592,11 +833,31 @@ func (stmt *Stmt) Run(params ...interface{}) (res mysql.Result, err error) {
 		panic(mysql.ErrBindCount)
 	}
 
+	// Execution closes the cursor opened by previous Run
+	stmt.dropCursor()
+	my := stmt.my
+	my.traceStart(stmt.sql, stmt.id)
+	res, err = stmt.exec()
+	my.traceResult(err)
+	if err == nil {
+		err = res.(*Result).checkLastWarnings()
+	}
+	return
+}
+
+// exec sends the execute command with binded parameters and reads the
+// response.
+func (stmt *Stmt) exec() (res mysql.Result, err error) {
+	defer catchError(&err)
+
 	// Send EXEC command with binded parameters
 	stmt.sendCmdExec()
 	// Get response
 	r := stmt.my.getResponse()
 	r.binary = true
+	if !r.StatusOnly() && stmt.cursorOpened(r) {
+		stmt.openCursor(r)
+	}
 	res = r
 	return
 }
@@ -613,13 +874,16 @@ func (stmt *Stmt) Delete() (err error) {
 		return mysql.ErrUnreadedReply
 	}
 
+	stmt.dropCursor()
 	// Allways delete statement on client side, even if
 	// the command return an error.
+	my, id, sql, start := stmt.my, stmt.id, stmt.sql, time.Now()
 	defer func() {
 		// Delete statement from stmt_map
-		delete(stmt.my.stmt_map, stmt.id)
+		delete(my.stmt_map, id)
 		// Invalidate handler
 		*stmt = Stmt{}
+		my.traceStmt(true, id, sql, start, err)
 	}()
 
 	// Send command
@@ -642,6 +906,7 @@ func (stmt *Stmt) Reset() (err error) {
 	// Next exec must send type information. We set rebind flag regardless of
 	// whether the command succeeds or not.
 	stmt.rebind = true
+	stmt.dropCursor()
 	// Send command
 	stmt.my.sendCmdU32(_COM_STMT_RESET, stmt.id)
 	// Get result
@@ -765,8 +1030,12 @@ func (stmt *Stmt) ExecLast(params ...interface{}) (mysql.Row, mysql.Result, erro
 	return mysql.ExecLast(stmt, params...)
 }
 
-// End: See mysql.End
+// End: See mysql.End. If the result is read from a cursor, End closes the
+// cursor without fetching the remaining rows.
 func (res *Result) End() error {
+	if res.cursor != nil && !res.eor_returned {
+		return res.endCursor()
+	}
 	return mysql.End(res)
 }
 
@@ -2,11 +2,14 @@ package native
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"github.com/ziutek/mymysql/mysql"
+	"io"
 	"io/ioutil"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -64,7 +67,17 @@ func checkWarnCount(t *testing.T, res_cnt, exp_cnt int) {
 
 func checkErrWarn(t *testing.T, res, exp *RowsResErr) {
 	checkErr(t, res.err, exp.err)
-	checkWarnCount(t, res.res.WarnCount(), exp.res.WarnCount())
+	if res_cnt, exp_cnt := res.res.WarnCount(), exp.res.WarnCount(); res_cnt != exp_cnt {
+		t.Errorf("Warning count: res=%d exp=%d", res_cnt, exp_cnt)
+		warnings, err := res.res.Warnings()
+		if err != nil {
+			t.Fatal("Can't get warrnings from MySQL", err)
+		}
+		for _, w := range warnings {
+			t.Error(w)
+		}
+		t.FailNow()
+	}
 }
 
 func types(row mysql.Row) (tt []reflect.Type) {
@@ -167,6 +180,47 @@ func myClose(t *testing.T) {
 	checkErr(t, my.Close(), nil)
 }
 
+func TestTLSPreferred(t *testing.T) {
+	my = New(conn[0], conn[1], conn[2], user, passwd)
+	my.SetTLSMode(mysql.TLSPreferred)
+	my.(*Conn).Debug = debug
+	checkErr(t, my.Connect(), nil)
+	checkErr(t, my.Ping(), nil)
+	checkErr(t, my.Reconnect(), nil)
+	checkErr(t, my.Ping(), nil)
+	myClose(t)
+}
+
+func TestCompression(t *testing.T) {
+	my = New(conn[0], conn[1], conn[2], user, passwd, dbname)
+	my.SetCompression("zlib", 0)
+	my.(*Conn).Debug = debug
+	checkErr(t, my.Connect(), nil)
+	if my.(*Conn).cio == nil {
+		t.Fatal("Compression wasn't negotiated")
+	}
+
+	row, _, err := my.QueryFirst("select repeat('a', 100000)")
+	checkErr(t, err, nil)
+	if row.Str(0) != strings.Repeat("a", 100000) {
+		t.Fatal("Bad text result")
+	}
+
+	sel, err := my.Prepare("select concat(?, ?)")
+	checkErr(t, err, nil)
+	sel.Bind("", 1)
+	checkErr(t, sel.SendLongData(0, strings.Repeat("b", 50000), 8192), nil)
+	row, _, err = sel.ExecFirst()
+	checkErr(t, err, nil)
+	if row.Str(0) != strings.Repeat("b", 50000)+"1" {
+		t.Fatal("Bad binary result")
+	}
+
+	checkErr(t, my.Reconnect(), nil)
+	checkErr(t, my.Ping(), nil)
+	myClose(t)
+}
+
 // Text queries tests
 
 func TestUse(t *testing.T) {
@@ -460,7 +514,7 @@ func TestVarBinding(t *testing.T) {
 
 	rows, _, err = sel.Exec(2)
 	checkErr(t, err, nil)
-	if len(rows) != 1 || i!bytes.Equal([]byte(s2), rows[0].Bin(0)) {
+	if len(rows) != 1 || !bytes.Equal([]byte(s2), rows[0].Bin(0)) {
 		t.Fatal("Second string don't match")
 	}
 
@@ -789,6 +843,70 @@ func TestReconnect(t *testing.T) {
 	myClose(t)
 }
 
+// Query cancellation test
+
+func TestStartContext(t *testing.T) {
+	myConnect(t, true, 0)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := my.StartContext(ctx, "select sleep(10)")
+	checkErr(t, err, context.DeadlineExceeded)
+	checkErr(t, my.Ping(), nil)
+
+	sel, err := my.Prepare("select sleep(?)")
+	checkErr(t, err, nil)
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = sel.RunContext(ctx, 10)
+	checkErr(t, err, context.DeadlineExceeded)
+	checkErr(t, my.Ping(), nil)
+
+	res, err := my.StartContext(context.Background(), "select 1")
+	checkErr(t, err, nil)
+	checkErr(t, res.End(), nil)
+
+	myClose(t)
+}
+
+// LOAD DATA LOCAL INFILE test
+
+func TestLoadDataLocalInfile(t *testing.T) {
+	myConnect(t, true, 0)
+	query("drop table li") // Drop test table if exists
+	checkResult(t,
+		query("create table li (id int primary key, str varchar(20))"),
+		cmdOK(0, false, true),
+	)
+
+	RegisterReaderHandler("li", func() io.Reader {
+		return strings.NewReader("1\tjeden\n2\tdwa\n3\ttrzy\n")
+	})
+	defer DeregisterReaderHandler("li")
+
+	_, res, err := my.Query("load data local infile 'Reader::li' into table li")
+	checkErr(t, err, nil)
+	if res.AffectedRows() != 3 {
+		t.Fatalf("AffectedRows: res=%d exp=3", res.AffectedRows())
+	}
+
+	// Unregistered file should be denied and connection should be usable
+	_, _, err = my.Query("load data local infile '/etc/passwd' into table li")
+	if err == nil {
+		t.Fatal("Unregistered local file was sent to the server")
+	}
+	checkErr(t, my.Ping(), nil)
+
+	rows, _, err := my.Query("select str from li order by id")
+	checkErr(t, err, nil)
+	if len(rows) != 3 || rows[2].Str(0) != "trzy" {
+		t.Fatal("Bad result")
+	}
+
+	checkResult(t, query("drop table li"), cmdOK(0, false, true))
+	myClose(t)
+}
+
 // StmtSendLongData test
 
 func TestSendLongData(t *testing.T) {
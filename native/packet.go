@@ -8,16 +8,23 @@ import (
 )
 
 type pktReader struct {
-	rd     *bufio.Reader
-	seq    *byte
-	remain int
-	last   bool
-	buf    [12]byte
-	ibuf   [3]byte
+	rd      *bufio.Reader
+	seq     *byte
+	cio     *compressIO // nil if compression isn't used
+	metrics mysql.Metrics
+	remain  int
+	last    bool
+	buf     [12]byte
+	ibuf    [3]byte
 }
 
 func (my *Conn) newPktReader() *pktReader {
-	return &pktReader{rd: my.rd, seq: &my.seq}
+	return &pktReader{
+		rd:      my.rd,
+		seq:     &my.seq,
+		cio:     my.cio,
+		metrics: my.metrics,
+	}
 }
 
 func (pr *pktReader) readHeader() {
@@ -39,12 +46,31 @@ func (pr *pktReader) readHeader() {
 		panic(err)
 	}
 	// Chceck sequence number
-	if *pr.seq != seq {
+	if *pr.seq != seq && !pr.resync(seq) {
 		panic(mysql.ErrSeq)
 	}
 	*pr.seq++
 	// Last packet?
 	pr.last = (pr.remain != 0xffffff)
+	if pr.metrics != nil {
+		pr.metrics.Add(mysql.PacketsIn, 1)
+	}
+}
+
+// resync reports whether the packet which header was just read is the first
+// packet in a compressed packet with the same sequence number. The server sets
+// the sequence number of packets to the sequence number of compressed packets
+// when it flushes its buffer (see net_flush in MySQL sources).
+func (pr *pktReader) resync(seq byte) bool {
+	if pr.cio == nil {
+		return false
+	}
+	start := pr.cio.rtotal - uint64(pr.rd.Buffered()) - 4
+	if start != pr.cio.rstart || seq != pr.cio.rseq {
+		return false
+	}
+	*pr.seq = seq
+	return true
 }
 
 func (pr *pktReader) readFull(buf []byte) {
@@ -167,6 +193,8 @@ func (pr *pktReader) checkEof() {
 type pktWriter struct {
 	wr       *bufio.Writer
 	seq      *byte
+	cio      *compressIO // nil if compression isn't used
+	metrics  mysql.Metrics
 	remain   int
 	to_write int
 	last     bool
@@ -175,7 +203,13 @@ type pktWriter struct {
 }
 
 func (my *Conn) newPktWriter(to_write int) *pktWriter {
-	return &pktWriter{wr: my.wr, seq: &my.seq, to_write: to_write}
+	return &pktWriter{
+		wr:       my.wr,
+		seq:      &my.seq,
+		cio:      my.cio,
+		to_write: to_write,
+		metrics:  my.metrics,
+	}
 }
 
 func (pw *pktWriter) writeHeader(l int) {
@@ -189,6 +223,9 @@ func (pw *pktWriter) writeHeader(l int) {
 	}
 	// Update sequence number
 	*pw.seq++
+	if pw.metrics != nil {
+		pw.metrics.Add(mysql.PacketsOut, 1)
+	}
 }
 
 func (pw *pktWriter) write(buf []byte) {
@@ -231,6 +268,12 @@ func (pw *pktWriter) write(buf []byte) {
 		if err := pw.wr.Flush(); err != nil {
 			panic(err)
 		}
+		if pw.cio != nil {
+			// Synchronize sequence numbers like the server does after
+			// flushing (the server expects the next packet with the
+			// sequence number of the next compressed packet)
+			*pw.seq = pw.cio.seq
+		}
 	}
 	return
 }
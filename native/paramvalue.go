@@ -32,7 +32,7 @@ func (val *paramValue) Len() int {
 	}
 
 	switch val.typ {
-	case MYSQL_TYPE_STRING:
+	case MYSQL_TYPE_STRING, MYSQL_TYPE_NEWDECIMAL:
 		return lenStr(v.String())
 
 	case MYSQL_TYPE_DATE:
@@ -77,7 +77,7 @@ func (pw *pktWriter) writeValue(val *paramValue) {
 	case MYSQL_TYPE_NULL:
 		// Don't write null values
 
-	case MYSQL_TYPE_STRING:
+	case MYSQL_TYPE_STRING, MYSQL_TYPE_NEWDECIMAL:
 		pw.writeBin([]byte(v.String()))
 
 	case MYSQL_TYPE_LONG:
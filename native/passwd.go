@@ -1,9 +1,17 @@
 package native
 
 import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha1"
 	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
 	"math"
+
+	"filippo.io/edwards25519"
+	"github.com/ziutek/mymysql/mysql"
 )
 
 // Borrowed from GoMySQL
@@ -63,6 +71,78 @@ func encryptedSHA256Passwd(password string, scramble []byte) []byte {
 	return message1
 }
 
+// MariaDB client_ed25519: Ed25519 signature of scramble. The secret scalar
+// and the nonce prefix are derived from SHA512(password), like from the seed in
+// RFC 8032, but the password can be of any length, so crypto/ed25519 can't be
+// used. edwards25519 provides constant-time scalar and point arithmetic.
+func ed25519Passwd(password string, scramble []byte) []byte {
+	h := sha512.Sum512([]byte(password))
+	// Lengths of arguments are correct, so errors can be ignored
+	a, _ := edwards25519.NewScalar().SetBytesWithClamping(h[:32])
+	pub := new(edwards25519.Point).ScalarBaseMult(a).Bytes()
+
+	var digest [sha512.Size]byte
+	crypt := sha512.New()
+	crypt.Write(h[32:])
+	crypt.Write(scramble)
+	r, _ := edwards25519.NewScalar().SetUniformBytes(crypt.Sum(digest[:0]))
+	sig := new(edwards25519.Point).ScalarBaseMult(r).Bytes()
+
+	crypt.Reset()
+	crypt.Write(sig)
+	crypt.Write(pub)
+	crypt.Write(scramble)
+	k, _ := edwards25519.NewScalar().SetUniformBytes(crypt.Sum(digest[:0]))
+	s := edwards25519.NewScalar().MultiplyAdd(k, a, r)
+	return append(sig, s.Bytes()...)
+}
+
+// parsecPasswd implements the MariaDB parsec authentication: it returns the
+// client scramble followed by the Ed25519 signature of serverScramble + client
+// scramble. The signing key is PBKDF2-HMAC-SHA512(password, salt), where the
+// salt and the number of iterations are sent by the server in extSalt.
+func parsecPasswd(password string, serverScramble, extSalt []byte) ([]byte, error) {
+	if len(extSalt) < 3 || extSalt[0] != 'P' || extSalt[1] > 3 {
+		// Unknown key derivation
+		return nil, mysql.ErrAuthentication
+	}
+	iterations := 1024 << extSalt[1]
+	key := pbkdf2SHA512([]byte(password), extSalt[2:], iterations, 32)
+
+	scramble := make([]byte, 32, 32+ed25519.SignatureSize)
+	if _, err := rand.Read(scramble); err != nil {
+		return nil, err
+	}
+	msg := append(append([]byte{}, serverScramble...), scramble...)
+	sig := ed25519.Sign(ed25519.NewKeyFromSeed(key), msg)
+	return append(scramble, sig...), nil
+}
+
+// pbkdf2SHA512 implements PBKDF2 (RFC 8018) with HMAC-SHA512.
+func pbkdf2SHA512(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha512.New, password)
+	var key []byte
+	for block := uint32(1); len(key) < keyLen; block++ {
+		var n [4]byte
+		binary.BigEndian.PutUint32(n[:], block)
+		prf.Reset()
+		prf.Write(salt)
+		prf.Write(n[:])
+		u := prf.Sum(nil)
+		t := append([]byte{}, u...)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
+
 // Old password handling based on translating to Go some functions from
 // libmysql
 
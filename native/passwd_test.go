@@ -0,0 +1,76 @@
+package native
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+)
+
+func TestEd25519Passwd(t *testing.T) {
+	// For 32 bytes password client_ed25519 is the standard Ed25519 with the
+	// password used as the seed.
+	seed := []byte("0123456789abcdef0123456789abcdef")
+	for _, msg := range []string{"", "scramble", "01234567890123456789012345678901"} {
+		exp := ed25519.Sign(ed25519.NewKeyFromSeed(seed), []byte(msg))
+		sig := ed25519Passwd(string(seed), []byte(msg))
+		if !bytes.Equal(sig, exp) {
+			t.Fatalf("Bad signature of %q:\n%x\n%x", msg, sig, exp)
+		}
+	}
+
+	// MariaDB plugin/auth_ed25519/ed25519-t.c
+	exp := []byte{
+		232, 61, 201, 63, 67, 63, 51, 53, 86, 73, 238, 35, 170, 117, 146,
+		214, 26, 17, 35, 9, 8, 132, 245, 141, 48, 99, 66, 58, 36, 228, 48,
+		84, 115, 254, 187, 168, 88, 162, 249, 57, 35, 85, 79, 238, 167, 106,
+		68, 117, 56, 135, 171, 47, 20, 14, 133, 79, 15, 229, 124, 160, 176,
+		100, 138, 14,
+	}
+	sig := ed25519Passwd("foobar", bytes.Repeat([]byte{'A'}, 32))
+	if !bytes.Equal(sig, exp) {
+		t.Fatalf("Bad signature:\n%x\n%x", sig, exp)
+	}
+	// Public key of "secret" from MariaDB documentation (ed25519_password)
+	pub, _ := base64.RawStdEncoding.DecodeString(
+		"ZIgUREUg5PVgQ6LskhXmO+eZLS0nC8be6HPjYWR4YJY",
+	)
+	if !ed25519.Verify(pub, []byte("scramble"), ed25519Passwd("secret", []byte("scramble"))) {
+		t.Fatal("Signature doesn't match the public key")
+	}
+}
+
+func TestParsecPasswd(t *testing.T) {
+	// PBKDF2-HMAC-SHA512 test vectors
+	for _, v := range []struct {
+		iterations int
+		key        string
+	}{
+		{1, "867f70cf1ade02cff3752599a3a53dc4af34c7a669815ae5d513554e1c8cf252c02d470a285a0501bad999bfe943c08f050235d7d68b1da55e63f73b60a57fce"},
+		{2, "e1d9c16aa681708a45f5c7c4e215ceb66e011a2e9f0040713f18aefdb866d53cf76cab2868a39b9f7840edce4fef5a82be67335c77a6068e04112754f27ccf4e"},
+		{4096, "d197b1b33db0143e018b12f3d1d1479e6cdebdcc97c5c0f87f6902e072f457b5143f30602641b3d55cd335988cb36b84376060ecd532e039b742a239434af2d5"},
+	} {
+		key := pbkdf2SHA512([]byte("password"), []byte("salt"), v.iterations, 64)
+		if hex.EncodeToString(key) != v.key {
+			t.Fatalf("Bad PBKDF2 key for %d iterations: %x", v.iterations, key)
+		}
+	}
+
+	// Signature of server scramble + client scramble made with the key
+	// derived from the password and the salt (P, 2048 iterations, salt)
+	salt := []byte("0123456789abcdef")
+	srv_scramble := bytes.Repeat([]byte{'A'}, 32)
+	resp, err := parsecPasswd("secret", srv_scramble, append([]byte{'P', 1}, salt...))
+	if err != nil || len(resp) != 32+ed25519.SignatureSize {
+		t.Fatal("Bad response:", resp, err)
+	}
+	seed := pbkdf2SHA512([]byte("secret"), salt, 2048, 32)
+	pub := ed25519.NewKeyFromSeed(seed).Public().(ed25519.PublicKey)
+	if !ed25519.Verify(pub, append(srv_scramble, resp[:32]...), resp[32:]) {
+		t.Fatal("Bad signature")
+	}
+	if _, err = parsecPasswd("secret", srv_scramble, []byte("X\x00salt")); err == nil {
+		t.Fatal("Unknown key derivation accepted")
+	}
+}
@@ -23,6 +23,9 @@ type Stmt struct {
 	status        mysql.ConnStatus
 
 	null_bitmap []byte
+
+	fetch_size int     // Rows fetched at once from a cursor, 0: no cursor
+	cursor     *cursor // Open cursor
 }
 
 func (stmt *Stmt) Fields() []*mysql.Field {
@@ -56,13 +59,17 @@ func (stmt *Stmt) sendCmdExec() {
 		pkt_len += stmt.param_count * 2
 	}
 	// Reset sequence number
-	stmt.my.seq = 0
+	stmt.my.resetSeq()
 	// Packet sending
 	pw := stmt.my.newPktWriter(pkt_len)
 	pw.writeByte(_COM_STMT_EXECUTE)
 	pw.writeU32(stmt.id)
-	pw.writeByte(0) // flags = CURSOR_TYPE_NO_CURSOR
-	pw.writeU32(1)  // iteration_count
+	if stmt.fetch_size > 0 {
+		pw.writeByte(_CURSOR_TYPE_READ_ONLY)
+	} else {
+		pw.writeByte(_CURSOR_TYPE_NO_CURSOR)
+	}
+	pw.writeU32(1) // iteration_count
 	pw.write(stmt.null_bitmap)
 	if stmt.rebind {
 		pw.writeByte(1)
@@ -106,6 +113,7 @@ loop:
 		}
 	} else {
 		unreaded_params := (stmt.param_count < len(stmt.params))
+		var done bool
 		switch {
 		case pkt0 == 254:
 			// EOF packet
@@ -122,11 +130,17 @@ loop:
 				pr.skipAll()
 				// Increment param_count count
 				stmt.param_count++
+				done = (stmt.param_count == len(stmt.params))
 			} else {
 				field := my.getFieldPacket(pr)
 				stmt.fields[stmt.field_count] = field
 				// Increment field count
 				stmt.field_count++
+				done = (stmt.field_count == len(stmt.fields))
+			}
+			if done && my.caps&_CLIENT_DEPRECATE_EOF != 0 {
+				// There is no EOF packet after parameters and fields
+				return stmt
 			}
 			// Read next packet
 			goto loop
@@ -2,6 +2,7 @@ package native
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"github.com/ziutek/mymysql/mysql"
 	"log"
@@ -25,14 +26,22 @@ type Result struct {
 	insert_id uint64
 
 	// Number of warinigs during command execution
-	// You can use the SHOW WARNINGS query for details.
+	// You can use the Warnings method for details.
 	warning_count int
+	warnings      []mysql.Warning // Read by Warnings
+	cmd_num       uint32          // Value of my.cmd_count for this response
+
+	// Session state changes from the OK packet
+	session []mysql.SessionChange
 
 	// MySQL server status immediately after the query execution
 	status mysql.ConnStatus
 
 	// Seted by GetRow if it returns nil row
 	eor_returned bool
+
+	// Cursor opened for the result, nil if rows are read from the connection
+	cursor *cursor
 }
 
 // StatusOnly returns true if this is status result that includes no result set
@@ -69,16 +78,115 @@ func (res *Result) WarnCount() int {
 	return res.warning_count
 }
 
+// SessionChanges returns the session state changes reported by the server
+// with this result (nil if there are none). The server sends only the changes
+// enabled by the session_track_* system variables.
+func (res *Result) SessionChanges() []mysql.SessionChange {
+	return res.session
+}
+
+// Warnings returns the warnings generated by the statement that returned res
+// (nil if WarnCount() == 0). They are read lazily using SHOW WARNINGS on the
+// same connection, so the whole response (all rows of all results) must be
+// read first (otherwise mysql.ErrUnreadedReply is returned) and no other
+// command can be sent in between (mysql.ErrWarningsLost). The server returns
+// at most max_error_count warnings and, for multiple statements, only the
+// warnings of the last one.
+func (res *Result) Warnings() ([]mysql.Warning, error) {
+	if res.warnings != nil {
+		return res.warnings, nil
+	}
+	my := res.my
+	if my.unreaded_reply {
+		// The warning count isn't known before the end of the result
+		return nil, mysql.ErrUnreadedReply
+	}
+	if res.warning_count == 0 {
+		return nil, nil
+	}
+	if res.cmd_num != my.cmd_count {
+		return nil, mysql.ErrWarningsLost
+	}
+	warnings, err := my.showWarnings()
+	// SHOW WARNINGS doesn't clear warnings
+	my.cmd_count = res.cmd_num
+	if err != nil {
+		return nil, err
+	}
+	res.warnings = warnings
+	return warnings, nil
+}
+
+// showWarnings reads the warnings using SHOW WARNINGS. It is an internal
+// query: it isn't traced and the strict warnings mode doesn't apply to it.
+func (my *Conn) showWarnings() (warnings []mysql.Warning, err error) {
+	defer catchError(&err)
+
+	trace_ev := my.trace_ev
+	my.trace_ev = nil
+	defer func() { my.trace_ev = trace_ev }()
+
+	my.sendCmdStr(_COM_QUERY, "SHOW WARNINGS")
+	res := my.getResponse()
+	if res.StatusOnly() {
+		return nil, mysql.ErrBadResult
+	}
+	level, code, msg := res.Map("Level"), res.Map("Code"), res.Map("Message")
+	if level < 0 || code < 0 || msg < 0 {
+		panic(mysql.ErrBadResult)
+	}
+	row := res.MakeRow()
+	warnings = []mysql.Warning{}
+	for {
+		if my.getResult(res, row) != nil {
+			break // EOF
+		}
+		warnings = append(warnings, mysql.Warning{
+			Level:   row.Str(level),
+			Code:    uint16(row.Uint(code)),
+			Message: row.Str(msg),
+		})
+	}
+	my.unreaded_reply = false
+	return
+}
+
+// checkWarnings returns *mysql.WarningError in the strict warnings mode if the
+// statement generated warnings. The whole response must be read.
+func (res *Result) checkWarnings() error {
+	if !res.my.strict_warnings || res.warning_count == 0 {
+		return nil
+	}
+	warnings, err := res.Warnings()
+	if err != nil {
+		return err
+	}
+	return &mysql.WarningError{Warnings: warnings}
+}
+
+// checkLastWarnings calls checkWarnings if res is the last status result.
+func (res *Result) checkLastWarnings() error {
+	if !res.StatusOnly() || res.MoreResults() {
+		return nil
+	}
+	return res.checkWarnings()
+}
+
 func (res *Result) MakeRow() mysql.Row {
 	return make(mysql.Row, res.field_count)
 }
 
 // getAuthResult After sending login request
-// use this func get server return packet
+// use this func get server return packet. Error packet (eg. access denied) is
+// panicked as *mysql.Error.
 func (my *Conn) getAuthResult() ([]byte, string) {
 	pr := my.newPktReader()
+	pkt0 := pr.readByte()
+	if pkt0 == 255 {
+		// Error packet (eg. access denied)
+		my.getErrorPacket(pr)
+	}
 	pkt := pr.readAll()
-	pkt0 := pkt[0]
 
 	// packet indicator
 	switch pkt0 {
@@ -86,10 +194,10 @@ func (my *Conn) getAuthResult() ([]byte, string) {
 		return nil, ""
 
 	case 1: // AuthMoreData
-		return pkt[1:], ""
+		return pkt, ""
 
 	case 254: // EOF
-		if len(pkt) == 1 {
+		if len(pkt) == 0 {
 			// https://dev.mysql.com/doc/internals/en/connection-phase-packets.html#packet-Protocol::OldAuthSwitchRequest
 			return nil, "mysql_old_password"
 		}
@@ -97,17 +205,14 @@ func (my *Conn) getAuthResult() ([]byte, string) {
 		if pluginEndIndex < 0 {
 			return nil, ""
 		}
-		plugin := string(pkt[1:pluginEndIndex])
+		plugin := string(pkt[:pluginEndIndex])
 		authData := pkt[pluginEndIndex+1:]
 		return authData, plugin
 
-	case 255: // Error packet
-		panic(mysql.ErrAuthentication)
-		return nil, ""
-
-	default: // Error otherwise
-		panic(mysql.ErrUnkResultPkt)
-		return nil, ""
+	default:
+		// MariaDB sends plugin data without AuthMoreData header if it
+		// doesn't start with 0, 1, 254 or 255
+		return append([]byte{pkt0}, pkt...), ""
 	}
 }
 
@@ -134,7 +239,13 @@ loop:
 			goto loop
 		case pkt0 == 251:
 			// Load infile response
-			// Handle response
+			if err := my.handleLocalInfile(pr); err != nil {
+				// Read the server response to the empty file and
+				// return the local error.
+				my.getResult(nil, nil)
+				panic(err)
+			}
+			// Read OK or error packet
 			goto loop
 		case pkt0 == 254:
 			// EOF packet (without body)
@@ -142,10 +253,9 @@ loop:
 		}
 	} else {
 		switch {
-		case pkt0 == 254:
-			// EOF packet
-			res.warning_count, res.status = my.getEofPacket(pr)
-			my.status = res.status
+		case pkt0 == 254 && pr.last:
+			// EOF packet (OK packet if CLIENT_DEPRECATE_EOF is used)
+			my.getEndPacket(pr, res)
 			return res
 
 		case pkt0 > 0 && pkt0 < 251 && res.field_count < len(res.fields):
@@ -155,11 +265,16 @@ loop:
 			res.fc_map[field.Name] = res.field_count
 			// Increment field count
 			res.field_count++
+			if res.field_count == len(res.fields) &&
+				my.caps&_CLIENT_DEPRECATE_EOF != 0 {
+				// There is no EOF packet after fields
+				return res
+			}
 			// Read next packet
 			goto loop
 
-		case pkt0 < 254 && res.field_count == len(res.fields):
-			// Row Data Packet
+		case res.field_count == len(res.fields):
+			// Row Data Packet (starts with 254 only if longer than 16 MB)
 			if len(row) != res.field_count {
 				panic(mysql.ErrRowLength)
 			}
@@ -187,7 +302,14 @@ func (my *Conn) getOkPacket(pr *pktReader) (res *Result) {
 	res.status = mysql.ConnStatus(pr.readU16())
 	my.status = res.status
 	res.warning_count = int(pr.readU16())
-	res.message = pr.readAll()
+	if my.caps&_CLIENT_SESSION_TRACK == 0 {
+		res.message = pr.readAll()
+	} else if !pr.eof() {
+		res.message = pr.readBin()
+		if res.status&mysql.SERVER_SESSION_STATE_CHANGED != 0 {
+			res.session = my.getSessionState(pr)
+		}
+	}
 	pr.checkEof()
 
 	if my.Debug {
@@ -208,16 +330,33 @@ func (my *Conn) getErrorPacket(pr *pktReader) {
 	if pr.readByte() != '#' {
 		panic(mysql.ErrPkt)
 	}
-	pr.skipN(5)
+	var state [5]byte
+	pr.readFull(state[:])
+	err.SQLState = string(state[:])
 	err.Msg = pr.readAll()
 	pr.checkEof()
 
 	if my.Debug {
-		log.Printf(tab8s+"code=0x%x msg=\"%s\"", err.Code, err.Msg)
+		log.Printf(tab8s+"code=0x%x state=%s msg=\"%s\"", err.Code,
+			err.SQLState, err.Msg)
 	}
 	panic(&err)
 }
 
+// getEndPacket reads the packet that ends the rows of res (or the fields if
+// the server opened a cursor). If CLIENT_DEPRECATE_EOF is used it is the OK
+// packet with 254 header, which can also contain session state changes.
+func (my *Conn) getEndPacket(pr *pktReader, res *Result) {
+	if my.caps&_CLIENT_DEPRECATE_EOF == 0 {
+		res.warning_count, res.status = my.getEofPacket(pr)
+		my.status = res.status
+		return
+	}
+	ok := my.getOkPacket(pr)
+	res.warning_count, res.status = ok.warning_count, ok.status
+	res.session = append(res.session, ok.session...)
+}
+
 func (my *Conn) getEofPacket(pr *pktReader) (warn_count int, status mysql.ConnStatus) {
 	if my.Debug {
 		if pr.eof() {
@@ -337,7 +476,15 @@ func (my *Conn) getBinRowPacket(pr *pktReader, res *Result, row mysql.Row) {
 			continue
 		}
 		unsigned := (field.Flags & _FLAG_UNSIGNED) != 0
-		if my.narrowTypeSet {
+		if my.decimal_mode == mysql.DecimalExact &&
+			(field.Type == MYSQL_TYPE_NEWDECIMAL ||
+				field.Type == MYSQL_TYPE_DECIMAL) {
+			if my.narrowTypeSet {
+				row[ii] = pr.readBin()
+			} else {
+				row[ii] = mysql.Decimal(pr.readBin())
+			}
+		} else if my.narrowTypeSet {
 			row[ii] = readValueNarrow(pr, field.Type, unsigned)
 		} else {
 			row[ii] = readValue(pr, field.Type, unsigned)
@@ -352,6 +499,8 @@ func readValue(pr *pktReader, typ byte, unsigned bool) interface{} {
 		MYSQL_TYPE_MEDIUM_BLOB, MYSQL_TYPE_LONG_BLOB, MYSQL_TYPE_SET,
 		MYSQL_TYPE_ENUM, MYSQL_TYPE_GEOMETRY:
 		return pr.readBin()
+	case MYSQL_TYPE_JSON:
+		return json.RawMessage(pr.readBin())
 	case MYSQL_TYPE_TINY:
 		if unsigned {
 			return pr.readByte()
@@ -402,7 +551,7 @@ func readValueNarrow(pr *pktReader, typ byte, unsigned bool) interface{} {
 	case MYSQL_TYPE_STRING, MYSQL_TYPE_VAR_STRING, MYSQL_TYPE_VARCHAR,
 		MYSQL_TYPE_BIT, MYSQL_TYPE_BLOB, MYSQL_TYPE_TINY_BLOB,
 		MYSQL_TYPE_MEDIUM_BLOB, MYSQL_TYPE_LONG_BLOB, MYSQL_TYPE_SET,
-		MYSQL_TYPE_ENUM, MYSQL_TYPE_GEOMETRY:
+		MYSQL_TYPE_ENUM, MYSQL_TYPE_GEOMETRY, MYSQL_TYPE_JSON:
 		return pr.readBin()
 	case MYSQL_TYPE_TINY:
 		if unsigned {
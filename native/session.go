@@ -0,0 +1,226 @@
+package native
+
+import (
+	"log"
+
+	"github.com/ziutek/mymysql/mysql"
+)
+
+// ResetSession resets the session state without reconnecting: rolls back an
+// active transaction, drops temporary tables, releases locks and resets user
+// variables and session system variables. It uses COM_RESET_CONNECTION or
+// COM_CHANGE_USER with current credentials if the server doesn't support it
+// (MySQL < 5.7.3). Registered commands are executed again and all prepared
+// statements are reprepared, so Stmt handlers remain valid. If
+// COM_CHANGE_USER fails the connection is closed.
+func (my *Conn) ResetSession() (err error) {
+	if my.net_conn == nil {
+		return mysql.ErrNotConn
+	}
+	if my.unreaded_reply {
+		return mysql.ErrUnreadedReply
+	}
+	err = my.resetConnection()
+	if e, ok := err.(*mysql.Error); ok && e.Code == mysql.ER_UNKNOWN_COM_ERROR {
+		// Old server
+		if err = my.refreshCredentials(); err != nil {
+			return
+		}
+		err = my.changeUser(my.user, my.passwd, my.dbname)
+	}
+	if err != nil {
+		return
+	}
+	return my.restoreSession()
+}
+
+// ChangeUser changes the user and the database of the connection using
+// COM_CHANGE_USER. The server resets the session state like ResetSession
+// does. Registered commands are executed again and all prepared statements
+// are reprepared. New credentials are also used by Reconnect. If the
+// authentication fails the connection is closed and the credentials aren't
+// changed.
+func (my *Conn) ChangeUser(user, passwd, dbname string) (err error) {
+	if my.net_conn == nil {
+		return mysql.ErrNotConn
+	}
+	if my.unreaded_reply {
+		return mysql.ErrUnreadedReply
+	}
+	if err = my.changeUser(user, passwd, dbname); err != nil {
+		return
+	}
+	return my.restoreSession()
+}
+
+func (my *Conn) resetConnection() (err error) {
+	defer catchError(&err)
+
+	my.sendCmd(_COM_RESET_CONNECTION)
+	my.getResult(nil, nil)
+	return
+}
+
+func (my *Conn) changeUser(user, passwd, dbname string) (err error) {
+	old_user, old_passwd, old_dbname := my.user, my.passwd, my.dbname
+	defer func() {
+		if err != nil {
+			my.user, my.passwd, my.dbname = old_user, old_passwd, old_dbname
+			if my.net_conn != nil {
+				// Session state is unknown after failed COM_CHANGE_USER
+				my.net_conn.Close()
+				my.net_conn = nil
+			}
+		}
+	}()
+	defer catchError(&err)
+
+	my.user, my.passwd, my.dbname = user, passwd, dbname
+	my.sendCmdChangeUser()
+	my.authResponse()
+	my.schema = dbname
+	return
+}
+
+// restoreSession executes registered commands and reprepares statements
+// after the server reset the session.
+func (my *Conn) restoreSession() (err error) {
+	defer catchError(&err)
+
+	if err = my.runInitCmds(); err != nil {
+		return
+	}
+	return my.reprepare()
+}
+
+// _COM_CHANGE_USER:
+func (my *Conn) sendCmdChangeUser() {
+	if my.plugin != "caching_sha2_password" {
+		// Other plugins may need the scramble from the auth switch request
+		// or can send the password in the first packet. Server will send
+		// the auth switch request if needed.
+		my.plugin = "mysql_native_password"
+	}
+	scrPasswd, err := my.authPlugin().InitialResponse(
+		my.authInfo(my.info.scramble[:]),
+	)
+	if err != nil {
+		panic(err)
+	}
+	pay_len := 1 + len(my.user) + 1 + 1 + len(scrPasswd) + len(my.dbname) + 1 + 2
+	plugin_auth := my.info.caps&_CLIENT_PLUGIN_AUTH != 0
+	if plugin_auth {
+		pay_len += len(my.plugin) + 1
+	}
+	var attrs []byte
+	if my.caps&_CLIENT_CONNECT_ATTRS != 0 {
+		attrs = my.encodeConnectAttrs()
+		pay_len += len(attrs)
+	}
+
+	my.resetSeq()
+	pw := my.newPktWriter(pay_len)
+	pw.writeByte(_COM_CHANGE_USER)
+	pw.writeNTB([]byte(my.user))       // Username
+	pw.writeByte(byte(len(scrPasswd))) // Length of auth response
+	pw.write(scrPasswd)                // Encrypted password
+	pw.writeNTB([]byte(my.dbname))     // Database name
+	pw.writeU16(uint16(my.info.lang))  // Charset number
+	if plugin_auth {
+		pw.writeNTB([]byte(my.plugin))
+	}
+	pw.write(attrs) // Connection attributes
+	if my.Debug {
+		log.Printf("[%2d <-] Change user packet: User=\"%s\" Db=\"%s\"",
+			my.seq-1, my.user, my.dbname)
+	}
+}
+
+// CurrentSchema returns the default database of the session. It is updated
+// by Connect, Use and ChangeUser and, if the server tracks the schema
+// (session_track_schema, on by default), by any statement that changes it
+// (eg. USE).
+func (my *Conn) CurrentSchema() string {
+	return my.schema
+}
+
+// LastGTIDs returns the GTID set reported by the server after the last
+// statement that reported it (session_track_gtids must be set to OWN_GTID or
+// ALL_GTIDS). It can be used to wait for a replica to apply the writes (see
+// WAIT_FOR_EXECUTED_GTID_SET) before reading from it.
+func (my *Conn) LastGTIDs() string {
+	return my.gtids
+}
+
+// getSessionState reads the session state change information from the OK
+// packet and updates the tracked session state.
+func (my *Conn) getSessionState(pr *pktReader) (changes []mysql.SessionChange) {
+	state := pr.readBin()
+	for len(state) > 0 {
+		var data, val, name []byte
+		typ := mysql.SessionTrackType(state[0])
+		data, state = splitLCS(state[1:])
+		switch typ {
+		case mysql.SessionTrackSystemVariables:
+			name, data = splitLCS(data)
+			val, _ = splitLCS(data)
+		case mysql.SessionTrackGTIDs:
+			if len(data) == 0 {
+				panic(mysql.ErrPkt)
+			}
+			// The first byte is the encoding specification (always 0)
+			val, _ = splitLCS(data[1:])
+			my.gtids = string(val)
+		case mysql.SessionTrackSchema:
+			val, _ = splitLCS(data)
+			my.schema = string(val)
+		case mysql.SessionTrackStateChange,
+			mysql.SessionTrackTransactionCharacteristics,
+			mysql.SessionTrackTransactionState:
+			val, _ = splitLCS(data)
+		default:
+			// Unknown tracker, return its raw data
+			val = data
+		}
+		changes = append(changes, mysql.SessionChange{
+			Type:  typ,
+			Name:  string(name),
+			Value: string(val),
+		})
+		if my.Debug {
+			log.Printf(tab8s+"Session state change: %s %s=%q", typ, name, val)
+		}
+	}
+	return
+}
+
+// splitLCS splits the length coded string from the beginning of b.
+func splitLCS(b []byte) (s, rest []byte) {
+	if len(b) == 0 {
+		panic(mysql.ErrPkt)
+	}
+	n, k := uint64(b[0]), 1
+	switch b[0] {
+	case 0xfc:
+		k = 3
+	case 0xfd:
+		k = 4
+	case 0xfe:
+		k = 9
+	case 0xfb, 0xff:
+		panic(mysql.ErrUnexpNullLCS)
+	}
+	if len(b) < k {
+		panic(mysql.ErrPkt)
+	}
+	if k > 1 {
+		n = 0
+		for i := k - 1; i > 0; i-- {
+			n = n<<8 | uint64(b[i])
+		}
+	}
+	if uint64(len(b)-k) < n {
+		panic(mysql.ErrPkt)
+	}
+	return b[k : k+int(n)], b[k+int(n):]
+}
@@ -0,0 +1,180 @@
+package native
+
+import (
+	"context"
+	"net"
+	"time"
+
+	"github.com/ziutek/mymysql/mysql"
+)
+
+// SetTracer sets the tracer that receives events from the connection (see
+// mysql.Tracer). nil disables tracing. Clone copies the tracer.
+func (my *Conn) SetTracer(t mysql.Tracer) {
+	my.tracer = t
+}
+
+// SetMetrics sets the collector of the connection metrics (see
+// mysql.Metrics). nil disables metrics. Clone copies the collector. Network
+// traffic is counted for connections established after SetMetrics.
+func (my *Conn) SetMetrics(m mysql.Metrics) {
+	my.metrics = m
+}
+
+func (my *Conn) countError(err error) {
+	if my.metrics != nil && err != nil {
+		var code uint16
+		if e, ok := err.(*mysql.Error); ok {
+			code = e.Code
+		}
+		my.metrics.Error(code)
+	}
+}
+
+// traceConnect reports the result of Connect or Reconnect.
+func (my *Conn) traceConnect(reconnect bool, start time.Time, err error) {
+	if m := my.metrics; m != nil {
+		if reconnect {
+			m.Add(mysql.Reconnects, 1)
+		}
+		if err == nil {
+			m.Add(mysql.Connects, 1)
+		}
+		my.countError(err)
+	}
+	if my.tracer == nil {
+		return
+	}
+	ev := mysql.ConnectEvent{
+		Addr:     my.raddr,
+		Duration: time.Since(start),
+		Err:      err,
+	}
+	if err == nil {
+		ev.ThreadId = my.info.thr_id
+		ev.ServerVersion = string(my.info.serv_ver)
+	}
+	if reconnect {
+		my.tracer.Reconnect(&ev)
+	} else {
+		my.tracer.Connect(&ev)
+	}
+}
+
+func (my *Conn) traceAuth(err error) {
+	if my.tracer != nil {
+		my.tracer.Auth(&mysql.AuthEvent{
+			Addr:   my.raddr,
+			User:   my.user,
+			Plugin: my.plugin,
+			Err:    err,
+		})
+	}
+}
+
+// traceStart starts tracing of the query or the statement execution
+// (stmt_id != 0). The event is finished by traceEnd.
+func (my *Conn) traceStart(sql string, stmt_id uint32) {
+	if my.tracer == nil && my.metrics == nil {
+		return
+	}
+	ctx := my.trace_ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	my.trace_ev = &mysql.QueryEvent{
+		Ctx:    ctx,
+		Addr:   my.raddr,
+		SQL:    sql,
+		StmtId: stmt_id,
+		Start:  time.Now(),
+	}
+	if my.tracer == nil {
+		return
+	}
+	if stmt_id == 0 {
+		my.tracer.QueryStart(my.trace_ev)
+	} else {
+		my.tracer.ExecStart(my.trace_ev)
+	}
+}
+
+// traceResult finishes the traced event if the response was read or err isn't
+// nil.
+func (my *Conn) traceResult(err error) {
+	if err != nil || !my.unreaded_reply {
+		my.traceEnd(err)
+	}
+}
+
+// traceNext is deferred by nextResult after catchError.
+func (my *Conn) traceNext(err *error) {
+	my.traceResult(*err)
+}
+
+func (my *Conn) traceEnd(err error) {
+	ev := my.trace_ev
+	if ev == nil {
+		return
+	}
+	my.trace_ev = nil
+	ev.Duration = time.Since(ev.Start)
+	ev.Err = err
+	if m := my.metrics; m != nil {
+		if ev.StmtId == 0 {
+			m.Add(mysql.Queries, 1)
+		} else {
+			m.Add(mysql.Execs, 1)
+		}
+		m.Observe(mysql.QueryDuration, ev.Duration)
+		my.countError(err)
+	}
+	if my.tracer == nil {
+		return
+	}
+	if ev.StmtId == 0 {
+		my.tracer.QueryEnd(ev)
+	} else {
+		my.tracer.ExecEnd(ev)
+	}
+}
+
+// traceStmt reports the result of Prepare (close == false) or Delete.
+func (my *Conn) traceStmt(close bool, id uint32, sql string, start time.Time,
+	err error) {
+
+	my.countError(err)
+	if my.tracer == nil {
+		return
+	}
+	ev := mysql.StmtEvent{
+		Addr:     my.raddr,
+		SQL:      sql,
+		StmtId:   id,
+		Duration: time.Since(start),
+		Err:      err,
+	}
+	if close {
+		my.tracer.StmtClose(&ev)
+	} else {
+		my.tracer.Prepare(&ev)
+	}
+}
+
+// meteredConn counts the network traffic.
+type meteredConn struct {
+	net.Conn
+	m mysql.Metrics
+}
+
+func (c meteredConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.m.Add(mysql.BytesIn, uint64(n))
+	return n, err
+}
+
+func (c meteredConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.m.Add(mysql.BytesOut, uint64(n))
+	return n, err
+}
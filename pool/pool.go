@@ -0,0 +1,443 @@
+// Package pool provides a pool of MySQL connections for MyMySQL.
+//
+// In contrast to thrsafe engine, goroutines don't share one connection. Every
+// goroutine gets its own connection from the pool (Get) and returns it after
+// use (Put), so the whole mysql.Conn API (Row accessors, SendLongData, multi
+// results) can be used without serialising all queries on one mutex.
+//
+// Example:
+//
+//	p := pool.New(mysql.New("tcp", "", "127.0.0.1:3306", user, pass, dbname),
+//		pool.Config{MaxOpen: 16, MaxLifetime: time.Hour})
+//	defer p.Close()
+//	sel := p.Prepare("select name from X where id = ?")
+//
+//	c, err := p.Get(ctx)
+//	if err != nil {
+//		return err
+//	}
+//	defer p.Put(c)
+//	stmt, err := c.Stmt(sel)
+//	if err != nil {
+//		return err
+//	}
+//	row, _, err := stmt.ExecFirst(id)
+package pool
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/ziutek/mymysql/mysql"
+)
+
+var ErrClosed = errors.New("pool: pool is closed")
+
+// Config contains the pool parameters.
+type Config struct {
+	// Maximum number of open connections (0 means no limit).
+	MaxOpen int
+	// Maximum number of idle connections (0 means MinIdle or 2 if MinIdle is
+	// also 0).
+	MaxIdle int
+	// Number of idle connections kept open in background.
+	MinIdle int
+	// Connections older than MaxLifetime are closed (0 means no limit).
+	MaxLifetime time.Duration
+	// Connections idle longer than IdleTimeout are closed (0 means no limit).
+	IdleTimeout time.Duration
+	// Get checks a connection that was idle longer than PingAfter using Ping
+	// (0 means always, negative value disables checks).
+	PingAfter time.Duration
+}
+
+// Stats contains the pool statistics.
+type Stats struct {
+	Open  int // Number of open connections (in use and idle)
+	InUse int // Number of connections in use
+	Idle  int // Number of idle connections
+
+	WaitCount    int64         // Number of Get calls that waited for connection
+	WaitDuration time.Duration // Total time spent waiting for connections
+	PingFailed   int64         // Number of connections closed after failed Ping
+	Expired      int64         // Number of connections closed after MaxLifetime or IdleTimeout
+}
+
+// Pool is a pool of connections created as clones of the template connection.
+// It can be used by multiple goroutines.
+type Pool struct {
+	tmpl mysql.Conn
+	cfg  Config
+
+	mutex   sync.Mutex
+	idle    []*Conn // The most recently used at the end
+	open    int
+	waiters []chan *Conn
+	init    []string
+	stmts   []*Stmt
+	stats   Stats
+	closed  bool
+	done    chan struct{}
+}
+
+// Conn is a connection from the pool. Return it to the pool using Put after
+// use. If the connection is closed (or broken) before Put, the pool opens a
+// new one when needed.
+type Conn struct {
+	mysql.Conn
+	p *Pool
+
+	created time.Time
+	used    time.Time // Last time the connection was returned to the pool
+	stmts   map[*Stmt]mysql.Stmt
+}
+
+// Stmt is a statement registered on the pool using Prepare.
+type Stmt struct {
+	sql string
+}
+
+// New creates a new pool. Connections are created as clones of tmpl (which
+// isn't used by the pool itself).
+func New(tmpl mysql.Conn, cfg Config) *Pool {
+	if cfg.MaxIdle <= 0 {
+		cfg.MaxIdle = cfg.MinIdle
+		if cfg.MaxIdle == 0 {
+			cfg.MaxIdle = 2
+		}
+	}
+	if cfg.MaxOpen > 0 && cfg.MaxIdle > cfg.MaxOpen {
+		cfg.MaxIdle = cfg.MaxOpen
+	}
+	if cfg.MinIdle > cfg.MaxIdle {
+		cfg.MinIdle = cfg.MaxIdle
+	}
+	p := &Pool{tmpl: tmpl, cfg: cfg, done: make(chan struct{})}
+	if cfg.MinIdle > 0 || cfg.MaxLifetime > 0 || cfg.IdleTimeout > 0 {
+		go p.maintain()
+	}
+	return p
+}
+
+// Register registers initialisation commands executed by every new
+// connection (see mysql.Conn.Register).
+func (p *Pool) Register(sql string) {
+	p.mutex.Lock()
+	p.init = append(p.init, sql)
+	p.mutex.Unlock()
+}
+
+// Prepare registers the statement on the pool. Every new connection prepares
+// it after connect, already opened connections prepare it on first use (see
+// Conn.Stmt). Reconnect reprepares it like any other statement.
+func (p *Pool) Prepare(sql string) *Stmt {
+	st := &Stmt{sql}
+	p.mutex.Lock()
+	p.stmts = append(p.stmts, st)
+	p.mutex.Unlock()
+	return st
+}
+
+// Stmt returns st prepared on the connection c.
+func (c *Conn) Stmt(st *Stmt) (mysql.Stmt, error) {
+	if s, ok := c.stmts[st]; ok {
+		return s, nil
+	}
+	s, err := c.Prepare(st.sql)
+	if err != nil {
+		return nil, err
+	}
+	c.stmts[st] = s
+	return s, nil
+}
+
+func (p *Pool) newConn() (*Conn, error) {
+	p.mutex.Lock()
+	init, stmts := p.init, p.stmts
+	p.mutex.Unlock()
+
+	raw := p.tmpl.Clone()
+	for _, sql := range init {
+		raw.Register(sql)
+	}
+	if err := raw.Connect(); err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	c := &Conn{
+		Conn:    raw,
+		p:       p,
+		created: now,
+		used:    now,
+		stmts:   make(map[*Stmt]mysql.Stmt),
+	}
+	for _, st := range stmts {
+		if _, err := c.Stmt(st); err != nil {
+			raw.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (p *Pool) expired(c *Conn, now time.Time) bool {
+	return p.cfg.MaxLifetime > 0 && now.Sub(c.created) >= p.cfg.MaxLifetime ||
+		p.cfg.IdleTimeout > 0 && now.Sub(c.used) >= p.cfg.IdleTimeout
+}
+
+// wakeOne informs the first waiting Get that it can open a new connection.
+// p.mutex must be locked.
+func (p *Pool) wakeOne() {
+	if len(p.waiters) > 0 {
+		p.waiters[0] <- nil
+		p.waiters = p.waiters[1:]
+	}
+}
+
+// Get returns an idle connection or opens a new one. If MaxOpen connections
+// are in use, it waits until one of them is returned or ctx is done.
+func (p *Pool) Get(ctx context.Context) (*Conn, error) {
+	p.mutex.Lock()
+	for {
+		if p.closed {
+			p.mutex.Unlock()
+			return nil, ErrClosed
+		}
+		if err := ctx.Err(); err != nil {
+			p.mutex.Unlock()
+			return nil, err
+		}
+		if n := len(p.idle); n > 0 {
+			c := p.idle[n-1]
+			p.idle = p.idle[:n-1]
+			now := time.Now()
+			if p.expired(c, now) {
+				p.open--
+				p.stats.Expired++
+				p.mutex.Unlock()
+				c.Conn.Close()
+				p.mutex.Lock()
+				continue
+			}
+			p.mutex.Unlock()
+			if p.cfg.PingAfter < 0 || now.Sub(c.used) < p.cfg.PingAfter ||
+				c.Ping() == nil {
+				return c, nil
+			}
+			c.Conn.Close()
+			p.mutex.Lock()
+			p.open--
+			p.stats.PingFailed++
+			continue
+		}
+		if p.cfg.MaxOpen <= 0 || p.open < p.cfg.MaxOpen {
+			p.open++
+			p.mutex.Unlock()
+			c, err := p.newConn()
+			if err != nil {
+				p.mutex.Lock()
+				p.open--
+				p.wakeOne()
+				p.mutex.Unlock()
+				return nil, err
+			}
+			return c, nil
+		}
+
+		// Wait for a returned connection or for a free slot (nil)
+		ch := make(chan *Conn, 1)
+		p.waiters = append(p.waiters, ch)
+		p.stats.WaitCount++
+		start := time.Now()
+		p.mutex.Unlock()
+		select {
+		case c := <-ch:
+			p.mutex.Lock()
+			p.stats.WaitDuration += time.Since(start)
+			if c != nil {
+				p.mutex.Unlock()
+				return c, nil
+			}
+		case <-ctx.Done():
+			p.mutex.Lock()
+			p.stats.WaitDuration += time.Since(start)
+			if !p.removeWaiter(ch) {
+				// Something was sent before we removed ch
+				if c := <-ch; c != nil {
+					if c = p.release(c); c != nil {
+						defer c.Conn.Close()
+					}
+				} else {
+					p.wakeOne()
+				}
+			}
+			p.mutex.Unlock()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (p *Pool) removeWaiter(ch chan *Conn) bool {
+	for i, w := range p.waiters {
+		if w == ch {
+			p.waiters = append(p.waiters[:i], p.waiters[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// release returns c to the pool and returns c if it should be closed.
+// p.mutex must be locked.
+func (p *Pool) release(c *Conn) *Conn {
+	now := time.Now()
+	c.used = now
+	// Connection with unread results can't be used by the next Get
+	pending := c.IsConnected() && c.PendingReply()
+	if p.closed || !c.IsConnected() || pending || p.expired(c, now) {
+		if !p.closed && c.IsConnected() && !pending {
+			p.stats.Expired++
+		}
+		p.open--
+		p.wakeOne()
+		return c
+	}
+	if len(p.waiters) > 0 {
+		p.waiters[0] <- c
+		p.waiters = p.waiters[1:]
+		return nil
+	}
+	if len(p.idle) >= p.cfg.MaxIdle {
+		p.open--
+		return c
+	}
+	p.idle = append(p.idle, c)
+	return nil
+}
+
+// Put returns the connection to the pool. All results should be read before
+// Put, otherwise the connection is closed. c must not be used after Put.
+func (p *Pool) Put(c *Conn) {
+	p.mutex.Lock()
+	c = p.release(c)
+	p.mutex.Unlock()
+	if c != nil && c.IsConnected() {
+		if c.PendingReply() {
+			// Close returns ErrUnreadedReply in this case
+			c.NetConn().Close()
+		} else {
+			c.Conn.Close()
+		}
+	}
+}
+
+// Stats returns the pool statistics.
+func (p *Pool) Stats() Stats {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	s := p.stats
+	s.Open = p.open
+	s.Idle = len(p.idle)
+	s.InUse = s.Open - s.Idle
+	return s
+}
+
+// Close closes all idle connections. Connections in use are closed by Put.
+// Waiting Get calls return ErrClosed.
+func (p *Pool) Close() error {
+	p.mutex.Lock()
+	if p.closed {
+		p.mutex.Unlock()
+		return nil
+	}
+	p.closed = true
+	close(p.done)
+	idle := p.idle
+	p.idle = nil
+	p.open -= len(idle)
+	for len(p.waiters) > 0 {
+		p.wakeOne()
+	}
+	p.mutex.Unlock()
+
+	var err error
+	for _, c := range idle {
+		if e := c.Conn.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// maintain closes expired idle connections and opens new ones to keep
+// MinIdle idle connections.
+func (p *Pool) maintain() {
+	interval := time.Second
+	for _, d := range []time.Duration{p.cfg.MaxLifetime, p.cfg.IdleTimeout} {
+		if d > 0 && d/2 < interval {
+			interval = d / 2
+		}
+	}
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-t.C:
+			p.clean()
+		}
+	}
+}
+
+func (p *Pool) clean() {
+	now := time.Now()
+	var old []*Conn
+	p.mutex.Lock()
+	idle := p.idle[:0]
+	for _, c := range p.idle {
+		if p.expired(c, now) {
+			old = append(old, c)
+		} else {
+			idle = append(idle, c)
+		}
+	}
+	for i := len(idle); i < len(p.idle); i++ {
+		p.idle[i] = nil
+	}
+	p.idle = idle
+	p.open -= len(old)
+	p.stats.Expired += int64(len(old))
+	n := p.cfg.MinIdle - len(p.idle)
+	if p.cfg.MaxOpen > 0 && n > p.cfg.MaxOpen-p.open {
+		n = p.cfg.MaxOpen - p.open
+	}
+	if n < 0 {
+		n = 0
+	}
+	p.open += n
+	p.mutex.Unlock()
+
+	for _, c := range old {
+		c.Conn.Close()
+	}
+	for ; n > 0; n-- {
+		c, err := p.newConn()
+		p.mutex.Lock()
+		if err != nil {
+			p.open--
+			p.wakeOne()
+		} else {
+			c = p.release(c)
+		}
+		p.mutex.Unlock()
+		if c != nil {
+			c.Conn.Close()
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package pool
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ziutek/mymysql/mysql"
+	"github.com/ziutek/mymysql/mysqltest"
+	_ "github.com/ziutek/mymysql/native"
+)
+
+const (
+	user   = "testuser"
+	passwd = "TestPasswd9"
+)
+
+func startServer(t *testing.T) *mysqltest.Server {
+	srv := &mysqltest.Server{User: user, Passwd: passwd}
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return srv
+}
+
+func newPool(srv *mysqltest.Server, cfg Config) *Pool {
+	return New(mysql.New("tcp", "", srv.Addr(), user, passwd), cfg)
+}
+
+func checkErr(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkStats(t *testing.T, p *Pool, open, inUse, idle int) {
+	t.Helper()
+	s := p.Stats()
+	if s.Open != open || s.InUse != inUse || s.Idle != idle {
+		t.Fatalf("Bad stats: %+v", s)
+	}
+}
+
+func TestGetPut(t *testing.T) {
+	srv := startServer(t)
+	defer srv.Close()
+	srv.HandleFunc("select ?", func(cmd *mysqltest.Command) []mysqltest.Result {
+		rs := mysqltest.Rows("?")
+		if cmd.Cmd == mysqltest.COM_STMT_EXECUTE {
+			rs.AddRow(cmd.Args...)
+		}
+		return []mysqltest.Result{rs}
+	})
+	p := newPool(srv, Config{MaxOpen: 2})
+	defer p.Close()
+	p.Register("SET NAMES utf8")
+	sel := p.Prepare("select ?")
+	ctx := context.Background()
+
+	c1, err := p.Get(ctx)
+	checkErr(t, err)
+	c2, err := p.Get(ctx)
+	checkErr(t, err)
+	checkStats(t, p, 2, 2, 0)
+	prepared := 0
+	for _, cmd := range srv.Commands() {
+		if cmd.Cmd == mysqltest.COM_STMT_PREPARE && cmd.Query == "select ?" {
+			prepared++
+		}
+	}
+	if q := srv.Queries(); len(q) != 2 || q[0] != "SET NAMES utf8" ||
+		prepared != 2 {
+		t.Fatalf("Bad commands: %q, %d", q, prepared)
+	}
+	stmt, err := c1.Stmt(sel)
+	checkErr(t, err)
+	row, _, err := stmt.ExecFirst(5)
+	checkErr(t, err)
+	if row.Int(0) != 5 {
+		t.Fatalf("Bad row: %v", row)
+	}
+
+	// Pool is exhausted
+	tctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, err = p.Get(tctx); err != context.DeadlineExceeded {
+		t.Fatal("Get doesn't wait for the connection:", err)
+	}
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		p.Put(c2)
+	}()
+	c3, err := p.Get(ctx)
+	checkErr(t, err)
+	if c3 != c2 {
+		t.Fatal("Returned connection wasn't reused")
+	}
+	if s := p.Stats(); s.WaitCount != 2 || s.WaitDuration < 30*time.Millisecond {
+		t.Fatalf("Bad wait stats: %+v", s)
+	}
+
+	p.Put(c1)
+	p.Put(c3)
+	checkStats(t, p, 2, 0, 2)
+	checkErr(t, p.Close())
+	checkStats(t, p, 0, 0, 0)
+	if _, err = p.Get(ctx); err != ErrClosed {
+		t.Fatal("Get from closed pool:", err)
+	}
+}
+
+func TestPing(t *testing.T) {
+	srv := startServer(t)
+	defer srv.Close()
+	p := newPool(srv, Config{})
+	defer p.Close()
+	ctx := context.Background()
+
+	c, err := p.Get(ctx)
+	checkErr(t, err)
+	p.Put(c)
+	srv.HandleCommand(mysqltest.COM_PING,
+		func(cmd *mysqltest.Command) []mysqltest.Result {
+			return []mysqltest.Result{mysqltest.Err(mysql.ER_UNKNOWN_ERROR, "dead")}
+		},
+	)
+	c2, err := p.Get(ctx)
+	checkErr(t, err)
+	if c2 == c {
+		t.Fatal("Connection with failed Ping was returned")
+	}
+	if s := p.Stats(); s.PingFailed != 1 || s.Open != 1 {
+		t.Fatalf("Bad stats: %+v", s)
+	}
+
+	// Closed connection isn't returned to the pool
+	c2.Close()
+	p.Put(c2)
+	checkStats(t, p, 0, 0, 0)
+}
+
+func TestPutPendingReply(t *testing.T) {
+	srv := startServer(t)
+	defer srv.Close()
+	srv.HandleQuery("select 1", mysqltest.Rows("1").AddRow(1).AddRow(1))
+	p := newPool(srv, Config{})
+	defer p.Close()
+	ctx := context.Background()
+
+	c, err := p.Get(ctx)
+	checkErr(t, err)
+	_, err = c.Start("select 1")
+	checkErr(t, err)
+	p.Put(c)
+	checkStats(t, p, 0, 0, 0)
+	if _, err = c.NetConn().Write([]byte{0}); err == nil {
+		t.Fatal("Connection with unread reply wasn't closed")
+	}
+	c2, err := p.Get(ctx)
+	checkErr(t, err)
+	if c2 == c {
+		t.Fatal("Connection with unread reply was reused")
+	}
+	c = c2
+	_, _, err = c.Query("select 1")
+	checkErr(t, err)
+	p.Put(c)
+	checkStats(t, p, 1, 0, 1)
+}
+
+func TestExpire(t *testing.T) {
+	srv := startServer(t)
+	defer srv.Close()
+	p := newPool(srv, Config{
+		MinIdle: 1, MaxLifetime: 50 * time.Millisecond, PingAfter: -1,
+	})
+	defer p.Close()
+
+	time.Sleep(40 * time.Millisecond)
+	checkStats(t, p, 1, 0, 1)
+	c, err := p.Get(context.Background())
+	checkErr(t, err)
+	time.Sleep(60 * time.Millisecond)
+	p.Put(c)
+	time.Sleep(40 * time.Millisecond)
+	s := p.Stats()
+	if s.Expired < 1 || s.Open != 1 || s.Idle != 1 {
+		t.Fatalf("Bad stats: %+v", s)
+	}
+}
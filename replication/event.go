@@ -0,0 +1,508 @@
+package replication
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ziutek/mymysql/mysql"
+	"github.com/ziutek/mymysql/native"
+)
+
+// EventType is the type of the binlog event.
+type EventType byte
+
+// Binlog event types
+const (
+	UNKNOWN_EVENT            EventType = 0
+	START_EVENT_V3           EventType = 1
+	QUERY_EVENT              EventType = 2
+	STOP_EVENT               EventType = 3
+	ROTATE_EVENT             EventType = 4
+	INTVAR_EVENT             EventType = 5
+	SLAVE_EVENT              EventType = 7
+	APPEND_BLOCK_EVENT       EventType = 9
+	DELETE_FILE_EVENT        EventType = 11
+	RAND_EVENT               EventType = 13
+	USER_VAR_EVENT           EventType = 14
+	FORMAT_DESCRIPTION_EVENT EventType = 15
+	XID_EVENT                EventType = 16
+	BEGIN_LOAD_QUERY_EVENT   EventType = 17
+	EXECUTE_LOAD_QUERY_EVENT EventType = 18
+	TABLE_MAP_EVENT          EventType = 19
+	WRITE_ROWS_EVENTv1       EventType = 23
+	UPDATE_ROWS_EVENTv1      EventType = 24
+	DELETE_ROWS_EVENTv1      EventType = 25
+	INCIDENT_EVENT           EventType = 26
+	HEARTBEAT_EVENT          EventType = 27
+	IGNORABLE_EVENT          EventType = 28
+	ROWS_QUERY_EVENT         EventType = 29
+	WRITE_ROWS_EVENTv2       EventType = 30
+	UPDATE_ROWS_EVENTv2      EventType = 31
+	DELETE_ROWS_EVENTv2      EventType = 32
+	GTID_EVENT               EventType = 33
+	ANONYMOUS_GTID_EVENT     EventType = 34
+	PREVIOUS_GTIDS_EVENT     EventType = 35
+)
+
+var eventNames = map[EventType]string{
+	UNKNOWN_EVENT:            "UNKNOWN_EVENT",
+	START_EVENT_V3:           "START_EVENT_V3",
+	QUERY_EVENT:              "QUERY_EVENT",
+	STOP_EVENT:               "STOP_EVENT",
+	ROTATE_EVENT:             "ROTATE_EVENT",
+	INTVAR_EVENT:             "INTVAR_EVENT",
+	SLAVE_EVENT:              "SLAVE_EVENT",
+	APPEND_BLOCK_EVENT:       "APPEND_BLOCK_EVENT",
+	DELETE_FILE_EVENT:        "DELETE_FILE_EVENT",
+	RAND_EVENT:               "RAND_EVENT",
+	USER_VAR_EVENT:           "USER_VAR_EVENT",
+	FORMAT_DESCRIPTION_EVENT: "FORMAT_DESCRIPTION_EVENT",
+	XID_EVENT:                "XID_EVENT",
+	BEGIN_LOAD_QUERY_EVENT:   "BEGIN_LOAD_QUERY_EVENT",
+	EXECUTE_LOAD_QUERY_EVENT: "EXECUTE_LOAD_QUERY_EVENT",
+	TABLE_MAP_EVENT:          "TABLE_MAP_EVENT",
+	WRITE_ROWS_EVENTv1:       "WRITE_ROWS_EVENTv1",
+	UPDATE_ROWS_EVENTv1:      "UPDATE_ROWS_EVENTv1",
+	DELETE_ROWS_EVENTv1:      "DELETE_ROWS_EVENTv1",
+	INCIDENT_EVENT:           "INCIDENT_EVENT",
+	HEARTBEAT_EVENT:          "HEARTBEAT_EVENT",
+	IGNORABLE_EVENT:          "IGNORABLE_EVENT",
+	ROWS_QUERY_EVENT:         "ROWS_QUERY_EVENT",
+	WRITE_ROWS_EVENTv2:       "WRITE_ROWS_EVENTv2",
+	UPDATE_ROWS_EVENTv2:      "UPDATE_ROWS_EVENTv2",
+	DELETE_ROWS_EVENTv2:      "DELETE_ROWS_EVENTv2",
+	GTID_EVENT:               "GTID_EVENT",
+	ANONYMOUS_GTID_EVENT:     "ANONYMOUS_GTID_EVENT",
+	PREVIOUS_GTIDS_EVENT:     "PREVIOUS_GTIDS_EVENT",
+}
+
+func (t EventType) String() string {
+	if name, ok := eventNames[t]; ok {
+		return name
+	}
+	return "EVENT_" + strconv.Itoa(int(t))
+}
+
+// Checksum algorithms
+const (
+	CHECKSUM_ALG_OFF   = 0
+	CHECKSUM_ALG_CRC32 = 1
+)
+
+const headerLen = 19
+
+// EventHeader is the common header of all events.
+type EventHeader struct {
+	Timestamp uint32
+	Type      EventType
+	ServerId  uint32
+	EventSize uint32
+	LogPos    uint32 // Position of the next event
+	Flags     uint16
+}
+
+func decodeHeader(data []byte) EventHeader {
+	return EventHeader{
+		Timestamp: native.DecodeU32(data[0:4]),
+		Type:      EventType(data[4]),
+		ServerId:  native.DecodeU32(data[5:9]),
+		EventSize: native.DecodeU32(data[9:13]),
+		LogPos:    native.DecodeU32(data[13:17]),
+		Flags:     native.DecodeU16(data[17:19]),
+	}
+}
+
+// Event is a binlog event.
+type Event struct {
+	Header EventHeader
+
+	// Decoded event: *FormatDescriptionEvent, *RotateEvent, *QueryEvent,
+	// *TableMapEvent, *RowsEvent, *XIDEvent, *GTIDEvent or nil for other
+	// event types.
+	Body interface{}
+
+	Raw []byte // Header and body without checksum
+}
+
+// FormatDescriptionEvent describes the format of the binlog. It is the
+// first event of every binlog file.
+type FormatDescriptionEvent struct {
+	BinlogVersion     uint16
+	ServerVersion     string
+	CreateTimestamp   uint32
+	HeaderLength      byte
+	PostHeaderLengths []byte // Indexed by event type - 1
+	ChecksumAlg       byte
+}
+
+// RotateEvent announces the next binlog file.
+type RotateEvent struct {
+	Position uint64
+	NextFile string
+}
+
+// QueryEvent contains a statement, for example BEGIN or DDL.
+type QueryEvent struct {
+	SlaveProxyId  uint32
+	ExecutionTime uint32
+	ErrorCode     uint16
+	StatusVars    []byte
+	Schema        string
+	Query         string
+}
+
+// XIDEvent commits a transaction.
+type XIDEvent struct {
+	XID uint64
+}
+
+// GTIDEvent starts a transaction with a global transaction identifier.
+type GTIDEvent struct {
+	Flags          byte
+	SID            [16]byte // Server UUID
+	GNO            int64    // Transaction number
+	LastCommitted  int64    // Logical clock (MySQL >= 5.7), 0 if absent
+	SequenceNumber int64
+}
+
+// String returns the GTID in the "uuid:gno" form.
+func (e *GTIDEvent) String() string {
+	return fmt.Sprintf("%s:%d", formatUUID(e.SID[:]), e.GNO)
+}
+
+// TableMapEvent describes the table of following rows events.
+type TableMapEvent struct {
+	TableId     uint64
+	Flags       uint16
+	Schema      string
+	Table       string
+	ColumnTypes []byte   // native.MYSQL_TYPE_*
+	ColumnMeta  []uint16 // Type specific metadata
+	Nullable    []bool
+
+	// Unsigned numeric columns. The signedness is known only if the server
+	// sends the optional metadata (MySQL >= 8.0.1 and MariaDB >= 10.5 with
+	// binlog_row_metadata=MINIMAL or FULL), otherwise all columns are signed.
+	Unsigned []bool
+}
+
+// RowsEvent contains rows changed by a statement.
+//
+// Values are decoded to the same Go types that the native engine returns
+// for the binary protocol, but integers are signed if the table map doesn't
+// contain the signedness (see TableMapEvent.Unsigned). DECIMAL values are
+// returned as exact mysql.Decimal, ENUM values as uint16 indexes, SET values
+// as uint64 bitmaps and JSON values in the binary format of the server.
+type RowsEvent struct {
+	Table *TableMapEvent
+	Flags uint16
+
+	// Columns in Rows (the row image can contain only some of them).
+	// Missing columns are nil.
+	Present []bool
+	// WRITE: inserted rows, DELETE: deleted rows, UPDATE: rows before update
+	Rows []mysql.Row
+
+	// UPDATE only: columns in After and rows after update
+	PresentAfter []bool
+	After        []mysql.Row
+}
+
+// reader decodes event data. It panics with ErrShortEvent if data is too
+// short, decode recovers it.
+type reader struct {
+	buf []byte
+}
+
+func (r *reader) next(n int) []byte {
+	if n < 0 || n > len(r.buf) {
+		panic(ErrShortEvent)
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *reader) byte() byte {
+	return r.next(1)[0]
+}
+
+func (r *reader) u16() uint16 {
+	return native.DecodeU16(r.next(2))
+}
+
+func (r *reader) u32() uint32 {
+	return native.DecodeU32(r.next(4))
+}
+
+func (r *reader) u64() uint64 {
+	return native.DecodeU64(r.next(8))
+}
+
+// uint reads n bytes little-endian integer.
+func (r *reader) uint(n int) uint64 {
+	var v uint64
+	for i, b := range r.next(n) {
+		v |= uint64(b) << (8 * uint(i))
+	}
+	return v
+}
+
+// lcb reads length coded binary integer.
+func (r *reader) lcb() uint64 {
+	switch b := r.byte(); b {
+	case 251:
+		return 0
+	case 252:
+		return uint64(r.u16())
+	case 253:
+		return r.uint(3)
+	case 254:
+		return r.u64()
+	default:
+		return uint64(b)
+	}
+}
+
+func (r *reader) bitmap(n int) []bool {
+	bits := r.next((n + 7) / 8)
+	bm := make([]bool, n)
+	for i := range bm {
+		bm[i] = bits[i/8]&(1<<uint(i%8)) != 0
+	}
+	return bm
+}
+
+func (s *Streamer) postHeaderLen(t EventType, def byte) byte {
+	if s.fde == nil || int(t) > len(s.fde.PostHeaderLengths) || t == 0 {
+		return def
+	}
+	return s.fde.PostHeaderLengths[t-1]
+}
+
+// decode decodes the body of the event.
+func (s *Streamer) decode(hdr *EventHeader, data []byte) (body interface{}, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			if e, ok := e.(error); ok {
+				err = e
+				return
+			}
+			panic(e)
+		}
+	}()
+
+	r := &reader{data}
+	switch hdr.Type {
+	case FORMAT_DESCRIPTION_EVENT:
+		return s.fde, nil
+	case ROTATE_EVENT:
+		ev := new(RotateEvent)
+		if s.postHeaderLen(hdr.Type, 8) >= 8 {
+			ev.Position = r.u64()
+		}
+		ev.NextFile = string(r.buf)
+		return ev, nil
+	case QUERY_EVENT:
+		return s.decodeQuery(r, s.postHeaderLen(hdr.Type, 13)), nil
+	case XID_EVENT:
+		return &XIDEvent{XID: r.u64()}, nil
+	case GTID_EVENT:
+		return decodeGTID(r), nil
+	case TABLE_MAP_EVENT:
+		return s.decodeTableMap(r, s.postHeaderLen(hdr.Type, 8)), nil
+	case WRITE_ROWS_EVENTv1, UPDATE_ROWS_EVENTv1, DELETE_ROWS_EVENTv1,
+		WRITE_ROWS_EVENTv2, UPDATE_ROWS_EVENTv2, DELETE_ROWS_EVENTv2:
+		return s.decodeRows(hdr.Type, r, s.postHeaderLen(hdr.Type, 10))
+	}
+	return nil, nil
+}
+
+func decodeFormatDescription(data []byte) (ev *FormatDescriptionEvent, err error) {
+	defer func() {
+		if recover() != nil {
+			err = ErrShortEvent
+		}
+	}()
+
+	r := &reader{data}
+	ev = new(FormatDescriptionEvent)
+	ev.BinlogVersion = r.u16()
+	ver := r.next(50)
+	if i := bytes.IndexByte(ver, 0); i >= 0 {
+		ver = ver[:i]
+	}
+	ev.ServerVersion = string(ver)
+	ev.CreateTimestamp = r.u32()
+	ev.HeaderLength = r.byte()
+	ev.PostHeaderLengths = r.buf
+	if hasChecksumAlg(ev.ServerVersion) {
+		// Checksum algorithm and the checksum of this event
+		n := len(r.buf) - 5
+		if n < 0 {
+			panic(ErrShortEvent)
+		}
+		ev.PostHeaderLengths = r.buf[:n]
+		ev.ChecksumAlg = r.buf[n]
+	}
+	return ev, nil
+}
+
+// hasChecksumAlg returns true if the format description event of the server
+// contains the checksum algorithm (MySQL >= 5.6.1).
+func hasChecksumAlg(version string) bool {
+	var v [3]int
+	for i, s := range strings.SplitN(version, ".", 3) {
+		if j := strings.IndexFunc(s, func(c rune) bool {
+			return c < '0' || c > '9'
+		}); j >= 0 {
+			s = s[:j]
+		}
+		v[i], _ = strconv.Atoi(s)
+	}
+	return v[0] > 5 || v[0] == 5 && (v[1] > 6 || v[1] == 6 && v[2] >= 1)
+}
+
+func (s *Streamer) decodeQuery(r *reader, post_len byte) *QueryEvent {
+	ev := new(QueryEvent)
+	ev.SlaveProxyId = r.u32()
+	ev.ExecutionTime = r.u32()
+	schema_len := int(r.byte())
+	ev.ErrorCode = r.u16()
+	vars_len := 0
+	if post_len >= 13 {
+		vars_len = int(r.u16())
+	}
+	ev.StatusVars = r.next(vars_len)
+	ev.Schema = string(r.next(schema_len))
+	r.byte() // 0x00
+	ev.Query = string(r.buf)
+	return ev
+}
+
+func decodeGTID(r *reader) *GTIDEvent {
+	ev := new(GTIDEvent)
+	ev.Flags = r.byte()
+	copy(ev.SID[:], r.next(16))
+	ev.GNO = int64(r.u64())
+	if len(r.buf) >= 17 && r.buf[0] == 2 {
+		// Logical timestamps
+		r.byte()
+		ev.LastCommitted = int64(r.u64())
+		ev.SequenceNumber = int64(r.u64())
+	}
+	return ev
+}
+
+func (s *Streamer) decodeTableMap(r *reader, post_len byte) *TableMapEvent {
+	ev := new(TableMapEvent)
+	if post_len == 6 {
+		ev.TableId = uint64(r.u32())
+	} else {
+		ev.TableId = r.uint(6)
+	}
+	ev.Flags = r.u16()
+	ev.Schema = string(r.next(int(r.byte())))
+	r.byte() // 0x00
+	ev.Table = string(r.next(int(r.byte())))
+	r.byte() // 0x00
+	n := int(r.lcb())
+	ev.ColumnTypes = r.next(n)
+	meta := &reader{r.next(int(r.lcb()))}
+	ev.ColumnMeta = make([]uint16, n)
+	for i, t := range ev.ColumnTypes {
+		switch t {
+		case native.MYSQL_TYPE_FLOAT, native.MYSQL_TYPE_DOUBLE,
+			native.MYSQL_TYPE_BLOB, native.MYSQL_TYPE_GEOMETRY,
+			native.MYSQL_TYPE_JSON, native.MYSQL_TYPE_TIMESTAMP2,
+			native.MYSQL_TYPE_DATETIME2, native.MYSQL_TYPE_TIME2:
+			ev.ColumnMeta[i] = uint16(meta.byte())
+		case native.MYSQL_TYPE_VARCHAR, native.MYSQL_TYPE_VAR_STRING,
+			native.MYSQL_TYPE_BIT:
+			ev.ColumnMeta[i] = meta.u16()
+		case native.MYSQL_TYPE_NEWDECIMAL, native.MYSQL_TYPE_STRING,
+			native.MYSQL_TYPE_ENUM, native.MYSQL_TYPE_SET:
+			// Big-endian
+			b := meta.next(2)
+			ev.ColumnMeta[i] = uint16(b[0])<<8 | uint16(b[1])
+		}
+	}
+	ev.Nullable = r.bitmap(n)
+	ev.Unsigned = make([]bool, n)
+	// Optional metadata (MySQL >= 8.0.1): type, length, value
+	for len(r.buf) > 0 {
+		typ := r.byte()
+		val := r.next(int(r.lcb()))
+		if typ != signednessMeta {
+			continue
+		}
+		// One bit (MSB first) for every numeric column
+		k := 0
+		for i, t := range ev.ColumnTypes {
+			if !isNumeric(t) {
+				continue
+			}
+			if k/8 >= len(val) {
+				panic(ErrShortEvent)
+			}
+			ev.Unsigned[i] = val[k/8]&(0x80>>uint(k%8)) != 0
+			k++
+		}
+	}
+	return ev
+}
+
+// Type of the optional table map metadata that contains the signedness
+const signednessMeta = 1
+
+func isNumeric(t byte) bool {
+	switch t {
+	case native.MYSQL_TYPE_TINY, native.MYSQL_TYPE_SHORT,
+		native.MYSQL_TYPE_INT24, native.MYSQL_TYPE_LONG,
+		native.MYSQL_TYPE_LONGLONG, native.MYSQL_TYPE_FLOAT,
+		native.MYSQL_TYPE_DOUBLE, native.MYSQL_TYPE_DECIMAL,
+		native.MYSQL_TYPE_NEWDECIMAL:
+		return true
+	}
+	return false
+}
+
+func (s *Streamer) decodeRows(t EventType, r *reader, post_len byte) (*RowsEvent, error) {
+	ev := new(RowsEvent)
+	var table_id uint64
+	if post_len == 6 {
+		table_id = uint64(r.u32())
+	} else {
+		table_id = r.uint(6)
+	}
+	ev.Flags = r.u16()
+	if t >= WRITE_ROWS_EVENTv2 {
+		// Extra data length includes its own two bytes
+		r.next(int(r.u16()) - 2)
+	}
+	if ev.Table = s.tables[table_id]; ev.Table == nil {
+		return nil, ErrNoTableMap
+	}
+	n := int(r.lcb())
+	ev.Present = r.bitmap(n)
+	update := t == UPDATE_ROWS_EVENTv1 || t == UPDATE_ROWS_EVENTv2
+	if update {
+		ev.PresentAfter = r.bitmap(n)
+	}
+	for len(r.buf) > 0 {
+		ev.Rows = append(ev.Rows, decodeRow(r, ev.Table, ev.Present))
+		if update {
+			ev.After = append(ev.After, decodeRow(r, ev.Table, ev.PresentAfter))
+		}
+	}
+	return ev, nil
+}
+
+func formatUUID(b []byte) string {
+	s := hex.EncodeToString(b)
+	return s[0:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" +
+		s[20:]
+}
@@ -0,0 +1,84 @@
+package replication
+
+import (
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/ziutek/mymysql/native"
+)
+
+var ErrBadGTIDSet = errors.New("replication: bad GTID set")
+
+// GTIDInterval is a range of transaction numbers [Start, End).
+type GTIDInterval struct {
+	Start, End int64
+}
+
+// GTIDSet is a set of transactions executed by servers (SID).
+type GTIDSet struct {
+	SID       [16]byte
+	Intervals []GTIDInterval
+}
+
+// ParseGTIDSet parses the MySQL GTID set, for example
+// "3E11FA47-71CA-11E1-9E33-C80AA9429562:1-5:7,uuid2:1-3".
+func ParseGTIDSet(s string) ([]GTIDSet, error) {
+	var sets []GTIDSet
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		fields := strings.Split(part, ":")
+		sid, err := hex.DecodeString(strings.Replace(fields[0], "-", "", -1))
+		if err != nil || len(sid) != 16 || len(fields) < 2 {
+			return nil, ErrBadGTIDSet
+		}
+		var set GTIDSet
+		copy(set.SID[:], sid)
+		for _, f := range fields[1:] {
+			var iv GTIDInterval
+			se := strings.SplitN(f, "-", 2)
+			if iv.Start, err = strconv.ParseInt(se[0], 10, 64); err != nil {
+				return nil, ErrBadGTIDSet
+			}
+			iv.End = iv.Start
+			if len(se) == 2 {
+				if iv.End, err = strconv.ParseInt(se[1], 10, 64); err != nil {
+					return nil, ErrBadGTIDSet
+				}
+			}
+			if iv.Start < 1 || iv.End < iv.Start {
+				return nil, ErrBadGTIDSet
+			}
+			iv.End++ // End is exclusive in the protocol
+			set.Intervals = append(set.Intervals, iv)
+		}
+		sets = append(sets, set)
+	}
+	return sets, nil
+}
+
+// encodeGTIDSets returns the binary form used by COM_BINLOG_DUMP_GTID.
+func encodeGTIDSets(sets []GTIDSet) []byte {
+	n := 8
+	for _, set := range sets {
+		n += 16 + 8 + 16*len(set.Intervals)
+	}
+	buf := make([]byte, n)
+	native.EncodeU64(buf, uint64(len(sets)))
+	b := buf[8:]
+	for _, set := range sets {
+		copy(b, set.SID[:])
+		native.EncodeU64(b[16:], uint64(len(set.Intervals)))
+		b = b[24:]
+		for _, iv := range set.Intervals {
+			native.EncodeU64(b, uint64(iv.Start))
+			native.EncodeU64(b[8:], uint64(iv.End))
+			b = b[16:]
+		}
+	}
+	return buf
+}
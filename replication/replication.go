@@ -0,0 +1,217 @@
+// Package replication implements a MySQL replication client. It registers a
+// native connection as a replica, streams binlog events using
+// COM_BINLOG_DUMP or COM_BINLOG_DUMP_GTID and decodes them.
+//
+// The source server must use binlog_format=ROW to obtain row changes. The
+// user needs the REPLICATION SLAVE privilege.
+//
+//	my := native.New("tcp", "", "127.0.0.1:3306", user, pass).(*native.Conn)
+//	if err := my.Connect(); err != nil {
+//		return err
+//	}
+//	s, err := replication.Start(my, &replication.Config{ServerId: 1001})
+//	if err != nil {
+//		return err
+//	}
+//	defer s.Close()
+//	for {
+//		ev, err := s.Next()
+//		if err != nil {
+//			return err
+//		}
+//		if rows, ok := ev.Body.(*replication.RowsEvent); ok {
+//			fmt.Println(rows.Table.Schema, rows.Table.Table, rows.Rows)
+//		}
+//	}
+package replication
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/ziutek/mymysql/native"
+)
+
+// Binlog dump flags
+const (
+	BINLOG_DUMP_NON_BLOCK = 0x01 // Send EOF instead of waiting for events
+	BINLOG_THROUGH_GTID   = 0x04 // COM_BINLOG_DUMP_GTID uses the GTID set
+)
+
+var (
+	ErrChecksum   = errors.New("replication: bad event checksum")
+	ErrShortEvent = errors.New("replication: event too short")
+	ErrNoTableMap = errors.New("replication: rows event without table map")
+)
+
+// Config specifies where the binlog stream starts.
+type Config struct {
+	// Server id of the replica. It must be unique among the source and all
+	// its replicas.
+	ServerId uint32
+
+	// Host and port reported by SHOW REPLICAS on the source (optional).
+	Host string
+	Port uint16
+
+	// User name and password reported in COM_REGISTER_SLAVE (optional). The
+	// source shows them in SHOW REPLICAS if --show-replica-auth-info is set.
+	// They aren't used for authentication and the credentials of the
+	// connection are never sent.
+	User   string
+	Passwd string
+
+	// Binlog file name and position of the first event. The stream starts at
+	// the beginning of the first available binlog if File is empty.
+	File string
+	Pos  uint32
+
+	// If not empty COM_BINLOG_DUMP_GTID is used and the server sends all
+	// transactions that aren't in this set (for example
+	// "3E11FA47-71CA-11E1-9E33-C80AA9429562:1-5").
+	GTIDSet string
+
+	// The server sends EOF (Next returns io.EOF) at the end of the binlog
+	// instead of waiting for new events.
+	NonBlock bool
+
+	// Interval of heartbeat events sent by the server if there are no other
+	// events (0: server default).
+	HeartbeatPeriod time.Duration
+}
+
+// Streamer reads binlog events from a connection.
+type Streamer struct {
+	my     *native.Conn
+	nc     net.Conn
+	fde    *FormatDescriptionEvent
+	crc    bool // Events end with CRC32 checksum
+	tables map[uint64]*TableMapEvent
+	file   string
+	pos    uint32
+}
+
+// Start registers my as a replica and requests the binlog stream. my must
+// be connected and can't be used for other commands until the stream ends.
+func Start(my *native.Conn, cfg *Config) (*Streamer, error) {
+	// Announce that we can handle checksums, otherwise the server refuses to
+	// send events with checksums. Older servers don't know this variable.
+	crc := false
+	_, _, err := my.Query("SET @master_binlog_checksum = @@global.binlog_checksum")
+	if err == nil {
+		// Checksum of events sent before the format description event
+		row, _, err := my.QueryFirst("SELECT @master_binlog_checksum")
+		if err != nil {
+			return nil, err
+		}
+		crc = row.Str(0) != "NONE"
+	}
+	if cfg.HeartbeatPeriod > 0 {
+		// Without params, so it works with interpolated connections too
+		_, _, err := my.Query("SET @master_heartbeat_period = " +
+			strconv.FormatInt(cfg.HeartbeatPeriod.Nanoseconds(), 10))
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = my.RegisterSlave(cfg.ServerId, cfg.Host, cfg.User, cfg.Passwd,
+		cfg.Port)
+	if err != nil {
+		return nil, err
+	}
+	var flags uint16
+	if cfg.NonBlock {
+		flags |= BINLOG_DUMP_NON_BLOCK
+	}
+	if cfg.GTIDSet != "" {
+		set, err := ParseGTIDSet(cfg.GTIDSet)
+		if err != nil {
+			return nil, err
+		}
+		err = my.BinlogDumpGTID(cfg.ServerId, cfg.File, uint64(cfg.Pos),
+			encodeGTIDSets(set), flags|BINLOG_THROUGH_GTID)
+	} else {
+		pos := cfg.Pos
+		if pos < 4 {
+			pos = 4 // Skip the magic number
+		}
+		err = my.BinlogDump(cfg.ServerId, cfg.File, pos, flags)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Streamer{
+		my:     my,
+		nc:     my.NetConn(),
+		crc:    crc,
+		tables: make(map[uint64]*TableMapEvent),
+		file:   cfg.File,
+		pos:    cfg.Pos,
+	}, nil
+}
+
+// Next reads and decodes the next event. It returns io.EOF at the end of the
+// binlog in non-blocking mode.
+func (s *Streamer) Next() (*Event, error) {
+	data, err := s.my.ReadBinlogEvent()
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < headerLen {
+		return nil, ErrShortEvent
+	}
+	ev := &Event{Header: decodeHeader(data)}
+	if ev.Header.Type == FORMAT_DESCRIPTION_EVENT {
+		// It describes all following events, including checksums
+		fde, err := decodeFormatDescription(data[headerLen:])
+		if err != nil {
+			return nil, err
+		}
+		s.fde = fde
+		s.crc = fde.ChecksumAlg == CHECKSUM_ALG_CRC32
+	}
+	if s.crc {
+		n := len(data) - 4
+		if n < headerLen {
+			return nil, ErrShortEvent
+		}
+		if crc32.ChecksumIEEE(data[:n]) != native.DecodeU32(data[n:]) {
+			return nil, ErrChecksum
+		}
+		data = data[:n]
+	}
+	ev.Raw = data
+	if ev.Body, err = s.decode(&ev.Header, data[headerLen:]); err != nil {
+		return nil, fmt.Errorf("replication: can't decode %s: %v",
+			ev.Header.Type, err)
+	}
+
+	if ev.Header.LogPos != 0 {
+		s.pos = ev.Header.LogPos
+	}
+	switch body := ev.Body.(type) {
+	case *RotateEvent:
+		s.file, s.pos = body.NextFile, uint32(body.Position)
+	case *TableMapEvent:
+		s.tables[body.TableId] = body
+	}
+	return ev, nil
+}
+
+// Position returns the binlog file and the position after the last event
+// returned by Next. It can be used as Config.File and Config.Pos to continue
+// the stream.
+func (s *Streamer) Position() (file string, pos uint32) {
+	return s.file, s.pos
+}
+
+// Close stops the stream by closing the network connection. It can be called
+// from another goroutine to interrupt Next. The connection can be used again
+// after Reconnect.
+func (s *Streamer) Close() error {
+	return s.nc.Close()
+}
@@ -0,0 +1,372 @@
+package replication
+
+import (
+	"bytes"
+	"hash/crc32"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ziutek/mymysql/mysql"
+	"github.com/ziutek/mymysql/mysqltest"
+	"github.com/ziutek/mymysql/native"
+)
+
+const (
+	user   = "testuser"
+	passwd = "TestPasswd9"
+)
+
+// event returns the binlog event with CRC32 checksum.
+func event(typ EventType, log_pos uint32, body ...[]byte) mysqltest.BinlogEvent {
+	data := make([]byte, headerLen)
+	for _, b := range body {
+		data = append(data, b...)
+	}
+	native.EncodeU32(data[0:], 1582979415)
+	data[4] = byte(typ)
+	native.EncodeU32(data[5:], 1)
+	native.EncodeU32(data[9:], uint32(len(data)+4))
+	native.EncodeU32(data[13:], log_pos)
+	var crc [4]byte
+	native.EncodeU32(crc[:], crc32.ChecksumIEEE(data))
+	return append(data, crc[:]...)
+}
+
+func le(v uint64, n int) []byte {
+	b := make([]byte, 8)
+	native.EncodeU64(b, v)
+	return b[:n]
+}
+
+func be(v uint64, n int) []byte {
+	b := make([]byte, n)
+	for i := n - 1; i >= 0; i-- {
+		b[i] = byte(v)
+		v >>= 8
+	}
+	return b
+}
+
+func str(s string) []byte {
+	return append([]byte{byte(len(s))}, s...)
+}
+
+func formatDescription() []byte {
+	post := make([]byte, 40)
+	post[ROTATE_EVENT-1] = 8
+	post[QUERY_EVENT-1] = 13
+	post[TABLE_MAP_EVENT-1] = 8
+	post[WRITE_ROWS_EVENTv2-1] = 10
+	post[UPDATE_ROWS_EVENTv2-1] = 10
+	post[GTID_EVENT-1] = 42
+	ver := make([]byte, 50)
+	copy(ver, "8.0.30-mysqltest")
+	b := append(le(4, 2), ver...)
+	b = append(b, le(0, 4)...)
+	b = append(b, headerLen)
+	b = append(b, post...)
+	return append(b, CHECKSUM_ALG_CRC32)
+}
+
+func datetime2(y, mon, d, h, m, s int) []byte {
+	ymd := uint64((y*13+mon)<<5 | d)
+	hms := uint64(h<<12 | m<<6 | s)
+	return be(ymd<<17|hms+0x8000000000, 5)
+}
+
+var sid = [16]byte{0x3e, 0x11, 0xfa, 0x47, 0x71, 0xca, 0x11, 0xe1, 0x9e,
+	0x33, 0xc8, 0x0a, 0xa9, 0x42, 0x95, 0x62}
+
+func binlog() []mysqltest.Result {
+	tm := [][]byte{
+		le(42, 6), le(1, 2), str("test"), {0}, str("t"), {0},
+		{5, native.MYSQL_TYPE_LONGLONG, native.MYSQL_TYPE_VARCHAR,
+			native.MYSQL_TYPE_NEWDECIMAL, native.MYSQL_TYPE_DATETIME2,
+			native.MYSQL_TYPE_TIME2},
+		{6, 100, 0, 10, 2, 0, 0},
+		{0x1e}, // Nullable except id
+	}
+	rows := [][]byte{le(42, 6), le(1, 2), le(2, 2), {5, 0x1f}}
+	row := [][]byte{
+		{0x10}, // NULL time
+		le(7, 8), str("abc"), {0x80, 0, 0x04, 0xd2, 0x38},
+		datetime2(2020, 2, 29, 12, 30, 15),
+	}
+	update := [][]byte{le(42, 6), le(1, 2), le(2, 2), {5, 0x03, 0x1f}}
+	before := [][]byte{{0x00}, le(7, 8), str("abc")}
+	after := [][]byte{
+		{0x08}, le(8, 8), str("xyz"), {0x7f, 0xff, 0xfb, 0x2d, 0xc7},
+		be(0x800000+(1<<12|30<<6), 3),
+	}
+	var rows_body, update_body []byte
+	for _, b := range append(rows, row...) {
+		rows_body = append(rows_body, b...)
+	}
+	for _, b := range append(append(update, before...), after...) {
+		update_body = append(update_body, b...)
+	}
+	gtid := append(append([]byte{1}, sid[:]...), le(23, 8)...)
+
+	return []mysqltest.Result{
+		event(ROTATE_EVENT, 0, le(4, 8), []byte("binlog.000001")),
+		event(FORMAT_DESCRIPTION_EVENT, 126, formatDescription()),
+		event(GTID_EVENT, 200, gtid),
+		event(QUERY_EVENT, 300, le(9, 4), le(0, 4), []byte{4}, le(0, 2),
+			le(0, 2), []byte("test\x00BEGIN")),
+		event(TABLE_MAP_EVENT, 400, tm...),
+		event(WRITE_ROWS_EVENTv2, 500, rows_body),
+		event(UPDATE_ROWS_EVENTv2, 600, update_body),
+		event(XID_EVENT, 700, le(99, 8)),
+		event(ROTATE_EVENT, 0, le(4, 8), []byte("binlog.000002")),
+	}
+}
+
+func startServer(t *testing.T) (*mysqltest.Server, *native.Conn) {
+	srv := &mysqltest.Server{User: user, Passwd: passwd}
+	if err := srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	srv.HandleQuery("SELECT @master_binlog_checksum",
+		mysqltest.Rows("@master_binlog_checksum").AddRow("CRC32"))
+	my := native.New("tcp", "", srv.Addr(), user, passwd).(*native.Conn)
+	if err := my.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	return srv, my
+}
+
+func TestStream(t *testing.T) {
+	srv, my := startServer(t)
+	defer srv.Close()
+	srv.HandleCommand(mysqltest.COM_BINLOG_DUMP,
+		func(cmd *mysqltest.Command) []mysqltest.Result {
+			return binlog()
+		},
+	)
+
+	s, err := Start(my, &Config{ServerId: 1001, NonBlock: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	var events []*Event
+	for {
+		ev, err := s.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, ev)
+	}
+	if len(events) != 9 {
+		t.Fatalf("Bad number of events: %d", len(events))
+	}
+	if rot := events[0].Body.(*RotateEvent); rot.NextFile != "binlog.000001" ||
+		rot.Position != 4 {
+		t.Fatalf("Bad rotate event: %+v", rot)
+	}
+	fde := events[1].Body.(*FormatDescriptionEvent)
+	if fde.ServerVersion != "8.0.30-mysqltest" ||
+		fde.ChecksumAlg != CHECKSUM_ALG_CRC32 {
+		t.Fatalf("Bad format description event: %+v", fde)
+	}
+	gtid := events[2].Body.(*GTIDEvent)
+	if gtid.String() != "3e11fa47-71ca-11e1-9e33-c80aa9429562:23" {
+		t.Fatalf("Bad GTID: %s", gtid)
+	}
+	if q := events[3].Body.(*QueryEvent); q.Schema != "test" ||
+		q.Query != "BEGIN" || q.SlaveProxyId != 9 {
+		t.Fatalf("Bad query event: %+v", q)
+	}
+	tm := events[4].Body.(*TableMapEvent)
+	if tm.TableId != 42 || tm.Schema != "test" || tm.Table != "t" ||
+		!reflect.DeepEqual(tm.ColumnMeta, []uint16{0, 100, 10<<8 | 2, 0, 0}) ||
+		tm.Nullable[0] || !tm.Nullable[1] {
+		t.Fatalf("Bad table map event: %+v", tm)
+	}
+
+	ins := events[5].Body.(*RowsEvent)
+	if ins.Table != tm || len(ins.Rows) != 1 || ins.After != nil {
+		t.Fatalf("Bad write rows event: %+v", ins)
+	}
+	exp := mysql.Row{int64(7), []byte("abc"), mysql.Decimal("1234.56"),
+		time.Date(2020, 2, 29, 12, 30, 15, 0, time.Local), nil}
+	if !reflect.DeepEqual(ins.Rows[0], exp) {
+		t.Fatalf("Bad inserted row: %#v", ins.Rows[0])
+	}
+
+	upd := events[6].Body.(*RowsEvent)
+	if len(upd.Rows) != 1 || len(upd.After) != 1 || upd.Present[2] ||
+		!upd.PresentAfter[4] {
+		t.Fatalf("Bad update rows event: %+v", upd)
+	}
+	exp = mysql.Row{int64(7), []byte("abc"), nil, nil, nil}
+	if !reflect.DeepEqual(upd.Rows[0], exp) {
+		t.Fatalf("Bad row before update: %#v", upd.Rows[0])
+	}
+	exp = mysql.Row{int64(8), []byte("xyz"), mysql.Decimal("-1234.56"), nil,
+		90 * time.Minute}
+	if !reflect.DeepEqual(upd.After[0], exp) {
+		t.Fatalf("Bad row after update: %#v", upd.After[0])
+	}
+	if xid := events[7].Body.(*XIDEvent); xid.XID != 99 {
+		t.Fatalf("Bad XID: %d", xid.XID)
+	}
+	if file, pos := s.Position(); file != "binlog.000002" || pos != 4 {
+		t.Fatalf("Bad position: %s:%d", file, pos)
+	}
+
+	cmds := srv.Commands()
+	dump := cmds[len(cmds)-1]
+	if cmds[len(cmds)-2].Cmd != mysqltest.COM_REGISTER_SLAVE ||
+		dump.Cmd != mysqltest.COM_BINLOG_DUMP ||
+		native.DecodeU32(dump.Data[0:]) != 4 ||
+		native.DecodeU16(dump.Data[4:]) != BINLOG_DUMP_NON_BLOCK ||
+		native.DecodeU32(dump.Data[6:]) != 1001 {
+		t.Fatalf("Bad commands: %+v", cmds)
+	}
+	// The password of the connection isn't reported to the source
+	if reg := cmds[len(cmds)-2]; bytes.Contains(reg.Data, []byte(passwd)) ||
+		bytes.Contains(reg.Data, []byte(user)) {
+		t.Fatalf("Credentials sent in COM_REGISTER_SLAVE: %q", reg.Data)
+	}
+}
+
+func TestBadChecksum(t *testing.T) {
+	srv, my := startServer(t)
+	defer srv.Close()
+	srv.HandleCommand(mysqltest.COM_BINLOG_DUMP,
+		func(cmd *mysqltest.Command) []mysqltest.Result {
+			ev := event(XID_EVENT, 700, le(99, 8))
+			ev[headerLen]++
+			return []mysqltest.Result{ev}
+		},
+	)
+	s, err := Start(my, &Config{ServerId: 1001, NonBlock: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, err = s.Next(); err != ErrChecksum {
+		t.Fatalf("Bad checksum accepted: %v", err)
+	}
+}
+
+func TestGTID(t *testing.T) {
+	srv, my := startServer(t)
+	defer srv.Close()
+	srv.HandleCommand(mysqltest.COM_BINLOG_DUMP_GTID,
+		func(cmd *mysqltest.Command) []mysqltest.Result {
+			return []mysqltest.Result{mysqltest.Delay(time.Hour)}
+		},
+	)
+	set := "3E11FA47-71CA-11E1-9E33-C80AA9429562:1-5:7"
+	s, err := Start(my, &Config{ServerId: 1001, GTIDSet: set})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Server waits for new events
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		s.Close()
+	}()
+	if _, err = s.Next(); err == nil {
+		t.Fatal("Event from closed stream")
+	}
+
+	sets, err := ParseGTIDSet(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp := []GTIDSet{{SID: sid, Intervals: []GTIDInterval{{1, 6}, {7, 8}}}}
+	if !reflect.DeepEqual(sets, exp) {
+		t.Fatalf("Bad GTID set: %+v", sets)
+	}
+	cmds := srv.Commands()
+	dump := cmds[len(cmds)-1]
+	if dump.Cmd != mysqltest.COM_BINLOG_DUMP_GTID ||
+		!bytes.HasSuffix(dump.Data, encodeGTIDSets(sets)) {
+		t.Fatalf("Bad dump command: %+v", dump)
+	}
+	if _, err = ParseGTIDSet("3E11FA47:1-5"); err != ErrBadGTIDSet {
+		t.Fatalf("Bad GTID set accepted: %v", err)
+	}
+}
+
+func TestDecimal(t *testing.T) {
+	for _, c := range []struct {
+		precision, scale int
+		data             []byte
+		exp              string
+	}{
+		{10, 2, []byte{0x80, 0, 0x04, 0xd2, 0x38}, "1234.56"},
+		{10, 2, []byte{0x7f, 0xff, 0xfb, 0x2d, 0xc7}, "-1234.56"},
+		{14, 4, []byte{0x81, 0x0d, 0xfb, 0x38, 0xd2, 0x04, 0xd2}, "1234567890.1234"},
+		{4, 4, []byte{0x80, 0x00}, "0.0000"},
+	} {
+		if s := decodeDecimal(&reader{c.data}, c.precision, c.scale); s != c.exp {
+			t.Errorf("%x: %s != %s", c.data, s, c.exp)
+		}
+	}
+}
+
+func TestUnsigned(t *testing.T) {
+	var body []byte
+	for _, b := range [][]byte{
+		le(42, 6), le(1, 2), str("test"), {0}, str("t"), {0},
+		{4, native.MYSQL_TYPE_LONGLONG, native.MYSQL_TYPE_VARCHAR,
+			native.MYSQL_TYPE_LONGLONG, native.MYSQL_TYPE_TINY},
+		{2, 100, 0},
+		{0x00},
+		{4, 2, 1, 'a'}, // COLUMN_NAME (ignored)
+		{1, 1, 0xa0},   // SIGNEDNESS: 1st and 3rd numeric columns
+	} {
+		body = append(body, b...)
+	}
+	tm := new(Streamer).decodeTableMap(&reader{body}, 8)
+	if !reflect.DeepEqual(tm.Unsigned, []bool{true, false, false, true}) {
+		t.Fatalf("Bad signedness: %v", tm.Unsigned)
+	}
+	var row []byte
+	for _, b := range [][]byte{{0x00}, le(1<<63+5, 8), str("abc"),
+		le(1<<64-1, 8), {0xff}} {
+
+		row = append(row, b...)
+	}
+	exp := mysql.Row{uint64(1<<63 + 5), []byte("abc"), int64(-1), uint8(255)}
+	r := decodeRow(&reader{row}, tm, []bool{true, true, true, true})
+	if !reflect.DeepEqual(r, exp) {
+		t.Fatalf("Bad row: %#v", r)
+	}
+}
+
+func TestHeartbeatInterpolated(t *testing.T) {
+	srv, my := startServer(t)
+	defer srv.Close()
+	srv.HandleCommand(mysqltest.COM_BINLOG_DUMP,
+		func(cmd *mysqltest.Command) []mysqltest.Result {
+			return []mysqltest.Result{event(XID_EVENT, 700, le(99, 8))}
+		},
+	)
+	my.InterpolateParams(true)
+	s, err := Start(my, &Config{
+		ServerId:        1001,
+		NonBlock:        true,
+		HeartbeatPeriod: 2 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	found := false
+	for _, q := range srv.Queries() {
+		found = found || q == "SET @master_heartbeat_period = 2000000000"
+	}
+	if !found {
+		t.Fatalf("Heartbeat period not set: %q", srv.Queries())
+	}
+}
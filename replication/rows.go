@@ -0,0 +1,290 @@
+package replication
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ziutek/mymysql/mysql"
+	"github.com/ziutek/mymysql/native"
+)
+
+// decodeRow decodes one row image. Only present columns are stored.
+func decodeRow(r *reader, table *TableMapEvent, present []bool) mysql.Row {
+	n := 0
+	for _, p := range present {
+		if p {
+			n++
+		}
+	}
+	nulls := r.bitmap(n)
+	row := make(mysql.Row, len(present))
+	i := 0
+	for col, p := range present {
+		if !p {
+			continue
+		}
+		if !nulls[i] {
+			row[col] = decodeValue(r, table.ColumnTypes[col],
+				table.ColumnMeta[col], table.Unsigned[col])
+		}
+		i++
+	}
+	return row
+}
+
+func decodeValue(r *reader, typ byte, meta uint16, unsigned bool) interface{} {
+	length := 0
+	if typ == native.MYSQL_TYPE_STRING && meta >= 256 {
+		// Real type and length are packed in the metadata
+		b0, b1 := byte(meta>>8), byte(meta)
+		if b0&0x30 != 0x30 {
+			length = int(b1) | int((b0&0x30)^0x30)<<4
+			typ = b0 | 0x30
+		} else {
+			typ = b0
+			length = int(b1)
+		}
+	} else if typ == native.MYSQL_TYPE_STRING {
+		length = int(meta)
+	}
+
+	switch typ {
+	case native.MYSQL_TYPE_TINY:
+		if unsigned {
+			return r.byte()
+		}
+		return int8(r.byte())
+	case native.MYSQL_TYPE_SHORT:
+		if unsigned {
+			return r.u16()
+		}
+		return int16(r.u16())
+	case native.MYSQL_TYPE_INT24:
+		if unsigned {
+			return uint32(r.uint(3))
+		}
+		v := int32(r.uint(3))
+		if v&0x800000 != 0 {
+			v -= 1 << 24
+		}
+		return v
+	case native.MYSQL_TYPE_LONG:
+		if unsigned {
+			return r.u32()
+		}
+		return int32(r.u32())
+	case native.MYSQL_TYPE_LONGLONG:
+		if unsigned {
+			return r.u64()
+		}
+		return int64(r.u64())
+	case native.MYSQL_TYPE_FLOAT:
+		return math.Float32frombits(r.u32())
+	case native.MYSQL_TYPE_DOUBLE:
+		return math.Float64frombits(r.u64())
+	case native.MYSQL_TYPE_YEAR:
+		if y := r.byte(); y != 0 {
+			return int16(y) + 1900
+		}
+		return int16(0)
+	case native.MYSQL_TYPE_NEWDECIMAL:
+		return mysql.Decimal(decodeDecimal(r, int(meta>>8), int(meta&0xff)))
+	case native.MYSQL_TYPE_DATE:
+		v := r.uint(3)
+		return mysql.Date{
+			Year:  int16(v >> 9),
+			Month: byte(v >> 5 & 15),
+			Day:   byte(v & 31),
+		}
+	case native.MYSQL_TYPE_TIME:
+		v := int64(r.uint(3))
+		if v&0x800000 != 0 {
+			v -= 1 << 24
+		}
+		neg := v < 0
+		if neg {
+			v = -v
+		}
+		d := time.Duration(v/10000*3600+v/100%100*60+v%100) * time.Second
+		if neg {
+			d = -d
+		}
+		return d
+	case native.MYSQL_TYPE_TIME2:
+		return decodeTime2(r, int(meta))
+	case native.MYSQL_TYPE_DATETIME:
+		v := r.u64()
+		if v == 0 {
+			return time.Time{}
+		}
+		d, t := int(v/1000000), int(v%1000000)
+		return time.Date(d/10000, time.Month(d/100%100), d%100,
+			t/10000, t/100%100, t%100, 0, time.Local)
+	case native.MYSQL_TYPE_DATETIME2:
+		return decodeDatetime2(r, int(meta))
+	case native.MYSQL_TYPE_TIMESTAMP:
+		if sec := r.u32(); sec != 0 {
+			return time.Unix(int64(sec), 0)
+		}
+		return time.Time{}
+	case native.MYSQL_TYPE_TIMESTAMP2:
+		sec := bigEndian(r.next(4))
+		usec := decodeFrac(r, int(meta))
+		if sec == 0 && usec == 0 {
+			return time.Time{}
+		}
+		return time.Unix(int64(sec), usec*1000)
+	case native.MYSQL_TYPE_VARCHAR, native.MYSQL_TYPE_VAR_STRING:
+		if meta < 256 {
+			return r.next(int(r.byte()))
+		}
+		return r.next(int(r.u16()))
+	case native.MYSQL_TYPE_STRING:
+		if length < 256 {
+			return r.next(int(r.byte()))
+		}
+		return r.next(int(r.u16()))
+	case native.MYSQL_TYPE_ENUM:
+		return uint16(r.uint(length))
+	case native.MYSQL_TYPE_SET:
+		return r.uint(length)
+	case native.MYSQL_TYPE_BIT:
+		nbits := int(meta>>8)*8 + int(meta&0xff)
+		return r.next((nbits + 7) / 8)
+	case native.MYSQL_TYPE_BLOB, native.MYSQL_TYPE_GEOMETRY,
+		native.MYSQL_TYPE_JSON:
+		return r.next(int(r.uint(int(meta))))
+	}
+	panic(mysql.ErrUnkMySQLType)
+}
+
+func bigEndian(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+// decodeFrac reads the fractional part of TIME2, DATETIME2, TIMESTAMP2 and
+// returns microseconds.
+func decodeFrac(r *reader, fsp int) int64 {
+	switch fsp {
+	case 1, 2:
+		return int64(r.byte()) * 10000
+	case 3, 4:
+		return int64(bigEndian(r.next(2))) * 100
+	case 5, 6:
+		return int64(bigEndian(r.next(3)))
+	}
+	return 0
+}
+
+func decodeDatetime2(r *reader, fsp int) time.Time {
+	v := int64(bigEndian(r.next(5))) - 0x8000000000
+	usec := decodeFrac(r, fsp)
+	if v == 0 && usec == 0 {
+		return time.Time{}
+	}
+	ymd := v >> 17
+	ym := ymd >> 5
+	hms := v % (1 << 17)
+	return time.Date(int(ym/13), time.Month(ym%13), int(ymd%(1<<5)),
+		int(hms>>12), int(hms>>6%(1<<6)), int(hms%(1<<6)), int(usec)*1000,
+		time.Local)
+}
+
+func decodeTime2(r *reader, fsp int) time.Duration {
+	var packed int64
+	switch fsp {
+	case 1, 2, 3, 4:
+		intpart := int64(bigEndian(r.next(3))) - 0x800000
+		var frac int64
+		if fsp <= 2 {
+			frac = int64(int8(r.byte()))
+			if intpart < 0 && frac != 0 {
+				intpart++
+				frac -= 0x100
+			}
+			frac *= 10000
+		} else {
+			frac = int64(int16(bigEndian(r.next(2))))
+			if intpart < 0 && frac != 0 {
+				intpart++
+				frac -= 0x10000
+			}
+			frac *= 100
+		}
+		packed = intpart<<24 + frac
+	case 5, 6:
+		packed = int64(bigEndian(r.next(6))) - 0x800000000000
+	default:
+		packed = (int64(bigEndian(r.next(3))) - 0x800000) << 24
+	}
+	neg := packed < 0
+	if neg {
+		packed = -packed
+	}
+	hms := packed >> 24
+	d := time.Duration(hms>>12%(1<<10))*time.Hour +
+		time.Duration(hms>>6%(1<<6))*time.Minute +
+		time.Duration(hms%(1<<6))*time.Second +
+		time.Duration(packed%(1<<24))*time.Microsecond
+	if neg {
+		d = -d
+	}
+	return d
+}
+
+var dig2bytes = [10]int{0, 1, 1, 2, 2, 3, 3, 4, 4, 4}
+
+// decodeDecimal decodes the binary DECIMAL(precision, scale) to text.
+func decodeDecimal(r *reader, precision, scale int) string {
+	intg := precision - scale
+	intg0, intg0x := intg/9, intg%9
+	frac0, frac0x := scale/9, scale%9
+	size := intg0*4 + dig2bytes[intg0x] + frac0*4 + dig2bytes[frac0x]
+	buf := append([]byte(nil), r.next(size)...)
+	neg := buf[0]&0x80 == 0
+	buf[0] ^= 0x80
+	if neg {
+		for i := range buf {
+			buf[i] ^= 0xff
+		}
+	}
+	d := &reader{buf}
+	var sb strings.Builder
+	if neg {
+		sb.WriteByte('-')
+	}
+	var ip strings.Builder
+	if intg0x > 0 {
+		ip.WriteString(strconv.FormatUint(bigEndian(d.next(dig2bytes[intg0x])), 10))
+	}
+	for i := 0; i < intg0; i++ {
+		v := strconv.FormatUint(bigEndian(d.next(4)), 10)
+		if ip.Len() > 0 {
+			v = strings.Repeat("0", 9-len(v)) + v
+		}
+		ip.WriteString(v)
+	}
+	s := strings.TrimLeft(ip.String(), "0")
+	if s == "" {
+		s = "0"
+	}
+	sb.WriteString(s)
+	if scale > 0 {
+		sb.WriteByte('.')
+		for i := 0; i < frac0; i++ {
+			v := strconv.FormatUint(bigEndian(d.next(4)), 10)
+			sb.WriteString(strings.Repeat("0", 9-len(v)) + v)
+		}
+		if frac0x > 0 {
+			v := strconv.FormatUint(bigEndian(d.next(dig2bytes[frac0x])), 10)
+			sb.WriteString(strings.Repeat("0", frac0x-len(v)) + v)
+		}
+	}
+	return sb.String()
+}
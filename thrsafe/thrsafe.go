@@ -10,6 +10,7 @@
 package thrsafe
 
 import (
+	"context"
 	"io"
 	"sync"
 	"time"
@@ -25,11 +26,18 @@ type Conn struct {
 
 	stopPinger chan struct{}
 	lastUsed   time.Time
+	metrics    mysql.Metrics
 }
 
 func (c *Conn) lock() {
 	//log.Println(c, ":: lock @", c.mutex)
+	if c.metrics == nil {
+		c.mutex.Lock()
+		return
+	}
+	start := time.Now()
 	c.mutex.Lock()
+	c.metrics.Observe(mysql.LockWait, time.Since(start))
 }
 
 func (c *Conn) unlock() {
@@ -42,6 +50,9 @@ func (c *Conn) unlock() {
 type Result struct {
 	mysql.Result
 	conn *Conn
+
+	cursor bool // Rows are fetched from a server side cursor
+	locked bool // Connection is locked until all rows are read
 }
 
 // Stmt is a thread safe statement type.
@@ -66,18 +77,27 @@ func New(proto, laddr, raddr, user, passwd string, db ...string) mysql.Conn {
 
 func (c *Conn) Clone() mysql.Conn {
 	return &Conn{
-		Conn:  c.Conn.Clone(),
-		mutex: new(sync.Mutex),
+		Conn:    c.Conn.Clone(),
+		mutex:   new(sync.Mutex),
+		metrics: c.metrics,
 	}
 }
 
-func (c *Conn) pinger() {
+// SetMetrics works like native.Conn.SetMetrics but additionally the time
+// spent waiting for the connection lock is recorded in mysql.LockWait
+// histogram. It should be called before the connection is shared.
+func (c *Conn) SetMetrics(m mysql.Metrics) {
+	c.metrics = m
+	c.Conn.SetMetrics(m)
+}
+
+func (c *Conn) pinger(stop <-chan struct{}) {
 	const to = 60 * time.Second
 	sleep := to
 	for {
 		timer := time.After(sleep)
 		select {
-		case <-c.stopPinger:
+		case <-stop:
 			return
 		case t := <-timer:
 			c.mutex.Lock()
@@ -94,19 +114,29 @@ func (c *Conn) pinger() {
 	}
 }
 
+func (c *Conn) startPinger() {
+	if c.stopPinger == nil {
+		c.stopPinger = make(chan struct{})
+		go c.pinger(c.stopPinger)
+	}
+}
+
 func (c *Conn) Connect() error {
 	//log.Println("Connect")
 	c.lock()
 	defer c.unlock()
-	c.stopPinger = make(chan struct{})
-	go c.pinger()
+	c.startPinger()
 	return c.Conn.Connect()
 }
 
 // Close closes the connection.
 func (c *Conn) Close() error {
 	//log.Println("Close")
-	close(c.stopPinger) // Stop pinger before lock connection
+	if c.stopPinger != nil {
+		// Stop pinger before lock connection
+		close(c.stopPinger)
+		c.stopPinger = nil
+	}
 	c.lock()
 	defer c.unlock()
 	return c.Conn.Close()
@@ -116,9 +146,7 @@ func (c *Conn) Reconnect() error {
 	//log.Println("Reconnect")
 	c.lock()
 	defer c.unlock()
-	if c.stopPinger == nil {
-		go c.pinger()
-	}
+	c.startPinger()
 	return c.Conn.Reconnect()
 }
 
@@ -129,6 +157,20 @@ func (c *Conn) Use(dbname string) error {
 	return c.Conn.Use(dbname)
 }
 
+func (c *Conn) ResetSession() error {
+	//log.Println("ResetSession")
+	c.lock()
+	defer c.unlock()
+	return c.Conn.ResetSession()
+}
+
+func (c *Conn) ChangeUser(user, passwd, dbname string) error {
+	//log.Println("ChangeUser")
+	c.lock()
+	defer c.unlock()
+	return c.Conn.ChangeUser(user, passwd, dbname)
+}
+
 func (c *Conn) Start(sql string, params ...interface{}) (mysql.Result, error) {
 	//log.Println("Start")
 	c.lock()
@@ -138,10 +180,27 @@ func (c *Conn) Start(sql string, params ...interface{}) (mysql.Result, error) {
 		c.unlock()
 		return nil, err
 	}
-	if res.StatusOnly() && !res.MoreResults() {
+	locked := !res.StatusOnly() || res.MoreResults()
+	if !locked {
+		c.unlock()
+	}
+	return &Result{Result: res, conn: c, locked: locked}, err
+}
+
+func (c *Conn) StartContext(ctx context.Context, sql string, params ...interface{}) (mysql.Result, error) {
+	//log.Println("StartContext")
+	c.lock()
+	res, err := c.Conn.StartContext(ctx, sql, params...)
+	// Unlock if error or OK result (which doesn't provide any fields)
+	if err != nil {
+		c.unlock()
+		return nil, err
+	}
+	locked := !res.StatusOnly() || res.MoreResults()
+	if !locked {
 		c.unlock()
 	}
-	return &Result{Result: res, conn: c}, err
+	return &Result{Result: res, conn: c, locked: locked}, err
 }
 
 func (c *Conn) Status() mysql.ConnStatus {
@@ -150,12 +209,30 @@ func (c *Conn) Status() mysql.ConnStatus {
 	return c.Conn.Status()
 }
 
+func (c *Conn) CurrentSchema() string {
+	c.lock()
+	defer c.unlock()
+	return c.Conn.CurrentSchema()
+}
+
+func (c *Conn) LastGTIDs() string {
+	c.lock()
+	defer c.unlock()
+	return c.Conn.LastGTIDs()
+}
+
 func (c *Conn) Escape(txt string) string {
 	return mysql.Escape(c, txt)
 }
 
 func (res *Result) ScanRow(row mysql.Row) error {
 	//log.Println("ScanRow")
+	if res.cursor {
+		// Connection is locked only for fetching
+		res.conn.lock()
+		defer res.conn.unlock()
+		return res.Result.ScanRow(row)
+	}
 	err := res.Result.ScanRow(row)
 	if err == nil {
 		// There are more rows to read
@@ -169,6 +246,7 @@ func (res *Result) ScanRow(row mysql.Row) error {
 		// Error or no more rows in not empty result set and no more resutls.
 		// In case if empty result set and no more resutls Start has unlocked
 		// it before.
+		res.locked = false
 		res.conn.unlock()
 	}
 	return err
@@ -182,15 +260,31 @@ func (res *Result) NextResult() (mysql.Result, error) {
 	//log.Println("NextResult")
 	next, err := res.Result.NextResult()
 	if err != nil {
+		res.locked = false
+		res.conn.unlock()
 		return nil, err
 	}
 	if next == nil {
 		return nil, nil
 	}
-	if next.StatusOnly() && !next.MoreResults() {
+	// The lock is passed to the next result
+	res.locked = false
+	locked := !next.StatusOnly() || next.MoreResults()
+	if !locked {
 		res.conn.unlock()
 	}
-	return &Result{next, res.conn}, nil
+	return &Result{Result: next, conn: res.conn, locked: locked}, nil
+}
+
+func (res *Result) Warnings() ([]mysql.Warning, error) {
+	if res.locked {
+		// The connection is already locked by this result (native
+		// connection returns mysql.ErrUnreadedReply)
+		return res.Result.Warnings()
+	}
+	res.conn.lock()
+	defer res.conn.unlock()
+	return res.Result.Warnings()
 }
 
 func (c *Conn) Ping() error {
@@ -219,10 +313,37 @@ func (stmt *Stmt) Run(params ...interface{}) (mysql.Result, error) {
 		stmt.conn.unlock()
 		return nil, err
 	}
-	if res.StatusOnly() && !res.MoreResults() {
+	if isCursor(stmt.conn, res) {
+		// Rows will be fetched from the cursor, the connection is free
+		stmt.conn.unlock()
+		return &Result{Result: res, conn: stmt.conn, cursor: true}, nil
+	}
+	locked := !res.StatusOnly() || res.MoreResults()
+	if !locked {
+		stmt.conn.unlock()
+	}
+	return &Result{Result: res, conn: stmt.conn, locked: locked}, nil
+}
+
+func (stmt *Stmt) RunContext(ctx context.Context, params ...interface{}) (mysql.Result, error) {
+	//log.Println("RunContext")
+	stmt.conn.lock()
+	res, err := stmt.Stmt.RunContext(ctx, params...)
+	// Unlock if error or OK result (which doesn't provide any fields)
+	if err != nil {
+		stmt.conn.unlock()
+		return nil, err
+	}
+	if isCursor(stmt.conn, res) {
+		// Rows will be fetched from the cursor, the connection is free
+		stmt.conn.unlock()
+		return &Result{Result: res, conn: stmt.conn, cursor: true}, nil
+	}
+	locked := !res.StatusOnly() || res.MoreResults()
+	if !locked {
 		stmt.conn.unlock()
 	}
-	return &Result{Result: res, conn: stmt.conn}, nil
+	return &Result{Result: res, conn: stmt.conn, locked: locked}, nil
 }
 
 func (stmt *Stmt) Delete() error {
@@ -278,9 +399,19 @@ func (stmt *Stmt) ExecLast(params ...interface{}) (mysql.Row, mysql.Result, erro
 
 // End: See mysql.End
 func (res *Result) End() error {
+	if res.cursor {
+		res.conn.lock()
+		defer res.conn.unlock()
+		return res.Result.End()
+	}
 	return mysql.End(res)
 }
 
+func isCursor(c *Conn, res mysql.Result) bool {
+	return !res.StatusOnly() &&
+		c.Conn.Status()&mysql.SERVER_STATUS_CURSOR_EXISTS != 0
+}
+
 // GetFirstRow: See mysql.GetFirstRow
 func (res *Result) GetFirstRow() (mysql.Row, error) {
 	return mysql.GetFirstRow(res)
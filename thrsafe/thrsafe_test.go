@@ -2,8 +2,10 @@ package thrsafe
 
 import (
 	"github.com/ziutek/mymysql/mysql"
+	"github.com/ziutek/mymysql/mysqltest"
 	"github.com/ziutek/mymysql/native"
 	"testing"
+	"time"
 )
 
 const (
@@ -134,3 +136,45 @@ func TestSSDDD(t *testing.T) {
 	err = db.Close()
 	checkErr(t, err)
 }
+
+func TestFakeWarningsPending(t *testing.T) {
+	srv := &mysqltest.Server{User: user, Passwd: passwd}
+	checkErr(t, srv.Start())
+	defer srv.Close()
+	rs := mysqltest.Rows("a").AddRow(1).AddRow(2)
+	rs.Warnings = 1
+	srv.HandleQuery("select a from t", rs)
+	srv.HandleQuery("SHOW WARNINGS", mysqltest.Rows("Level", "Code", "Message").
+		AddRow("Warning", 1292, "Truncated incorrect value"))
+
+	db := New("tcp", "", srv.Addr(), user, passwd)
+	checkErr(t, db.Connect())
+	defer db.Close()
+	res, err := db.Start("select a from t")
+	checkErr(t, err)
+	done := make(chan error, 1)
+	go func() {
+		_, err := res.Warnings()
+		done <- err
+	}()
+	select {
+	case err = <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Warnings deadlocked")
+	}
+	if err != mysql.ErrUnreadedReply {
+		t.Fatal("Bad error:", err)
+	}
+	for {
+		row, err := res.GetRow()
+		checkErr(t, err)
+		if row == nil {
+			break
+		}
+	}
+	w, err := res.Warnings()
+	checkErr(t, err)
+	if len(w) != 1 || w[0].Code != 1292 {
+		t.Fatalf("Bad warnings: %+v", w)
+	}
+}